@@ -0,0 +1,275 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/pkg/errors"
+	"io/ioutil"
+	"k8s.io/gengo/types"
+	"k8s.io/klog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sigs.k8s.io/yaml"
+	"sort"
+	"strings"
+)
+
+// crdManifest is the subset of an apiextensions.k8s.io CustomResourceDefinition
+// (v1 or v1beta1) needed to generate the types of its versions.
+type crdManifest struct {
+	Kind string `json:"kind"`
+	Spec struct {
+		Group string `json:"group"`
+		Names struct {
+			Kind string `json:"kind"`
+		} `json:"names"`
+		Versions []struct {
+			Name   string         `json:"name"`
+			Schema *crdValidation `json:"schema"`
+		} `json:"versions"`
+		// Validation is the v1beta1 schema shared by all versions.
+		Validation *crdValidation `json:"validation"`
+	} `json:"spec"`
+}
+
+type crdValidation struct {
+	OpenAPIV3Schema *crdSchema `json:"openAPIV3Schema"`
+}
+
+// crdSchema is the subset of an OpenAPI v3 schema used to build types.
+type crdSchema struct {
+	Type                 string                `json:"type"`
+	Description          string                `json:"description"`
+	Properties           map[string]*crdSchema `json:"properties"`
+	Required             []string              `json:"required"`
+	Items                *crdSchema            `json:"items"`
+	AdditionalProperties *crdSchema            `json:"additionalProperties"`
+	Enum                 []interface{}         `json:"enum"`
+	IntOrString          bool                  `json:"x-kubernetes-int-or-string"`
+}
+
+// crdDocumentSeparator splits the YAML documents of a file.
+var crdDocumentSeparator = regexp.MustCompile(`(?m)^---\s*$`)
+
+// builtin types of the types built from CRDs. They are named after their
+// TypeScript counterparts, since OpenAPI types carry no Go type to replace.
+var (
+	crdString      = &types.Type{Name: types.Name{Name: "string"}, Kind: types.Builtin}
+	crdNumber      = &types.Type{Name: types.Name{Name: "number"}, Kind: types.Builtin}
+	crdBoolean     = &types.Type{Name: types.Name{Name: "boolean"}, Kind: types.Builtin}
+	crdUnknown     = &types.Type{Name: types.Name{Name: "unknown"}, Kind: types.Builtin}
+	crdIntOrString = &types.Type{Name: types.Name{Name: "number | string"}, Kind: types.Builtin}
+
+	// crdObjectMeta stands for the metadata of the root types, so it is
+	// rendered like the metav1.ObjectMeta of Go types when the config maps
+	// it, and as crdObjectMetaName otherwise.
+	crdObjectMeta = &types.Type{
+		Name: types.Name{Package: "k8s.io/apimachinery/pkg/apis/meta/v1", Name: "ObjectMeta"},
+		Kind: types.Struct,
+	}
+)
+
+// crdObjectMetaName is the type the default templates declare for the metadata
+// of the root kinds.
+const crdObjectMetaName = "ObjectMetadata"
+
+// parseCRDPackages reads the CustomResourceDefinition manifests in the YAML
+// files of dir and builds an apiPackage of types per group and version of
+// their OpenAPI v3 schemas.
+func parseCRDPackages(dir string) ([]*apiPackage, error) {
+	var files []string
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if ext := filepath.Ext(path); !info.IsDir() && (ext == ".yaml" || ext == ".yml") {
+			files = append(files, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot read the %s directory", dir)
+	}
+	sort.Strings(files)
+
+	pkgMap := make(map[string]*apiPackage)
+	for _, f := range files {
+		b, err := ioutil.ReadFile(f)
+		if err != nil {
+			return nil, err
+		}
+		for _, doc := range crdDocumentSeparator.Split(string(b), -1) {
+			if strings.TrimSpace(doc) == "" {
+				continue
+			}
+			var crd crdManifest
+			if err := yaml.Unmarshal([]byte(doc), &crd); err != nil {
+				return nil, errors.Wrapf(err, "failed to parse %s", f)
+			}
+			if crd.Kind != "CustomResourceDefinition" {
+				klog.V(3).Infof("skipping %s object in %s", crd.Kind, f)
+				continue
+			}
+			for _, v := range crd.Spec.Versions {
+				validation := v.Schema
+				if validation == nil {
+					validation = crd.Spec.Validation
+				}
+				if validation == nil || validation.OpenAPIV3Schema == nil {
+					log.Warningf("%s %s/%s in %s has no schema, skipping", crd.Spec.Names.Kind, crd.Spec.Group, v.Name, f)
+					continue
+				}
+				id := fmt.Sprintf("%s/%s", crd.Spec.Group, v.Name)
+				pkg, ok := pkgMap[id]
+				if !ok {
+					pkg = &apiPackage{apiGroup: crd.Spec.Group, apiVersion: v.Name}
+					pkgMap[id] = pkg
+				}
+				b := crdTypeBuilder{pkg: pkg, pkgPath: id}
+				b.rootType(crd.Spec.Names.Kind, validation.OpenAPIV3Schema)
+			}
+		}
+	}
+	out := packageMapToList(pkgMap)
+	sort.Slice(out, func(i, j int) bool { return out[i].identifier() < out[j].identifier() })
+	return out, nil
+}
+
+// crdTypeBuilder builds the types of a schema into pkg.
+type crdTypeBuilder struct {
+	pkg     *apiPackage
+	pkgPath string
+}
+
+// rootType builds the root type named kind, from the schema of a version.
+func (b crdTypeBuilder) rootType(kind string, s *crdSchema) {
+	t := b.structType(kind, s, true)
+	t.SecondClosestCommentLines = []string{"+kubebuilder:object:root=true"}
+}
+
+// structType builds the struct type name with the properties of s. The
+// apiVersion and kind of root types are left out like metav1.TypeMeta.
+func (b crdTypeBuilder) structType(name string, s *crdSchema, root bool) *types.Type {
+	t := &types.Type{
+		Name:         types.Name{Package: b.pkgPath, Name: name},
+		Kind:         types.Struct,
+		CommentLines: crdComments(s.Description),
+	}
+	b.pkg.Types = append(b.pkg.Types, t)
+
+	var props []string
+	for p := range s.Properties {
+		props = append(props, p)
+	}
+	sort.Strings(props)
+	for _, p := range props {
+		if root && (p == "apiVersion" || p == "kind") {
+			continue
+		}
+		var mt *types.Type
+		if root && p == "metadata" {
+			mt = crdObjectMeta
+		} else {
+			mt = b.schemaType(name+exportedName(p), s.Properties[p])
+		}
+		tag := fmt.Sprintf(`json:"%s,omitempty"`, p)
+		if containsString(s.Required, p) {
+			tag = fmt.Sprintf(`json:"%s"`, p)
+		}
+		t.Members = append(t.Members, types.Member{
+			Name:         exportedName(p),
+			Type:         mt,
+			Tags:         tag,
+			CommentLines: crdComments(s.Properties[p].Description),
+		})
+	}
+	return t
+}
+
+// schemaType returns the type of s, building the types of its nested objects
+// and enums, named after name.
+func (b crdTypeBuilder) schemaType(name string, s *crdSchema) *types.Type {
+	if s.IntOrString {
+		return crdIntOrString
+	}
+	if len(s.Enum) > 0 {
+		return b.enumType(name, s)
+	}
+	switch s.Type {
+	case "string":
+		return crdString
+	case "integer", "number":
+		return crdNumber
+	case "boolean":
+		return crdBoolean
+	case "array":
+		if s.Items == nil {
+			return &types.Type{Kind: types.Slice, Elem: crdUnknown}
+		}
+		return &types.Type{Kind: types.Slice, Elem: b.schemaType(name, s.Items)}
+	case "object":
+		if len(s.Properties) > 0 {
+			return b.structType(name, s, false)
+		}
+		elem := crdUnknown
+		if s.AdditionalProperties != nil {
+			elem = b.schemaType(name, s.AdditionalProperties)
+		}
+		return &types.Type{Kind: types.Map, Key: crdString, Elem: elem}
+	}
+	return crdUnknown
+}
+
+// enumType builds the alias type name of s, with a constant per value.
+func (b crdTypeBuilder) enumType(name string, s *crdSchema) *types.Type {
+	underlying := crdString
+	if s.Type == "integer" || s.Type == "number" {
+		underlying = crdNumber
+	}
+	t := &types.Type{
+		Name:         types.Name{Package: b.pkgPath, Name: name},
+		Kind:         types.Alias,
+		Underlying:   underlying,
+		CommentLines: crdComments(s.Description),
+	}
+	b.pkg.Types = append(b.pkg.Types, t)
+	for _, v := range s.Enum {
+		value := fmt.Sprint(v)
+		b.pkg.Constants = append(b.pkg.Constants, &types.Type{
+			Name:       types.Name{Package: b.pkgPath, Name: name + exportedName(value)},
+			Kind:       types.DeclarationOf,
+			Underlying: t,
+			ConstValue: &value,
+		})
+	}
+	return t
+}
+
+// crdComments splits the description of a schema into comment lines.
+func crdComments(description string) []string {
+	if description == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimRight(description, "\n"), "\n")
+}
+
+// exportedName turns a property name or an enum value (e.g. "node-port") into
+// an exported Go-style name (e.g. "NodePort").
+func exportedName(s string) string {
+	var b bytes.Buffer
+	upper := true
+	for _, r := range s {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9') {
+			upper = true
+			continue
+		}
+		if upper {
+			b.WriteString(strings.ToUpper(string(r)))
+			upper = false
+		} else {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
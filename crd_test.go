@@ -0,0 +1,126 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseCRDPackages(t *testing.T) {
+	pkgs, err := parseCRDPackages("testdata/crds")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []struct {
+		id        string
+		types     []string
+		constants []string
+	}{
+		// the v1beta1 validation is shared by all versions.
+		{"bar.example.com/v1", []string{"Gadget"}, nil},
+		{"bar.example.com/v2", []string{"Gadget"}, nil},
+		{"foo.example.com/v1", []string{"Widget", "WidgetSpec", "WidgetSpecMode", "WidgetSpecParts"},
+			[]string{"WidgetSpecModeFast", "WidgetSpecModeSlowIsh"}},
+		{"foo.example.com/v2", []string{"Widget"}, nil},
+	}
+	if len(pkgs) != len(want) {
+		t.Fatalf("%d packages, want %d", len(pkgs), len(want))
+	}
+	for i, w := range want {
+		p := pkgs[i]
+		if p.identifier() != w.id {
+			t.Errorf("package %d = %s, want %s", i, p.identifier(), w.id)
+		}
+		if got := typeNames(p.Types); !reflect.DeepEqual(got, w.types) {
+			t.Errorf("types of %s = %v, want %v", w.id, got, w.types)
+		}
+		if got := typeNames(p.Constants); !reflect.DeepEqual(got, w.constants) {
+			t.Errorf("constants of %s = %v, want %v", w.id, got, w.constants)
+		}
+	}
+
+	widget := findType(t, pkgs[2:3], "Widget")
+	if !isExportedType(widget, testConfig()) {
+		t.Errorf("Widget is not a root kind")
+	}
+	if m := findMember(t, widget, "Metadata"); m.Type != crdObjectMeta {
+		t.Errorf("Widget.metadata has type %s, want %s", m.Type, crdObjectMeta)
+	}
+	// the descriptions of the properties are the docs of the fields.
+	docs := []string{"Spec of the widget.", "It is desired."}
+	if m := findMember(t, widget, "Spec"); !reflect.DeepEqual(m.CommentLines, docs) {
+		t.Errorf("Widget.spec comments = %q, want %q", m.CommentLines, docs)
+	}
+	for _, name := range []string{"ApiVersion", "Kind"} {
+		for _, m := range widget.Members {
+			if m.Name == name {
+				t.Errorf("Widget has a %s member", name)
+			}
+		}
+	}
+
+	out := renderTemplate(t, "packages", pkgs, testConfig())
+	assertContains(t, out, []string{
+		"export interface WidgetSpec {\n" +
+			"labels?: Record<string, string>;\n" +
+			"mode?: WidgetSpecMode;\n" +
+			"parts?: WidgetSpecParts[];\n" +
+			"port?: number | string;\n" +
+			"raw?: Record<string, unknown>;\n" +
+			"size: number;\n" +
+			"tags?: string[];\n" +
+			"}",
+		"export type WidgetSpecMode = 'fast' | 'slow-ish';",
+		"'Widget': CustomResourceDefinition<ObjectMetadata, WidgetSpec, unknown>;",
+		"export interface Gadget {\ncount?: number;\n}",
+	}, []string{"ConfigMap", "apiVersion?:", "kind?:"})
+}
+
+func TestParseCRDPackagesErrors(t *testing.T) {
+	writeTree(t, map[string]string{
+		"crds/broken.yaml": "kind: CustomResourceDefinition\nspec: [\n",
+	})
+	if _, err := parseCRDPackages("crds"); err == nil {
+		t.Errorf("parseCRDPackages() of a broken manifest = nil, want an error")
+	}
+	if _, err := parseCRDPackages("missing"); err == nil {
+		t.Errorf("parseCRDPackages() of a missing directory = nil, want an error")
+	}
+}
+
+func TestExportedName(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"size", "Size"},
+		{"node-port", "NodePort"},
+		{"slow_ish", "SlowIsh"},
+		{"x509Cert", "X509Cert"},
+		{"3", "3"},
+		{"a.b c", "ABC"},
+	}
+	for _, tt := range tests {
+		if got := exportedName(tt.in); got != tt.want {
+			t.Errorf("exportedName(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestCRDObjectMetaDisplayName(t *testing.T) {
+	tests := []struct {
+		c    generatorConfig
+		want string
+	}{
+		{generatorConfig{}, crdObjectMetaName},
+		{testConfig(), "ObjectMetadata"},
+		{generatorConfig{
+			ExternalPackages: []externalPackage{{TypeMatchPrefix: `^k8s\.io/apimachinery/`}},
+			ExternalTypes:    map[string]map[string]string{crdObjectMeta.Name.Package: {"ObjectMeta": "KubeMeta"}},
+		}, "KubeMeta"},
+	}
+	for _, tt := range tests {
+		c := validConfig(t, tt.c)
+		if got := typeDisplayName(crdObjectMeta, c, nil); got != tt.want {
+			t.Errorf("typeDisplayName(metadata) = %q, want %q", got, tt.want)
+		}
+	}
+}
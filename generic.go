@@ -0,0 +1,151 @@
+package main
+
+import (
+	"k8s.io/gengo/types"
+	"strings"
+)
+
+// gengo has no notion of type parameters: it names the generic types after
+// their declaration (e.g. "Box[T any]"), their instantiations after their
+// type arguments (e.g. "Box[example.com/apis/v1.Part]", split at its last dot
+// like other names), and gives the type parameters the Unsupported kind.
+
+// splitGenericName splits the name of a generic type or of one of its
+// instantiations into its base name and the type parameters or arguments
+// between its brackets. ok is false for other names.
+func splitGenericName(name string) (base string, args []string, ok bool) {
+	i := strings.IndexByte(name, '[')
+	if i <= 0 || !strings.HasSuffix(name, "]") {
+		return name, nil, false
+	}
+	depth, start := 0, i+1
+	for j := start; j < len(name)-1; j++ {
+		switch name[j] {
+		case '[', '(', '{':
+			depth++
+		case ']', ')', '}':
+			depth--
+		case ',':
+			if depth == 0 {
+				args = append(args, strings.TrimSpace(name[start:j]))
+				start = j + 1
+			}
+		}
+	}
+	args = append(args, strings.TrimSpace(name[start:len(name)-1]))
+	return name[:i], args, true
+}
+
+// isGenericDeclaration determines if name is the one of a generic type, rather
+// than of an instantiation: its last type parameter has a constraint.
+func isGenericDeclaration(name string) bool {
+	_, params, ok := splitGenericName(name)
+	return ok && len(strings.Fields(params[len(params)-1])) > 1
+}
+
+// dropInstantiations removes the instantiations of generic types that gengo
+// lists among the types of pkg, since they are rendered inline where they are
+// used, see genericInstanceName.
+func dropInstantiations(pkg *types.Package) {
+	for name := range pkg.Types {
+		if _, _, ok := splitGenericName(name); ok && !isGenericDeclaration(name) {
+			delete(pkg.Types, name)
+		}
+	}
+}
+
+// typeParams renders the type parameters of the generic type t (e.g. "<K, V>"
+// for "Map[K, V any]"), or returns empty string if t is not generic.
+func typeParams(t *types.Type) string {
+	_, params, ok := splitGenericName(t.Name.Name)
+	if !ok || !isGenericDeclaration(t.Name.Name) {
+		return ""
+	}
+	var names []string
+	for _, p := range params {
+		// parameters sharing a constraint only name it once: "K, V any".
+		names = append(names, strings.Fields(p)[0])
+	}
+	return "<" + strings.Join(names, ", ") + ">"
+}
+
+// isTypeParam determines if t is the type parameter of a generic type.
+func isTypeParam(t *types.Type) bool {
+	return t.Kind == types.Unsupported && t.Name.Package == "" && tsIdentifier.MatchString(t.Name.Name)
+}
+
+// genericInstanceName renders the instantiation t of a generic type of the API
+// packages (e.g. "Box<Part>"), or returns false if t is not one.
+func genericInstanceName(t *types.Type, c generatorConfig, typePkgMap map[*types.Type]*apiPackage) (string, bool) {
+	// the name is split at its last dot, which may be in the type arguments.
+	full := t.Name.String()
+	i := strings.IndexByte(full, '[')
+	if i < 0 {
+		return "", false
+	}
+	dot := strings.LastIndexByte(full[:i], '.')
+	pkg := ""
+	if dot >= 0 {
+		pkg = full[:dot]
+	}
+	base, args, ok := splitGenericName(full[dot+1:])
+	if !ok {
+		return "", false
+	}
+	var generic *types.Type
+	byName := make(map[string]*types.Type)
+	for v := range typePkgMap {
+		byName[v.Name.String()] = v
+		if b, _, _ := splitGenericName(v.Name.Name); b == base && v.Name.Package == pkg && isGenericDeclaration(v.Name.Name) {
+			generic = v
+		}
+	}
+	if generic == nil {
+		return "", false
+	}
+
+	var names []string
+	for _, a := range args {
+		names = append(names, typeArgumentName(a, c, byName, typePkgMap))
+	}
+	return localTypeName(generic, c, typePkgMap[generic]) + "<" + strings.Join(names, ", ") + ">", true
+}
+
+// typeArgumentName renders the type argument a of an instantiation: a type of
+// the API packages looked up in byName, another instantiation, a slice or a
+// map of such, or a builtin.
+func typeArgumentName(a string, c generatorConfig, byName map[string]*types.Type, typePkgMap map[*types.Type]*apiPackage) string {
+	if strings.HasPrefix(a, "*") {
+		return typeArgumentName(a[1:], c, byName, typePkgMap)
+	}
+	if strings.HasPrefix(a, "[]") {
+		return sliceDisplayName(c, typeArgumentName(a[2:], c, byName, typePkgMap))
+	}
+	if strings.HasPrefix(a, "map[") {
+		if _, kv, ok := splitGenericName(a[:strings.IndexByte(a, ']')+1]); ok && len(kv) == 1 {
+			k, v := kv[0], a[len("map[")+len(kv[0])+1:]
+			key, ok := byName[k]
+			if !ok {
+				key = &types.Type{Name: types.Name{Name: k}, Kind: types.Builtin}
+			}
+			var keyName string
+			if namedMapKey(key) {
+				keyName = typeArgumentName(k, c, byName, typePkgMap)
+			}
+			return mapDisplayName(c, key, keyName, typeArgumentName(v, c, byName, typePkgMap))
+		}
+	}
+	if t, ok := byName[a]; ok {
+		return typeDisplayName(t, c, typePkgMap)
+	}
+	if r, ok := genericInstanceName(&types.Type{Name: types.Name{Name: a}}, c, typePkgMap); ok {
+		return r
+	}
+	name := a[strings.LastIndexByte(a, '.')+1:]
+	if !tsIdentifier.MatchString(name) {
+		// e.g. maps keyed by instantiations, whose key ends at another bracket.
+		c.currentReport().addUnmappedType(a)
+		return "unknown"
+	}
+	return replaceTypeName(c, name)
+}
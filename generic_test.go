@@ -0,0 +1,95 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitGenericName(t *testing.T) {
+	tests := []struct {
+		name string
+		base string
+		args []string
+		ok   bool
+	}{
+		{"Part", "Part", nil, false},
+		{"Box[T any]", "Box", []string{"T any"}, true},
+		{"Pair[K comparable, V any]", "Pair", []string{"K comparable", "V any"}, true},
+		{"Box[example.com/apis/v1.Part]", "Box", []string{"example.com/apis/v1.Part"}, true},
+		{"Box[map[string]int]", "Box", []string{"map[string]int"}, true},
+		{"Pair[string, Box[int]]", "Pair", []string{"string", "Box[int]"}, true},
+		{"Box[T interface{ ~int | ~string }]", "Box", []string{"T interface{ ~int | ~string }"}, true},
+		{"[]Part", "[]Part", nil, false},
+	}
+	for _, tt := range tests {
+		base, args, ok := splitGenericName(tt.name)
+		if base != tt.base || !reflect.DeepEqual(args, tt.args) || ok != tt.ok {
+			t.Errorf("splitGenericName(%q) = %q, %q, %v, want %q, %q, %v", tt.name, base, args, ok, tt.base, tt.args, tt.ok)
+		}
+	}
+}
+
+func TestTypeParams(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"Part", ""},
+		{"Box[T any]", "<T>"},
+		{"Pair[K comparable, V any]", "<K, V>"},
+		{"Pair[K, V any]", "<K, V>"},
+		// instantiations are not declarations.
+		{"Box[example.com/apis/v1.Part]", ""},
+	}
+	for _, tt := range tests {
+		if got := typeParams(testType(tt.name)); got != tt.want {
+			t.Errorf("typeParams(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestGenericTypes(t *testing.T) {
+	useTemplateDir(t, nil)
+	writeTree(t, map[string]string{
+		"go.mod":    "module example.com/gen\n\ngo 1.18\n",
+		"v1/doc.go": "// +groupName=gen.example.com\npackage v1\n",
+		"v1/types.go": `package v1
+
+type Box[T any] struct {
+	Value T   ` + "`json:\"value\"`" + `
+	All   []T ` + "`json:\"all\"`" + `
+}
+
+type Pair[K comparable, V any] struct {
+	Items map[K]V ` + "`json:\"items\"`" + `
+}
+
+type Part struct {
+	Name string ` + "`json:\"name\"`" + `
+}
+
+type Holder struct {
+	Part   Box[Part]                ` + "`json:\"part\"`" + `
+	Parts  Box[[]*Part]             ` + "`json:\"parts\"`" + `
+	Pairs  Pair[string, Box[int32]] ` + "`json:\"pairs\"`" + `
+}
+`,
+	})
+	raw, err := parseAPIPackages("./v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkgs, err := combineAPIPackages(raw, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := renderTemplate(t, "packages", pkgs, testConfig())
+	assertContains(t, out, []string{
+		"export interface Box<T> {\nvalue: T;\nall: T[];\n}",
+		// the type parameters may be keyed by anything marshaling to text.
+		"items: Record<string, V>;",
+		"part: Box<Part>;",
+		"parts: Box<Part[]>;",
+		"pairs: Pair<string, Box<number>>;",
+	}, []string{"Box[", "Pair["})
+}
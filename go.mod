@@ -7,6 +7,8 @@ require (
 	github.com/pmezard/go-difflib v1.0.0 // indirect
 	github.com/russross/blackfriday/v2 v2.0.1
 	github.com/shurcooL/sanitized_anchor_name v1.0.0 // indirect
+	gopkg.in/yaml.v2 v2.4.0 // indirect
 	k8s.io/gengo v0.0.0-20201203183100-97869a43a9d9
 	k8s.io/klog v0.2.0
+	sigs.k8s.io/yaml v1.2.0
 )
@@ -3,8 +3,10 @@ package main
 import (
 	"bytes"
 	"fmt"
+	"github.com/pkg/errors"
 	"k8s.io/gengo/types"
 	"k8s.io/klog"
+	"path/filepath"
 	"reflect"
 	"regexp"
 	"sort"
@@ -20,12 +22,14 @@ func typeIdentifier(t *types.Type) string {
 }
 
 // apiGroupForType looks up apiGroup for the given type
-func apiGroupForType(t *types.Type, typePkgMap map[*types.Type]*apiPackage) string {
+func apiGroupForType(t *types.Type, c generatorConfig, typePkgMap map[*types.Type]*apiPackage) string {
 	t = tryDereference(t)
 
 	v := typePkgMap[t]
 	if v == nil {
-		klog.Warningf("WARNING: cannot read apiVersion for %s from type=>pkg map", t.Name.String())
+		r := c.currentReport()
+		r.errorf(errUnresolvedType, "cannot read apiVersion for %s from type=>pkg map", t.Name.String())
+		r.unresolvedTypes[t.Name.String()] = struct{}{}
 		return "<UNKNOWN_API_GROUP>"
 	}
 
@@ -56,6 +60,7 @@ func replaceTypeName(c generatorConfig, s string) string {
 	result, ok := c.TypeReplacements[s]
 
 	if ok {
+		c.currentReport().markConfigUsed("typeReplacements", s)
 		return result
 	}
 
@@ -89,6 +94,193 @@ func isExternalType(c generatorConfig, id string) bool {
 	return false
 }
 
+// tsBuiltinTypes lists the TypeScript types that never need to be imported.
+var tsBuiltinTypes = map[string]bool{
+	"any": true, "bigint": true, "boolean": true, "never": true, "null": true,
+	"number": true, "object": true, "string": true, "symbol": true,
+	"undefined": true, "unknown": true, "void": true,
+}
+
+// addExternalImport records name, the TypeScript name of the external type
+// identified by id, as imported from the Import of the first external package
+// matching it, and reports whether it did. Names that are not plain
+// identifiers (e.g. "Record<...>") or are TypeScript builtins are not
+// recorded.
+func addExternalImport(c generatorConfig, id, name string) bool {
+	if !tsIdentifier.MatchString(name) || tsBuiltinTypes[name] {
+		return false
+	}
+	for _, v := range c.ExternalPackages {
+		r, err := regexp.Compile(v.TypeMatchPrefix)
+		if err != nil || !r.MatchString(id) {
+			continue
+		}
+		if v.Import == "" {
+			return false
+		}
+		if externalImports[v.Import] == nil {
+			externalImports[v.Import] = make(map[string]struct{})
+		}
+		externalImports[v.Import][name] = struct{}{}
+		return true
+	}
+	return false
+}
+
+// addUnmappedType records the type id, rendered as is because neither
+// ExternalTypes, TypeReplacements nor an import maps it to a TypeScript type.
+func (r *runReport) addUnmappedType(id string) {
+	if _, ok := r.unmappedTypes[id]; ok {
+		return
+	}
+	r.unmappedTypes[id] = struct{}{}
+	r.warnf(warnUnmappedType, "type %s has no TypeScript mapping, rendering it as is", id)
+}
+
+// hasExternalImports determines if any external package or synthetic type has
+// an Import.
+func hasExternalImports(c generatorConfig) bool {
+	for _, v := range c.ExternalPackages {
+		if v.Import != "" {
+			return true
+		}
+	}
+	for _, v := range c.SyntheticTypes {
+		if v.Import != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// syntheticTypeName returns the TypeScript type the SyntheticTypes entry of
+// the Go type id renders it as, recording its import if any.
+func syntheticTypeName(c generatorConfig, id string) (string, bool) {
+	v, ok := c.SyntheticTypes[id]
+	if !ok {
+		return "", false
+	}
+	c.currentReport().markConfigUsed("syntheticTypes", id)
+	if v.Import != "" && tsIdentifier.MatchString(v.Type) {
+		if externalImports[v.Import] == nil {
+			externalImports[v.Import] = make(map[string]struct{})
+		}
+		externalImports[v.Import][v.Type] = struct{}{}
+	}
+	return v.Type, true
+}
+
+// importStatements renders the import statements of the given names by
+// module, sorted by module and name.
+func importStatements(imports map[string]map[string]struct{}) string {
+	var modules []string
+	for m := range imports {
+		modules = append(modules, m)
+	}
+	sort.Strings(modules)
+
+	var b strings.Builder
+	for _, m := range modules {
+		var names []string
+		for n := range imports[m] {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		fmt.Fprintf(&b, "import { %s } from '%s';\n", strings.Join(names, ", "), m)
+	}
+	if b.Len() > 0 {
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+// checkExtraMembers reports the first ExtraMembers entry that is not keyed by
+// the name of a struct type of pkgs.
+func checkExtraMembers(pkgs []*apiPackage, c generatorConfig) error {
+	structs := make(map[string]bool)
+	for _, pkg := range pkgs {
+		for _, t := range pkg.Types {
+			if t.Kind == types.Struct {
+				structs[t.Name.Name] = true
+			}
+		}
+	}
+	var names []string
+	for k := range c.ExtraMembers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	for _, k := range names {
+		if !structs[k] {
+			return errors.Errorf("extraMembers refers to %q, which is not a struct type of the API packages", k)
+		}
+	}
+	return nil
+}
+
+// enumsImport returns the statement importing the enums of pkgs, declared in
+// the enumsFile, into the outFile referring to them.
+func enumsImport(pkgs []*apiPackage, c generatorConfig, outFile, enumsFile string) (string, error) {
+	rel, err := filepath.Rel(filepath.Dir(outFile), enumsFile)
+	if err != nil {
+		return "", err
+	}
+	module := strings.TrimSuffix(filepath.ToSlash(rel), ".ts")
+	if !strings.HasPrefix(module, ".") {
+		module = "./" + module
+	}
+
+	constants := indexConstants(pkgs)
+	names := make(map[string]struct{})
+	for _, pkg := range pkgs {
+		for _, t := range visibleTypes(pkg.Types, c) {
+			if len(constantsOfType(t, constants, c)) > 0 {
+				names[localTypeName(t, c, pkg)] = struct{}{}
+			}
+		}
+	}
+	if len(names) == 0 {
+		return "", nil
+	}
+	return importStatements(map[string]map[string]struct{}{module: names}), nil
+}
+
+// externalTypeDocsURL renders the documentation URL of t using the
+// DocsURLTemplate of the first external package matching it, or returns empty
+// string if t is not external or has no template configured.
+func externalTypeDocsURL(c generatorConfig, t *types.Type) string {
+	t = tryDereference(t)
+	id := typeIdentifier(t)
+	for _, v := range c.ExternalPackages {
+		r, err := regexp.Compile(v.TypeMatchPrefix)
+		if err != nil || !r.MatchString(id) {
+			continue
+		}
+		if v.DocsURLTemplate == "" {
+			return ""
+		}
+
+		tpl, err := template.New("").Parse(v.DocsURLTemplate)
+		if err != nil {
+			c.currentReport().warnf(warnDocsURL, "invalid docsURLTemplate %q: %v", v.DocsURLTemplate, err)
+			return ""
+		}
+		var b bytes.Buffer
+		err = tpl.Execute(&b, map[string]interface{}{
+			"package": t.Name.Package,
+			"name":    t.Name.Name,
+			"type":    externalTypeReplacement(c, t),
+		})
+		if err != nil {
+			c.currentReport().warnf(warnDocsURL, "failed to execute docsURLTemplate %q: %v", v.DocsURLTemplate, err)
+			return ""
+		}
+		return b.String()
+	}
+
+	return ""
+}
+
 func externalTypeReplacement(c generatorConfig, t *types.Type) string {
 	for t.Kind == types.Pointer || t.Kind == types.Slice {
 		t = t.Elem
@@ -99,6 +291,7 @@ func externalTypeReplacement(c generatorConfig, t *types.Type) string {
 	if ok {
 		r, ok := pkg[t.Name.Name]
 		if ok {
+			c.currentReport().markConfigUsed("externalTypes", t.Name.Package, t.Name.Name)
 			return r
 		}
 	}
@@ -106,32 +299,91 @@ func externalTypeReplacement(c generatorConfig, t *types.Type) string {
 	return t.Name.Name
 }
 
+// builtinExternalTypes are the types of the standard library rendered as the
+// given TypeScript types, unless ExternalTypes maps them otherwise.
+// time.Duration marshals to a number of nanoseconds, but CRDs usually wrap it
+// into a string like metav1.Duration does.
+var builtinExternalTypes = map[string]map[string]string{
+	"time": {"Duration": "string"},
+}
+
+// builtinExternalType returns the rendering of t if it is one of the
+// builtinExternalTypes, taking its ExternalTypes override into account.
+func builtinExternalType(c generatorConfig, t *types.Type) (string, bool) {
+	if _, ok := builtinExternalTypes[t.Name.Package][t.Name.Name]; !ok {
+		return "", false
+	}
+	if r, ok := c.ExternalTypes[t.Name.Package][t.Name.Name]; ok {
+		c.currentReport().markConfigUsed("externalTypes", t.Name.Package, t.Name.Name)
+		return r, true
+	}
+	return builtinExternalTypes[t.Name.Package][t.Name.Name], true
+}
+
 func typeDisplayName(t *types.Type, c generatorConfig, typePkgMap map[*types.Type]*apiPackage) string {
-	s := typeIdentifier(t)
+	// pointers have no representation in the output, render what they point to.
+	for t.Kind == types.Pointer {
+		t = t.Elem
+	}
+
+	// render slice elements on their own so nested collections keep their
+	// nesting (e.g. [][]Foo) instead of collapsing into a single level.
+	if t.Kind == types.Slice {
+		elem := typeDisplayName(t.Elem, c, typePkgMap)
+		if c.NullablePointers && t.Elem.Kind == types.Pointer {
+			elem = "(" + elem + " | null)"
+		}
+		return sliceDisplayName(c, elem)
+	}
 
-	if isLocalType(t, typePkgMap) {
-		s = tryDereference(t).Name.Name
+	s := typeIdentifier(t)
+	if r, ok := syntheticTypeName(c, s); ok {
+		return r
+	}
+	if t == crdObjectMeta && !hasExternalMapping(c, t) {
+		return crdObjectMetaName
 	}
 
-	if t.Kind == types.Pointer {
-		s = strings.TrimLeft(s, "*")
+	local := isLocalType(t, typePkgMap)
+	if local {
+		s = localTypeName(t, c, typePkgMap[t])
+	} else if r, ok := builtinExternalType(c, t); ok {
+		return r
+	} else if r, ok := genericInstanceName(t, c, typePkgMap); ok {
+		return r
+	} else if isTypeParam(t) {
+		return t.Name.Name
 	}
 
-	if isExternalType(c, s) {
+	external := isExternalType(c, s)
+	if external {
+		id := s
 		s = externalTypeReplacement(c, t)
+		_, mapped := c.ExternalTypes[t.Name.Package][t.Name.Name]
+		if imported := addExternalImport(c, id, s); !mapped && !imported && !tsBuiltinTypes[s] {
+			c.currentReport().addUnmappedType(id)
+		}
 	}
 
 	switch t.Kind {
 	case types.Struct,
 		types.Interface,
 		types.Alias,
-		types.Pointer,
-		types.Slice,
 		types.Builtin:
 		// noop
 	case types.Map:
-		// return original name
-		return fmt.Sprintf("Record<%s, %s>", t.Key.Name.Name, replaceTypeName(c, t.Elem.Name.Name))
+		// render the value on its own so nested collections keep their nesting
+		value := typeDisplayName(t.Elem, c, typePkgMap)
+		if c.NullablePointers && t.Elem.Kind == types.Pointer {
+			value += " | null"
+		}
+		// builtin keys are rendered as their TypeScript key type, see
+		// mapDisplayName, so they need no mapping.
+		var key string
+		if namedMapKey(t.Key) {
+			key = typeDisplayName(t.Key, c, typePkgMap)
+		}
+		return mapDisplayName(c, t.Key, key, value)
 	case types.DeclarationOf:
 		// For constants, we want to display the value
 		// rather than the name of the constant, since the
@@ -147,41 +399,367 @@ func typeDisplayName(t *types.Type, c generatorConfig, typePkgMap map[*types.Typ
 			return *t.ConstValue
 		}
 		klog.Fatalf("type %s is a non-const declaration, which is unhandled", t.Name)
+	case types.Unknown:
+		// see resolveInvalidMembers.
+		klog.Fatalf("type %s could not be loaded, map it with syntheticTypes", t.Name)
 	default:
 		//it seems imported third lib types missed here.
 		klog.Fatalf("type %s has kind=%v which is unhandled", t.Name, t.Kind)
 	}
 
-	s = replaceTypeName(c, s)
+	if local {
+		// already replaced by localTypeName
+		return s
+	}
+	if _, ok := c.TypeReplacements[s]; !ok && !external && !tsBuiltinTypes[s] {
+		// builtins that are not identifiers are TypeScript expressions
+		// already, like those of the types built from CRDs.
+		if t.Name.Package != "" || tsIdentifier.MatchString(s) {
+			c.currentReport().addUnmappedType(s)
+		}
+	}
+	return replaceTypeName(c, s)
+}
 
-	if t.Kind == types.Slice {
-		tpl, err := template.New("").Parse(c.SliceTemplate)
-		if err != nil {
-			return s
+// localTypeName returns the name emitted for the type t of the API package
+// pkg, both where it is declared and where it is referenced: its entry in
+// TypeReplacements if any, or else the name given by its "+ts:name=<name>"
+// marker, or else its name transformed by TypeNameTemplate, suffixed with its
+// apiVersion if other versions merged into pkg declare it too.
+func localTypeName(t *types.Type, c generatorConfig, pkg *apiPackage) string {
+	if r, ok := c.TypeReplacements[t.Name.Name]; ok {
+		c.currentReport().markConfigUsed("typeReplacements", t.Name.Name)
+		return r
+	}
+	name := templatedTypeName(t, c, pkg)
+	if pkg != nil && pkg.versionSuffixed[t] {
+		name += strings.Title(pkg.versionOf(t))
+	}
+	if c.enumTypes[t] {
+		name = c.EnumNamePrefix + name + c.EnumNameSuffix
+	}
+	return name
+}
+
+// templatedTypeName returns the name given to t by its "+ts:name=<name>"
+// marker, or else its name transformed by TypeNameTemplate.
+func templatedTypeName(t *types.Type, c generatorConfig, pkg *apiPackage) string {
+	// generic types are named without their type parameters, see typeParams.
+	name, _, _ := splitGenericName(t.Name.Name)
+	for _, lines := range [][]string{t.CommentLines, t.SecondClosestCommentLines} {
+		if v := types.ExtractCommentTags("+", lines)["ts:name"]; len(v) > 0 && strings.TrimSpace(v[0]) != "" {
+			return strings.TrimSpace(v[0])
 		}
-		var b bytes.Buffer
-		err = tpl.Execute(&b, map[string]interface{}{
-			"type": s,
-		})
-		if err != nil {
-			return s
+	}
+	if c.typeNameTemplate == nil || pkg == nil {
+		return name
+	}
+	s, err := executeTypeNameTemplate(c.typeNameTemplate, map[string]interface{}{
+		"name":       name,
+		"package":    t.Name.Package,
+		"group":      pkg.apiGroup,
+		"shortGroup": strings.Split(pkg.apiGroup, ".")[0],
+		"version":    pkg.versionOf(t),
+	})
+	if err != nil {
+		// the declaration and the references would disagree on a fallback.
+		klog.Fatalf("failed to name type %s: %v", t.Name, err)
+	}
+	return s
+}
+
+// tsIdentifier matches the names that are valid TypeScript identifiers.
+var tsIdentifier = regexp.MustCompile(`^[A-Za-z_$][A-Za-z0-9_$]*$`)
+
+// compileTypeNameTemplate parses the TypeNameTemplate s and checks that it
+// names a sample type, or returns nil if s is empty.
+func compileTypeNameTemplate(s string) (*template.Template, error) {
+	if s == "" {
+		return nil, nil
+	}
+	tpl, err := template.New("typeNameTemplate").Option("missingkey=error").Funcs(map[string]interface{}{
+		"title": strings.Title,
+		"upper": strings.ToUpper,
+		"lower": strings.ToLower,
+	}).Parse(s)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid typeNameTemplate")
+	}
+	_, err = executeTypeNameTemplate(tpl, map[string]interface{}{
+		"name":       "Foo",
+		"package":    "example.com/apis/example/v1",
+		"group":      "example.com",
+		"shortGroup": "example",
+		"version":    "v1",
+	})
+	return tpl, errors.Wrap(err, "invalid typeNameTemplate")
+}
+
+// executeTypeNameTemplate renders the type name of data with tpl, failing if
+// the name is not a TypeScript identifier.
+func executeTypeNameTemplate(tpl *template.Template, data map[string]interface{}) (string, error) {
+	var b bytes.Buffer
+	if err := tpl.Execute(&b, data); err != nil {
+		return "", err
+	}
+	if !tsIdentifier.MatchString(b.String()) {
+		return "", errors.Errorf("%q is not a valid type name", b.String())
+	}
+	return b.String(), nil
+}
+
+// isValidMapKey determines if t is a string or an integer type, the map keys
+// encoding/json marshals without a TextMarshaler.
+func isValidMapKey(t *types.Type) bool {
+	u := finalUnderlyingTypeOf(t)
+	if u.Kind != types.Builtin {
+		return false
+	}
+	return u.Name.Name == "string" || strings.HasPrefix(u.Name.Name, "int") || strings.HasPrefix(u.Name.Name, "uint")
+}
+
+// mapKeyType returns the TypeScript key type of the map key type t: "number"
+// for integers and "string" otherwise.
+func mapKeyType(t *types.Type) string {
+	if isValidMapKey(t) && finalUnderlyingTypeOf(t).Name.Name != "string" {
+		return "number"
+	}
+	return "string"
+}
+
+// invalidMapKey returns the key type of the first map in t (or its elements)
+// that is not isValidMapKey, or nil if there is none.
+func invalidMapKey(t *types.Type) *types.Type {
+	for t.Kind == types.Pointer || t.Kind == types.Slice || t.Kind == types.Array {
+		t = t.Elem
+	}
+	if t.Kind == types.Alias && t.Underlying.Kind == types.Map {
+		t = t.Underlying
+	}
+	if t.Kind != types.Map {
+		return nil
+	}
+	if !isValidMapKey(t.Key) {
+		return t.Key
+	}
+	return invalidMapKey(t.Elem)
+}
+
+// checkMapKeys reports the first field or alias type of pkgs that is a map
+// keyed by a type that is neither a string nor an integer, unless
+// StringifyMapKeys is set.
+func checkMapKeys(pkgs []*apiPackage, c generatorConfig) error {
+	if c.StringifyMapKeys {
+		return nil
+	}
+	for _, pkg := range pkgs {
+		for _, t := range visibleTypes(sortTypes(pkg.Types, c), c) {
+			if t.Kind == types.Alias {
+				if k := invalidMapKey(t.Underlying); k != nil {
+					return errors.Errorf("type %s is a map keyed by %s, which TypeScript cannot key records by; set stringifyMapKeys if it marshals to text", t.Name, k.Name)
+				}
+			}
+			for _, m := range t.Members {
+				if hiddenMemberOf(t, m, c) {
+					continue
+				}
+				if k := invalidMapKey(m.Type); k != nil {
+					return errors.Errorf("field %s.%s is a map keyed by %s, which TypeScript cannot key records by; set stringifyMapKeys if it marshals to text", t.Name, m.Name, k.Name)
+				}
+			}
 		}
+	}
+	return nil
+}
 
-		s = b.String()
+// isUnserializable determines if t is, or points to, holds or maps to, a func
+// or a chan, which encoding/json cannot marshal.
+func isUnserializable(t *types.Type) bool {
+	for t.Kind == types.Pointer || t.Kind == types.Slice || t.Kind == types.Map {
+		t = t.Elem
 	}
+	return t.Kind == types.Func || t.Kind == types.Chan
+}
 
-	return s
+// warnUnserializableMembers warns about the fields of the visible types of
+// pkgs that hiddenMember omits because their type is unserializable.
+func warnUnserializableMembers(pkgs []*apiPackage, c generatorConfig) {
+	for _, pkg := range pkgs {
+		for _, t := range visibleTypes(sortTypes(pkg.Types, c), c) {
+			for _, m := range t.Members {
+				if isUnserializable(m.Type) {
+					warnf(warnUnserializable, "field %s.%s has the unserializable type %s, omitting it", t.Name, m.Name, m.Type.Name)
+				}
+			}
+		}
+	}
+}
+
+// anchorSeparators matches the runs of characters replaced by hyphens in slug
+// anchor IDs.
+var anchorSeparators = regexp.MustCompile(`[^a-z0-9]+`)
+
+// anchorID turns the display name s of a package or type into an anchor ID in
+// the AnchorIDStyle of c.
+func anchorID(s string, c generatorConfig) string {
+	if c.AnchorIDStyle == anchorIDStyleSlug {
+		return strings.Trim(anchorSeparators.ReplaceAllString(strings.ToLower(s), "-"), "-")
+	}
+	// display names like 'serving.knative.dev/v1alpha1' are valid DOM id
+	// strings per HTML5, except whitespace, so just replace those.
+	return strings.Join(strings.Fields(s), "-")
+}
+
+// namedMapKey determines if the map key type t is rendered by its display
+// name, as the aliases of strings and integers are, rather than by its
+// mapKeyType.
+func namedMapKey(t *types.Type) bool {
+	return t.Kind != types.Builtin && isValidMapKey(t)
+}
+
+// mapDisplayName renders a map with the given key type, key display name and
+// value display name in the configured MapStyle. Builtin keys are rendered as
+// "string" or "number", and keys that are neither strings nor integers as
+// strings, see StringifyMapKeys.
+func mapDisplayName(c generatorConfig, key *types.Type, keyName, value string) string {
+	if !namedMapKey(key) {
+		keyName = mapKeyType(key)
+	}
+	if c.MapStyle == mapStyleIndex {
+		// index signatures only accept the base key type, not aliases of it
+		return fmt.Sprintf("{ [key: %s]: %s }", mapKeyType(key), value)
+	}
+	if c.MapStyle == mapStyleMap {
+		return fmt.Sprintf("Map<%s, %s>", keyName, value)
+	}
+	return fmt.Sprintf("Record<%s, %s>", keyName, value)
+}
+
+// sliceDisplayName wraps the display name of a slice element with the
+// configured SliceTemplate.
+func sliceDisplayName(c generatorConfig, elem string) string {
+	tpl := c.sliceTemplate
+	if tpl == nil {
+		tpl = template.Must(compileSliceTemplate(c.SliceTemplate))
+	}
+	var b bytes.Buffer
+	if err := tpl.Execute(&b, map[string]interface{}{"type": elem}); err != nil {
+		return elem
+	}
+	return b.String()
+}
+
+// defaultSliceTemplate renders slices when no SliceTemplate is configured.
+const defaultSliceTemplate = "{{.type}}[]"
+
+// compileSliceTemplate parses the SliceTemplate s, or the default one if s is
+// empty, and checks that it renders the type of the elements.
+func compileSliceTemplate(s string) (*template.Template, error) {
+	if s == "" {
+		s = defaultSliceTemplate
+	}
+	tpl, err := template.New("sliceTemplate").Option("missingkey=error").Parse(s)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid sliceTemplate")
+	}
+	const probe = "__elem__"
+	var b bytes.Buffer
+	if err := tpl.Execute(&b, map[string]interface{}{"type": probe}); err != nil {
+		return nil, errors.Wrap(err, "invalid sliceTemplate")
+	}
+	if !strings.Contains(b.String(), probe) {
+		return nil, errors.Errorf("sliceTemplate %q does not render {{.type}}", s)
+	}
+	return tpl, nil
+}
+
+// isForceIncludedType determines if the doc comments of t carry the
+// force-include marker, which overrides any rule that would hide the type.
+func isForceIncludedType(t *types.Type) bool {
+	marker := strings.TrimSpace(strings.TrimPrefix(docCommentForceIncludes, "//"))
+	for _, lines := range [][]string{t.CommentLines, t.SecondClosestCommentLines} {
+		for _, l := range lines {
+			if strings.TrimSpace(l) == marker {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// isDeprecatedType determines if the doc comments of t have a "Deprecated:"
+// paragraph or the +deprecatedversion marker.
+func isDeprecatedType(t *types.Type) bool {
+	for _, lines := range [][]string{t.CommentLines, t.SecondClosestCommentLines} {
+		for _, l := range lines {
+			l = strings.TrimSpace(l)
+			if strings.HasPrefix(l, "Deprecated:") || strings.HasPrefix(l, "+deprecatedversion") {
+				return true
+			}
+		}
+	}
+	return false
 }
 
 func hideType(t *types.Type, c generatorConfig) bool {
-	for _, pattern := range c.HideTypePatterns {
-		if regexp.MustCompile(pattern).MatchString(t.Name.String()) {
-			return true
+	return hiddenReason(t, c) != ""
+}
+
+// hiddenReason returns why the type t is hidden, or empty string if it is
+// visible.
+func hiddenReason(t *types.Type, c generatorConfig) string {
+	if isForceIncludedType(t) {
+		return ""
+	}
+	if c.ExcludeDeprecated && isDeprecatedType(t) {
+		return "deprecated"
+	}
+	for _, r := range c.hideTypePatterns {
+		if r.MatchString(t.Name.String()) {
+			c.currentReport().markConfigUsed("hideTypePatterns", r.String())
+			return fmt.Sprintf("matches hideTypePatterns %q", r.String())
 		}
 	}
-	if !isExportedType(t) && unicode.IsLower(rune(t.Name.Name[0])) {
-		// types that start with lowercase
-		return true
+	if !isExportedType(t, c) && unicode.IsLower(rune(t.Name.Name[0])) {
+		// types that start with lowercase, unless needed by visible types
+		if !(c.EmitUnexportedReferenced && c.unexportedReferenced[t]) {
+			return "unexported"
+		}
+	}
+	return ""
+}
+
+// findUnexportedReferenced returns the lowercase-named types of pkgs
+// transitively referenced by the fields of visible types.
+func findUnexportedReferenced(pkgs []*apiPackage, c generatorConfig) map[*types.Type]bool {
+	references := findTypeReferences(pkgs)
+	typePkgMap := extractTypeToPackageMap(pkgs)
+	// the types found so far are visible, and may reveal others.
+	c.unexportedReferenced = make(map[*types.Type]bool)
+	for changed := true; changed; {
+		changed = false
+		for t, refs := range references {
+			if c.unexportedReferenced[t] || typePkgMap[t] == nil || !unicode.IsLower(rune(t.Name.Name[0])) {
+				continue
+			}
+			for _, r := range refs {
+				if !hideType(r, c) {
+					c.unexportedReferenced[t] = true
+					changed = true
+					break
+				}
+			}
+		}
+	}
+	return c.unexportedReferenced
+}
+
+// hideConstant determines if the constant t matches HideConstantPatterns.
+func hideConstant(t *types.Type, c generatorConfig) bool {
+	for _, r := range c.hideConstantPatterns {
+		if r.MatchString(t.Name.String()) {
+			return true
+		}
 	}
 	return false
 }
@@ -197,23 +775,292 @@ func typeReferences(t *types.Type, c generatorConfig, references map[*types.Type
 	for k := range m {
 		out = append(out, k)
 	}
-	sortTypes(out)
+	sortTypes(out, c)
 	return out
 }
 
-func sortTypes(typs []*types.Type) []*types.Type {
+// danglingReferences reports the fields of visible types that refer to a
+// hidden type of the API packages, which is missing from the output.
+func danglingReferences(pkgs []*apiPackage, c generatorConfig) []string {
+	typePkgMap := extractTypeToPackageMap(pkgs)
+	var out []string
+	for _, pkg := range pkgs {
+		for _, t := range visibleTypes(sortTypes(pkg.Types, c), c) {
+			for _, m := range t.Members {
+				ref := tryDereference(m.Type)
+				if hiddenMemberOf(t, m, c) || !isLocalType(ref, typePkgMap) || !hideType(ref, c) {
+					continue
+				}
+				out = append(out, fmt.Sprintf("field %s.%s refers to hidden type %s", t.Name.Name, m.Name, ref.Name.Name))
+			}
+		}
+	}
+	return out
+}
+
+// reportDanglingReferences records the danglingReferences of pkgs in the
+// report: as warnings, or as errors failing the run if strict is set.
+func reportDanglingReferences(pkgs []*apiPackage, c generatorConfig, strict bool) {
+	for _, v := range danglingReferences(pkgs, c) {
+		if strict {
+			errorf(errDanglingRef, "%s", v)
+		} else {
+			warnf(warnDanglingRef, "%s", v)
+		}
+	}
+}
+
+// sortTypes sorts typs in the TypeSortOrder: by their rank in the typeRanks of
+// c, or else root kinds first and then by name.
+func sortTypes(typs []*types.Type, c generatorConfig) []*types.Type {
 	sort.Slice(typs, func(i, j int) bool {
 		t1, t2 := typs[i], typs[j]
-		if isExportedType(t1) && !isExportedType(t2) {
-			return true
-		} else if !isExportedType(t1) && isExportedType(t2) {
-			return false
+		r1, ok1 := c.typeRanks[t1]
+		r2, ok2 := c.typeRanks[t2]
+		if ok1 && ok2 {
+			return r1 < r2
+		} else if ok1 != ok2 {
+			return ok1
 		}
-		return t1.Name.String() < t2.Name.String()
+		return defaultTypeLess(t1, t2, c)
 	})
 	return typs
 }
 
+// defaultTypeLess orders the root kinds first, and then the types by name.
+func defaultTypeLess(t1, t2 *types.Type, c generatorConfig) bool {
+	if isExportedType(t1, c) && !isExportedType(t2, c) {
+		return true
+	} else if !isExportedType(t1, c) && isExportedType(t2, c) {
+		return false
+	}
+	return t1.Name.String() < t2.Name.String()
+}
+
+// rankTypes computes the positions of the types of pkgs in the TypeSortOrder
+// of c, or returns nil for the default order.
+func rankTypes(pkgs []*apiPackage, c generatorConfig) map[*types.Type]int {
+	var all []*types.Type
+	for _, pkg := range pkgs {
+		all = append(all, pkg.Types...)
+	}
+
+	var ordered []*types.Type
+	switch c.TypeSortOrder {
+	case typeSortAlphabetical:
+		ordered = all
+		sort.Slice(ordered, func(i, j int) bool { return ordered[i].Name.String() < ordered[j].Name.String() })
+	case typeSortSource:
+		// types without sources (e.g. built from CRDs) keep the default order.
+		sources := findTypeSources(pkgs)
+		for _, t := range all {
+			if _, ok := sources[t]; ok {
+				ordered = append(ordered, t)
+			}
+		}
+		sort.Slice(ordered, func(i, j int) bool {
+			p1, p2 := sources[ordered[i]], sources[ordered[j]]
+			if p1.Filename != p2.Filename {
+				return p1.Filename < p2.Filename
+			}
+			return p1.Offset < p2.Offset
+		})
+	case typeSortDependency:
+		ordered = dependencyOrder(all, c)
+	default:
+		return nil
+	}
+
+	ranks := make(map[*types.Type]int, len(ordered))
+	for i, t := range ordered {
+		ranks[t] = i
+	}
+	return ranks
+}
+
+// dependencyOrder sorts typs topologically, so that each type comes before the
+// types its fields refer to. Ties, and cycles, are broken by picking the first
+// type in the default order.
+func dependencyOrder(typs []*types.Type, c generatorConfig) []*types.Type {
+	remaining := make(map[*types.Type]bool, len(typs))
+	for _, t := range typs {
+		remaining[t] = true
+	}
+	referrers := make(map[*types.Type]int)
+	refs := make(map[*types.Type][]*types.Type)
+	for _, t := range typs {
+		seen := make(map[*types.Type]bool)
+		for _, m := range t.Members {
+			r := tryDereference(m.Type)
+			if r != t && remaining[r] && !seen[r] {
+				seen[r] = true
+				refs[t] = append(refs[t], r)
+				referrers[r]++
+			}
+		}
+	}
+
+	candidates := make([]*types.Type, len(typs))
+	copy(candidates, typs)
+	sort.Slice(candidates, func(i, j int) bool { return defaultTypeLess(candidates[i], candidates[j], c) })
+
+	var out []*types.Type
+	for len(out) < len(typs) {
+		var next *types.Type
+		for _, t := range candidates {
+			if remaining[t] && referrers[t] == 0 {
+				next = t
+				break
+			}
+		}
+		if next == nil {
+			// only cycles are left.
+			for _, t := range candidates {
+				if remaining[t] {
+					next = t
+					break
+				}
+			}
+		}
+		delete(remaining, next)
+		out = append(out, next)
+		for _, r := range refs[next] {
+			referrers[r]--
+		}
+	}
+	return out
+}
+
+// sortedMembers returns the members of t in the order configured by
+// MemberOrder, without those of RootFieldExclude for root kinds nor the
+// apiVersion and kind fields replaced by kindLiterals. Embedded members come
+// first when sorting alphabetically.
+func sortedMembers(t *types.Type, c generatorConfig) []types.Member {
+	ms := t.Members
+	literals := hasKindLiterals(t, c)
+	if len(c.RootFieldExclude) > 0 && isExportedType(t, c) || literals {
+		ms = nil
+		for _, m := range t.Members {
+			if !hiddenMemberOf(t, m, c) && !(literals && isTypeMetaField(m, c)) {
+				ms = append(ms, m)
+			}
+		}
+	}
+	if c.MemberOrder != memberOrderAlphabetical {
+		return ms
+	}
+
+	ms = append([]types.Member(nil), ms...)
+	sort.SliceStable(ms, func(i, j int) bool {
+		if ei, ej := fieldEmbedded(ms[i]), fieldEmbedded(ms[j]); ei != ej {
+			return ei
+		}
+		return fieldName(ms[i], c) < fieldName(ms[j], c)
+	})
+	return ms
+}
+
+// memberNamed returns the field of t with the given JSON name, even if it is
+// hidden, and whether there is one.
+func memberNamed(t *types.Type, name string, c generatorConfig) (types.Member, bool) {
+	for _, m := range t.Members {
+		if !fieldEmbedded(m) && fieldName(m, c) == name {
+			return m, true
+		}
+	}
+	return types.Member{}, false
+}
+
+// kindLiterals returns the apiVersion and kind members rendered for the root
+// kind t of pkg with KindLiterals, or nil if t has none.
+func kindLiterals(t *types.Type, c generatorConfig, pkg *apiPackage) []string {
+	if pkg == nil || !hasKindLiterals(t, c) {
+		return nil
+	}
+	apiVersion := pkg.versionOf(t)
+	if pkg.apiGroup != "" {
+		apiVersion = pkg.apiGroup + "/" + apiVersion
+	}
+	return []string{
+		fmt.Sprintf("apiVersion: '%s';", apiVersion),
+		fmt.Sprintf("kind: '%s';", t.Name.Name),
+	}
+}
+
+// kindEntry is an entry of the KindRegistry: the kind of a root kind and its
+// TypeScript type.
+type kindEntry struct {
+	Kind string
+	Type string
+}
+
+// kindRegistry returns the entries of the KindRegistry of the visible root
+// kinds of pkgs, sorted by kind. A kind declared by several packages keeps the
+// type of the first one.
+func kindRegistry(pkgs []*apiPackage, c generatorConfig, typePkgMap map[*types.Type]*apiPackage) []kindEntry {
+	var out []kindEntry
+	seen := make(map[string]*apiPackage)
+	for _, p := range pkgs {
+		for _, t := range visibleTypes(sortTypes(p.Types, c), c) {
+			if !isExportedType(t, c) {
+				continue
+			}
+			if other, ok := seen[t.Name.Name]; ok {
+				c.currentReport().warnf(warnKindRegistry, "kind %s of %s is already registered by %s", t.Name.Name, p.identifier(), other.identifier())
+				continue
+			}
+			seen[t.Name.Name] = p
+			out = append(out, kindEntry{Kind: t.Name.Name, Type: typeDisplayName(t, c, typePkgMap)})
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Kind < out[j].Kind })
+	return out
+}
+
+// hasKindLiterals determines if the apiVersion and kind fields of t are
+// rendered as literals, see KindLiterals.
+func hasKindLiterals(t *types.Type, c generatorConfig) bool {
+	if !c.KindLiterals || !isExportedType(t, c) {
+		return false
+	}
+	apiVersion, kind := typeMetaFields(t, c, make(map[*types.Type]bool))
+	return apiVersion && kind
+}
+
+// typeMetaFields determines if t has the apiVersion and kind fields, declared
+// directly or through its embedded types.
+func typeMetaFields(t *types.Type, c generatorConfig, seen map[*types.Type]bool) (apiVersion, kind bool) {
+	if seen[t] {
+		return false, false
+	}
+	seen[t] = true
+	for _, m := range t.Members {
+		if fieldEmbedded(m) {
+			e := m.Type
+			for e.Kind == types.Pointer {
+				e = e.Elem
+			}
+			a, k := typeMetaFields(e, c, seen)
+			apiVersion, kind = apiVersion || a, kind || k
+			continue
+		}
+		switch fieldName(m, c) {
+		case "apiVersion":
+			apiVersion = true
+		case "kind":
+			kind = true
+		}
+	}
+	return apiVersion, kind
+}
+
+// isTypeMetaField determines if m is an apiVersion or kind field declared
+// directly in its struct.
+func isTypeMetaField(m types.Member, c generatorConfig) bool {
+	name := fieldName(m, c)
+	return !fieldEmbedded(m) && (name == "apiVersion" || name == "kind")
+}
+
 func visibleTypes(in []*types.Type, c generatorConfig) []*types.Type {
 	var out []*types.Type
 	for _, t := range in {
@@ -224,25 +1071,71 @@ func visibleTypes(in []*types.Type, c generatorConfig) []*types.Type {
 	return out
 }
 
-func isExportedType(t *types.Type) bool {
-	// TODO(ahmetb) use types.ExtractSingleBoolCommentTag() to parse +genclient
-	// https://godoc.org/k8s.io/gengo/types#ExtractCommentTags
-	res := strings.Contains(strings.Join(t.SecondClosestCommentLines, "\n"), "+kubebuilder:object:root=true")
-	return res
+// defaultRootKindMarkers lists the markers flagging the root kinds when
+// RootKindMarkers is not set.
+var defaultRootKindMarkers = []string{"kubebuilder:object:root"}
+
+// isExportedType determines if t is a root kind, i.e. if its doc comments carry
+// one of the RootKindMarkers of c, either without value or set to true (e.g.
+// "+kubebuilder:object:root=true"), or with sub-keys (e.g.
+// "+kubebuilder:resource:path=foos").
+func isExportedType(t *types.Type, c generatorConfig) bool {
+	markers := c.RootKindMarkers
+	if len(markers) == 0 {
+		markers = defaultRootKindMarkers
+	}
+	for _, lines := range [][]string{t.CommentLines, t.SecondClosestCommentLines} {
+		for k, v := range types.ExtractCommentTags("+", lines) {
+			k = strings.TrimSpace(k)
+			for _, m := range markers {
+				if strings.HasPrefix(k, m+":") {
+					return true
+				}
+				if val := strings.TrimSpace(v[len(v)-1]); k == m && (val == "" || val == "true") {
+					return true
+				}
+			}
+		}
+	}
+	return false
 }
 
-func fieldName(m types.Member) string {
+// fieldName returns the JSON name of the field m: the name of its json tag, or
+// else its Go name in the DefaultFieldCase of c.
+func fieldName(m types.Member, c generatorConfig) string {
 	v := reflect.StructTag(m.Tags).Get("json")
 	v = strings.TrimSuffix(v, ",omitempty")
 	v = strings.TrimSuffix(v, ",inline")
 	if v != "" {
 		return v
 	}
+	if c.DefaultFieldCase == fieldCaseCamel && m.Name != "" {
+		return strings.ToLower(m.Name[:1]) + m.Name[1:]
+	}
 	return m.Name
 }
 
+// hasJSONOption determines if the json tag of m carries the given option
+// (e.g. "omitempty").
+func hasJSONOption(m types.Member, option string) bool {
+	opts := strings.Split(reflect.StructTag(m.Tags).Get("json"), ",")
+	for _, o := range opts[1:] {
+		if o == option {
+			return true
+		}
+	}
+	return false
+}
+
+// fieldEmbedded determines if the fields of m are flattened into its parent,
+// either through the ",inline" json option or through Go struct embedding
+// without an explicit json name.
 func fieldEmbedded(m types.Member) bool {
-	return strings.Contains(reflect.StructTag(m.Tags).Get("json"), ",inline")
+	tag := reflect.StructTag(m.Tags).Get("json")
+	if strings.Contains(tag, ",inline") {
+		return true
+	}
+	return m.Embedded && strings.Split(tag, ",")[0] == ""
 }
 
 func hasEmbeddedTypes(t types.Type) bool {
@@ -265,12 +1158,160 @@ func embeddedTypes(t types.Type) (ms []types.Member) {
 	return
 }
 
+// embeddedDisplayName renders the embedded type t in the intersection of its
+// parent: its display name, or else the inline object of its members when it
+// is an external struct without a TypeScript mapping, since its Go name means
+// nothing in the output. External types without members keep their name and
+// are reported as unmapped, see -strict-types.
+func embeddedDisplayName(t *types.Type, c generatorConfig, typePkgMap map[*types.Type]*apiPackage) string {
+	for t.Kind == types.Pointer {
+		t = t.Elem
+	}
+	if t.Kind != types.Struct || len(t.Members) == 0 || isLocalType(t, typePkgMap) || hasExternalMapping(c, t) {
+		return typeDisplayName(t, c, typePkgMap)
+	}
+
+	var fields, embedded []string
+	for _, m := range t.Members {
+		if hiddenMember(m, c) {
+			continue
+		}
+		if fieldEmbedded(m) {
+			embedded = append(embedded, embeddedDisplayName(m.Type, c, typePkgMap))
+			continue
+		}
+		optional := ""
+		if isOptionalMember(m, c) && c.OptionalStyle != optionalStyleUndefinedUnion {
+			optional = "?"
+		}
+		fields = append(fields, fmt.Sprintf("%s%s: %s;", fieldName(m, c), optional, memberType(m, c, typePkgMap)))
+	}
+	s := "{ " + strings.Join(fields, " ") + " }"
+	if len(fields) == 0 {
+		s = "{}"
+	}
+	for _, e := range embedded {
+		s += " & " + e
+	}
+	return s
+}
+
+// tsExtendable matches the types an interface can extend: plain or generic
+// names.
+var tsExtendable = regexp.MustCompile(`^[A-Za-z_$][A-Za-z0-9_$]*(<.*>)?$`)
+
+// interfaceExtends returns the types the struct t extends when declared as an
+// interface, and whether it is declared as one: DeclarationKind is
+// "interface" and all its visible embedded types are extendable.
+func interfaceExtends(t *types.Type, c generatorConfig, typePkgMap map[*types.Type]*apiPackage) (string, bool) {
+	if c.declarationKind() != declarationInterface || t.Kind != types.Struct {
+		return "", false
+	}
+	var names []string
+	for _, m := range embeddedTypes(*t) {
+		if hiddenMember(m, c) {
+			continue
+		}
+		name := embeddedDisplayName(m.Type, c, typePkgMap)
+		if !tsExtendable.MatchString(name) {
+			return "", false
+		}
+		names = append(names, name)
+	}
+	return strings.Join(names, ", "), true
+}
+
+// hasExternalMapping determines if the external type t is rendered as a
+// TypeScript type given by the config, rather than as its Go name:
+// SyntheticTypes, ExternalTypes, TypeReplacements, the builtinExternalTypes or
+// the Import of its external package.
+func hasExternalMapping(c generatorConfig, t *types.Type) bool {
+	id := t.Name.String()
+	if _, ok := c.SyntheticTypes[id]; ok {
+		return true
+	}
+	if _, ok := builtinExternalTypes[t.Name.Package][t.Name.Name]; ok {
+		return true
+	}
+	if _, ok := c.ExternalTypes[t.Name.Package][t.Name.Name]; ok {
+		return true
+	}
+	if _, ok := c.TypeReplacements[id]; ok {
+		return true
+	}
+	for _, v := range c.ExternalPackages {
+		if r, err := regexp.Compile(v.TypeMatchPrefix); err == nil && r.MatchString(id) {
+			_, ok := c.TypeReplacements[t.Name.Name]
+			return ok || v.Import != ""
+		}
+	}
+	return false
+}
+
 func isLocalType(t *types.Type, typePkgMap map[*types.Type]*apiPackage) bool {
 	t = tryDereference(t)
 	_, ok := typePkgMap[t]
 	return ok
 }
 
+// knownFormats lists the OpenAPI and kubebuilder string formats recognized by
+// the "branded" FormatStyle.
+var knownFormats = map[string]bool{
+	"bsonobjectid": true, "byte": true, "binary": true, "cidr": true,
+	"creditcard": true, "date": true, "date-time": true, "datetime": true,
+	"duration": true, "email": true, "hexcolor": true, "hostname": true,
+	"ipv4": true, "ipv6": true, "isbn": true, "isbn10": true, "isbn13": true,
+	"mac": true, "password": true, "rgbcolor": true, "ssn": true, "uri": true,
+	"uuid": true, "uuid3": true, "uuid4": true, "uuid5": true,
+}
+
+// memberFormat returns the value of the +kubebuilder:validation:Format marker
+// of a string member, or empty string if it has none.
+func memberFormat(m types.Member) string {
+	f, ok := validationMarker(m, "Format")
+	if !ok {
+		return ""
+	}
+	t := m.Type
+	for t.Kind == types.Pointer {
+		t = t.Elem
+	}
+	if u := finalUnderlyingTypeOf(t); u.Kind != types.Builtin || u.Name.Name != "string" {
+		return ""
+	}
+	return f
+}
+
+// memberExample returns the lines of the example value of the field m, one per
+// "+ts:example=<line>" marker, or else per "+kubebuilder:example=<line>"
+// marker. Lines closing the JSDoc comment are escaped.
+func memberExample(m types.Member) []string {
+	tags := types.ExtractCommentTags("+", m.CommentLines)
+	lines, ok := tags["ts:example"]
+	if !ok {
+		lines = tags["kubebuilder:example"]
+	}
+	var out []string
+	for _, l := range lines {
+		out = append(out, strings.Replace(l, "*/", "*\\/", -1))
+	}
+	return out
+}
+
+// memberDocs returns the doc comment lines of the field m. Fields without a
+// Go doc comment fall back to their description struct tag, which documents
+// the fields of go-restful APIs. The fields built from CRDs carry their schema
+// description as comment lines.
+func memberDocs(m types.Member) []string {
+	if hasComments(m.CommentLines) {
+		return m.CommentLines
+	}
+	if v := reflect.StructTag(m.Tags).Get("description"); v != "" {
+		return strings.Split(v, "\n")
+	}
+	return m.CommentLines
+}
+
 func hasComments(s []string) bool {
 	s = filterCommentTags(s)
 	if len(s) == 0 || (len(s) == 1 && s[0] == "") {
@@ -280,6 +1321,69 @@ func hasComments(s []string) bool {
 	return true
 }
 
+// wrapComments returns the lines of the JSDoc of comment lines: it leaves out
+// the markers (e.g. +optional), along with the blank lines they leave at the
+// end, and word-wraps the prose so that, once rendered with the " * " JSDoc
+// prefix, it fits in width columns. Blank lines, indented lines (e.g. code
+// blocks) and tags are left intact, and so are inline tags like {@link Foo}.
+// A width of 0 disables wrapping.
+func wrapComments(lines []string, width int) []string {
+	lines = filterCommentTags(lines)
+	for len(lines) > 0 && strings.TrimSpace(lines[len(lines)-1]) == "" {
+		lines = lines[:len(lines)-1]
+	}
+	if width <= 0 {
+		return lines
+	}
+	width -= len(" * ")
+
+	var out []string
+	for _, l := range lines {
+		trimmed := strings.TrimSpace(l)
+		if trimmed == "" || len(l) <= width || strings.HasPrefix(l, " ") || strings.HasPrefix(l, "\t") ||
+			strings.HasPrefix(trimmed, "@") {
+			out = append(out, l)
+			continue
+		}
+
+		var cur string
+		for _, w := range commentWords(l) {
+			if cur != "" && len(cur)+1+len(w) > width {
+				out = append(out, cur)
+				cur = ""
+			}
+			if cur == "" {
+				cur = w
+			} else {
+				cur += " " + w
+			}
+		}
+		out = append(out, cur)
+	}
+	return out
+}
+
+// commentWords splits a comment line into words, keeping inline tags like
+// {@link Foo bar} as a single word.
+func commentWords(l string) []string {
+	var out []string
+	inTag := false
+	for _, w := range strings.Fields(l) {
+		if inTag {
+			out[len(out)-1] += " " + w
+		} else {
+			out = append(out, w)
+		}
+		if strings.Contains(w, "{@") {
+			inTag = true
+		}
+		if strings.Contains(w, "}") {
+			inTag = false
+		}
+	}
+	return out
+}
+
 func renderComments(s []string) string {
 	s = filterCommentTags(s)
 	if len(s) == 0 || (len(s) == 1 && s[0] == "") {
@@ -294,20 +1398,218 @@ func renderComments(s []string) string {
 	return "/**\n" + doc + "\n */"
 }
 
-// constantsOfType finds all the constants in pkg that have the
-// same underlying type as t. This is intended for use by enum
+// unionMarker returns the type names of the +ts:union marker of the
+// interface t, if it has one.
+func unionMarker(t *types.Type) (names []string, ok bool) {
+	if t.Kind != types.Interface {
+		return nil, false
+	}
+	lines := make([]string, 0, len(t.CommentLines)+len(t.SecondClosestCommentLines))
+	lines = append(append(lines, t.CommentLines...), t.SecondClosestCommentLines...)
+	v, ok := types.ExtractCommentTags("+", lines)["ts:union"]
+	if !ok {
+		return nil, false
+	}
+	for _, n := range strings.Split(v[0], ",") {
+		if n = strings.TrimSpace(n); n != "" {
+			names = append(names, n)
+		}
+	}
+	return names, true
+}
+
+// unionTypes returns the implementations of the interface t when it has the
+// +ts:union marker, so that it can be rendered as their union: either the
+// types it names (+ts:union=Foo,Bar) or, if it names none, the visible types
+// implementing all of its methods. Names are looked up in the package of t,
+// unless qualified by their import path (+ts:union=example.com/v1.Foo).
+func unionTypes(t *types.Type, pkgs []*apiPackage, c generatorConfig) []*types.Type {
+	names, ok := unionMarker(t)
+	if !ok {
+		return nil
+	}
+
+	named := func(typ *types.Type) bool {
+		for _, n := range names {
+			if n == typ.Name.String() || n == typ.Name.Name && typ.Name.Package == t.Name.Package {
+				return true
+			}
+		}
+		return false
+	}
+	implements := func(typ *types.Type) bool {
+		if typ == t || typ.Kind != types.Struct {
+			return false
+		}
+		for name := range t.Methods {
+			if _, ok := typ.Methods[name]; !ok {
+				return false
+			}
+		}
+		return true
+	}
+
+	var out []*types.Type
+	for _, pkg := range pkgs {
+		for _, typ := range pkg.Types {
+			if hideType(typ, c) {
+				continue
+			}
+			if len(names) > 0 && named(typ) || len(names) == 0 && implements(typ) {
+				out = append(out, typ)
+			}
+		}
+	}
+	if len(out) == 0 {
+		c.currentReport().warnf(warnUnion, "interface %s has the +ts:union marker but no implementations", t.Name.String())
+	}
+	return sortTypes(out, c)
+}
+
+// constantValue renders the value of the constant t as a TypeScript literal.
+// String constants are quoted, numeric (e.g. iota-based) and boolean
+// constants are emitted verbatim.
+func constantValue(t *types.Type) string {
+	u := finalUnderlyingTypeOf(t)
+	if u.Kind == types.Builtin && u.Name.Name == "string" {
+		return "'" + tsStringEscaper.Replace(*t.ConstValue) + "'"
+	}
+	return *t.ConstValue
+}
+
+// tsStringEscaper escapes the characters that cannot appear verbatim in a
+// single-quoted TypeScript string.
+var tsStringEscaper = strings.NewReplacer(`\`, `\\`, `'`, `\'`, "\n", `\n`, "\r", `\r`, "\u2028", `\u2028`, "\u2029", `\u2029`)
+
+// enumDocTable returns the lines of the Markdown table of the values of the
+// enum type t and their descriptions, taken from the doc comments of its
+// constants, or nil unless EnumDocTable is set.
+func enumDocTable(t *types.Type, constants map[*types.Type][]*types.Type, c generatorConfig) []string {
+	if !c.EnumDocTable {
+		return nil
+	}
+	values := constantsOfType(t, constants, c)
+	if len(values) == 0 {
+		return nil
+	}
+	escape := strings.NewReplacer("|", "\\|", "*/", "*\\/")
+	out := []string{"| Value | Description |", "| --- | --- |"}
+	for _, v := range values {
+		if v.ConstValue == nil {
+			continue
+		}
+		description := strings.TrimSpace(strings.Join(filterCommentTags(v.CommentLines), " "))
+		out = append(out, fmt.Sprintf("| `%s` | %s |", escape.Replace(constantValue(v)), escape.Replace(description)))
+	}
+	return out
+}
+
+// constantsType renders the constants of t as a union of their values, or
+// returns empty string if t has no visible constants.
+func constantsType(t *types.Type, constants map[*types.Type][]*types.Type, c generatorConfig) string {
+	var values []string
+	for _, typ := range constantsOfType(t, constants, c) {
+		if typ.ConstValue == nil {
+			continue
+		}
+		if !c.EnumMemberComments {
+			values = append(values, constantValue(typ))
+			continue
+		}
+		// one member per line, each preceded by its own doc comment
+		if doc := renderComments(typ.CommentLines); doc != "" {
+			values = append(values, doc)
+		}
+		values = append(values, "| "+constantValue(typ))
+	}
+
+	if c.EnumMemberComments && len(values) > 0 {
+		return "\n" + strings.Join(values, "\n")
+	}
+	return strings.Join(values, " | ")
+}
+
+// aliasDisplayName renders the type aliased by t: the union of its constants
+// if it has any visible, EmptyEnumType if they are all hidden, or else its
+// underlying type.
+func aliasDisplayName(t *types.Type, c generatorConfig, constants map[*types.Type][]*types.Type, typePkgMap map[*types.Type]*apiPackage) string {
+	if s := constantsType(t, constants, c); s != "" {
+		return s
+	}
+	if c.EmptyEnumType != "" && len(constantsOfType(t, constants, generatorConfig{})) > 0 {
+		return c.EmptyEnumType
+	}
+	return typeDisplayName(t.Underlying, c, typePkgMap)
+}
+
+// constantsOfType finds all the constants that have the
+// same underlying type as t, in the constants indexed by
+// indexConstants. This is intended for use by enum
 // type validation, where users need to specify one of a specific
 // set of constant values for a field.
-func constantsOfType(t *types.Type, pkg *apiPackage) []*types.Type {
-	constants := []*types.Type{}
+// Constants matching HideConstantPatterns are left out. The constants of the
+// types defined from t only belong to it when t has constants of its own:
+// otherwise t is not an enum, and accepts any value of its base type.
+func constantsOfType(t *types.Type, constants map[*types.Type][]*types.Type, c generatorConfig) []*types.Type {
+	out := []*types.Type{}
+	own := false
+	for _, v := range constants[t] {
+		if !hideConstant(v, c) {
+			out = append(out, v)
+			own = own || v.Underlying == t
+		}
+	}
+
+	if !own {
+		return []*types.Type{}
+	}
+	return sortTypes(out, c)
+}
 
-	for _, c := range pkg.Constants {
-		if c.Underlying == t {
-			constants = append(constants, c)
+// indexConstants maps the types to the constants of pkgs that are values of
+// them, either directly or through intermediate alias types, see
+// constantTypes. Constants are looked up in all packages since they are
+// sometimes declared in a sibling package of their type.
+func indexConstants(pkgs []*apiPackage) map[*types.Type][]*types.Type {
+	out := make(map[*types.Type][]*types.Type)
+	seen := make(map[*types.Type]bool)
+	for _, pkg := range pkgs {
+		for _, v := range pkg.Constants {
+			if seen[v] {
+				continue
+			}
+			seen[v] = true
+			for _, t := range constantTypes(v) {
+				out[t] = append(out[t], v)
+			}
 		}
 	}
+	return out
+}
+
+// constantTypes returns the type of the constant v, followed by the named
+// types it is defined from (e.g. Base for "type Mid Base"), stopping at the
+// builtin type they share with unrelated constants.
+func constantTypes(v *types.Type) []*types.Type {
+	var out []*types.Type
+	for t := v.Underlying; t != nil && t.Kind == types.Alias; t = t.Underlying {
+		out = append(out, t)
+	}
+	return out
+}
 
-	return sortTypes(constants)
+// findEnumTypes returns the types of pkgs having visible constants declared
+// with that exact type, see constantsOfType.
+func findEnumTypes(pkgs []*apiPackage, c generatorConfig) map[*types.Type]bool {
+	out := make(map[*types.Type]bool)
+	for _, pkg := range pkgs {
+		for _, v := range pkg.Constants {
+			if !hideConstant(v, c) {
+				out[v.Underlying] = true
+			}
+		}
+	}
+	return out
 }
 
 // TODO extract external types
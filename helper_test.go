@@ -0,0 +1,1511 @@
+package main
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"k8s.io/gengo/types"
+)
+
+func TestHideType(t *testing.T) {
+	c := validConfig(t, generatorConfig{HideTypePatterns: []string{"List$"}})
+	tests := []struct {
+		name     string
+		comments []string
+		want     bool
+	}{
+		{name: "Widget", want: false},
+		{name: "widget", want: true},
+		{name: "widget", comments: []string{"+gencrdrefdocs:force"}, want: false},
+		{name: "WidgetList", want: true},
+		{name: "WidgetList", comments: []string{"+gencrdrefdocs:force"}, want: false},
+	}
+	for _, tt := range tests {
+		if got := hideType(testType(tt.name, tt.comments...), c); got != tt.want {
+			t.Errorf("hideType(%s %q) = %v, want %v", tt.name, tt.comments, got, tt.want)
+		}
+	}
+}
+
+func TestForceIncludedTypeRendered(t *testing.T) {
+	out := renderTemplate(t, "packages", testPackages(t, "foo/v1"), generatorConfig{})
+	if !strings.Contains(out, "innerThing {") {
+		t.Errorf("the force-included innerThing is not rendered:\n%s", out)
+	}
+	if strings.Contains(out, "orphanThing {") {
+		t.Errorf("the unexported orphanThing is rendered:\n%s", out)
+	}
+}
+
+// testDisplayNames checks the typeDisplayName of members of the types of the
+// foo/v1 test package, given as {type, member, want}.
+func testDisplayNames(t *testing.T, c generatorConfig, tests [][3]string) {
+	t.Helper()
+	pkgs := testPackages(t, "foo/v1")
+	c = validConfig(t, c).withPackages(pkgs)
+	typePkgMap := extractTypeToPackageMap(pkgs)
+	for _, tt := range tests {
+		m := findMember(t, findType(t, pkgs, tt[0]), tt[1])
+		if got := typeDisplayName(m.Type, c, typePkgMap); got != tt[2] {
+			t.Errorf("%s.%s: typeDisplayName() = %q, want %q", tt[0], tt[1], got, tt[2])
+		}
+	}
+}
+
+func TestTypeDisplayNameSlices(t *testing.T) {
+	testDisplayNames(t, testConfig(), [][3]string{
+		{"Shapes", "A", "Part[]"},
+		{"Shapes", "B", "Part[]"},
+		{"Shapes", "C", "Part[][]"},
+		{"Shapes", "D", "Part[]"},
+		{"Shapes", "E", "Part[]"},
+		{"WidgetSpec", "Ptr", "Part"},
+		{"WidgetSpec", "Grid", "string[][]"},
+	})
+}
+
+func TestSliceTemplate(t *testing.T) {
+	c := testConfig()
+	c.SliceTemplate = "Array<{{.type}}>"
+	testDisplayNames(t, c, [][3]string{
+		{"Shapes", "A", "Array<Part>"},
+		{"Shapes", "C", "Array<Array<Part>>"},
+		{"MapShapes", "A", "Record<string, Array<Part>>"},
+		{"WidgetSpec", "Ptr", "Part"},
+	})
+
+	tests := []struct {
+		tpl     string
+		wantErr string
+	}{
+		{"", ""},
+		{"ReadonlyArray<{{.type}}>", ""},
+		{"{{.type}", "invalid sliceTemplate"},
+		{"{{.elem}}[]", "invalid sliceTemplate"},
+		{"any[]", `sliceTemplate "any[]" does not render {{.type}}`},
+	}
+	for _, tt := range tests {
+		c := testConfig()
+		c.SliceTemplate = tt.tpl
+		err := c.validate()
+		if tt.wantErr == "" {
+			if err != nil {
+				t.Errorf("sliceTemplate %q: validate() = %v, want no error", tt.tpl, err)
+			}
+		} else if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("sliceTemplate %q: validate() = %v, want an error containing %q", tt.tpl, err, tt.wantErr)
+		}
+	}
+}
+
+func TestTypeDisplayNameMaps(t *testing.T) {
+	testDisplayNames(t, testConfig(), [][3]string{
+		{"MapShapes", "A", "Record<string, Part[]>"},
+		{"MapShapes", "B", "Record<string, Record<string, Part>>"},
+		{"MapShapes", "C", "Record<string, string[]>[]"},
+		{"MapShapes", "D", "Record<string, number>"},
+		{"WidgetSpec", "Idx", "Record<string, Part[]>"},
+	})
+}
+
+func TestExternalTypeDocsURL(t *testing.T) {
+	timeType := &types.Type{
+		Name: types.Name{Package: "k8s.io/apimachinery/pkg/apis/meta/v1", Name: "Time"},
+		Kind: types.Struct,
+	}
+	docs := func(tpl string) generatorConfig {
+		return generatorConfig{
+			ExternalPackages: []externalPackage{{TypeMatchPrefix: `^k8s\.io/apimachinery/`, DocsURLTemplate: tpl}},
+			ExternalTypes:    map[string]map[string]string{timeType.Name.Package: {"Time": "string"}},
+		}
+	}
+	tests := []struct {
+		name     string
+		c        generatorConfig
+		typ      *types.Type
+		want     string
+		warnings int
+	}{
+		{"template", docs("https://pkg.go.dev/{{.package}}#{{.name}}"), timeType,
+			"https://pkg.go.dev/k8s.io/apimachinery/pkg/apis/meta/v1#Time", 0},
+		{"pointer", docs("https://docs/{{.name}}"), &types.Type{Kind: types.Pointer, Elem: timeType}, "https://docs/Time", 0},
+		{"typescript name", docs("https://docs/{{.type}}"), timeType, "https://docs/string", 0},
+		{"no template", docs(""), timeType, "", 0},
+		{"not external", docs("https://docs/{{.name}}"), testType("Widget"), "", 0},
+		{"invalid template", docs("https://docs/{{.name"), timeType, "", 1},
+		{"failing template", docs("https://docs/{{.name.x}}"), timeType, "", 1},
+	}
+	for _, tt := range tests {
+		resetRun()
+		if got := externalTypeDocsURL(tt.c, tt.typ); got != tt.want {
+			t.Errorf("%s: externalTypeDocsURL() = %q, want %q", tt.name, got, tt.want)
+		}
+		if n := len(report.warnings[warnDocsURL]); n != tt.warnings {
+			t.Errorf("%s: %d warnings, want %d", tt.name, n, tt.warnings)
+		}
+	}
+}
+
+// memberNames returns the JSON names of ms, the embedded ones as "...Type".
+func memberNames(ms []types.Member, c generatorConfig) []string {
+	var out []string
+	for _, m := range ms {
+		if fieldEmbedded(m) {
+			out = append(out, "..."+m.Type.Name.Name)
+			continue
+		}
+		out = append(out, fieldName(m, c))
+	}
+	return out
+}
+
+func TestSortedMembersOrder(t *testing.T) {
+	typ := testType("Widget")
+	typ.Members = []types.Member{
+		testMember("Zeta", types.String, `json:"zeta"`),
+		testMember("Alpha", types.String, `json:"alpha,omitempty"`),
+		{Name: "Part", Embedded: true, Type: testType("Part"), Tags: `json:",inline"`},
+		testMember("Mid", types.String, `json:"mid"`),
+	}
+	tests := []struct {
+		order string
+		want  string
+	}{
+		{"", "zeta alpha ...Part mid"},
+		{memberOrderSource, "zeta alpha ...Part mid"},
+		{memberOrderAlphabetical, "...Part alpha mid zeta"},
+	}
+	for _, tt := range tests {
+		c := validConfig(t, generatorConfig{MemberOrder: tt.order})
+		if got := strings.Join(memberNames(sortedMembers(typ, c), c), " "); got != tt.want {
+			t.Errorf("memberOrder %q: sortedMembers() = %s, want %s", tt.order, got, tt.want)
+		}
+	}
+}
+
+func TestInvalidMemberOrder(t *testing.T) {
+	c := generatorConfig{MemberOrder: "random"}
+	if err := c.validate(); err == nil {
+		t.Error("validate() accepts the memberOrder random")
+	}
+}
+
+// testConstant returns a constant of an alias of underlying holding value.
+func testConstant(name, value string, underlying *types.Type) *types.Type {
+	alias := &types.Type{
+		Name:       types.Name{Package: "example.com/apis/v1", Name: "Phase"},
+		Kind:       types.Alias,
+		Underlying: underlying,
+	}
+	return &types.Type{
+		Name:       types.Name{Package: "example.com/apis/v1", Name: name},
+		Kind:       types.DeclarationOf,
+		Underlying: alias,
+		ConstValue: &value,
+	}
+}
+
+func TestConstantValue(t *testing.T) {
+	tests := []struct {
+		value      string
+		underlying *types.Type
+		want       string
+	}{
+		{"Ready", types.String, `'Ready'`},
+		{"", types.String, `''`},
+		{"it's", types.String, `'it\'s'`},
+		{`C:\dir`, types.String, `'C:\\dir'`},
+		{"a\nb\r", types.String, `'a\nb\r'`},
+		{"a\u2028b\u2029", types.String, `'a\u2028b\u2029'`},
+		{"2", types.Int, "2"},
+		{"1.5", types.Float64, "1.5"},
+		{"true", types.Bool, "true"},
+	}
+	for _, tt := range tests {
+		if got := constantValue(testConstant("C", tt.value, tt.underlying)); got != tt.want {
+			t.Errorf("constantValue(%q of %s) = %s, want %s", tt.value, tt.underlying.Name, got, tt.want)
+		}
+	}
+}
+
+func TestConstantValueIota(t *testing.T) {
+	want := map[string]string{"LevelLow": "0", "LevelMid": "1", "LevelHigh": "2"}
+	for _, p := range testPackages(t, "foo/v1") {
+		for _, v := range p.Constants {
+			if w, ok := want[v.Name.Name]; ok {
+				if got := constantValue(v); got != w {
+					t.Errorf("constantValue(%s) = %s, want %s", v.Name.Name, got, w)
+				}
+				delete(want, v.Name.Name)
+			}
+		}
+	}
+	if len(want) > 0 {
+		t.Errorf("constants not found: %v", want)
+	}
+}
+
+func TestFieldEmbedded(t *testing.T) {
+	part := testType("Part")
+	tests := []struct {
+		m    types.Member
+		want bool
+	}{
+		{types.Member{Name: "Part", Type: part, Embedded: true}, true},
+		{types.Member{Name: "Part", Type: part, Embedded: true, Tags: `json:",omitempty"`}, true},
+		{types.Member{Name: "Part", Type: part, Embedded: true, Tags: `json:"part"`}, false},
+		{types.Member{Name: "Part", Type: part, Tags: `json:",inline"`}, true},
+		{types.Member{Name: "Part", Type: part, Embedded: true, Tags: `json:"part,inline"`}, true},
+		{types.Member{Name: "Part", Type: part, Tags: `json:"part"`}, false},
+	}
+	for _, tt := range tests {
+		if got := fieldEmbedded(tt.m); got != tt.want {
+			t.Errorf("fieldEmbedded(embedded=%v %s) = %v, want %v", tt.m.Embedded, tt.m.Tags, got, tt.want)
+		}
+	}
+}
+
+func TestIsDeprecatedType(t *testing.T) {
+	tests := []struct {
+		comments []string
+		want     bool
+	}{
+		{nil, false},
+		{[]string{"OldThing is old."}, false},
+		{[]string{"OldThing is old.", "", "Deprecated: use Part."}, true},
+		{[]string{"  Deprecated: use Part."}, true},
+		{[]string{"+deprecatedversion:warning=v1"}, true},
+		{[]string{"It is not Deprecated: at all."}, false},
+	}
+	for _, tt := range tests {
+		if got := isDeprecatedType(testType("OldThing", tt.comments...)); got != tt.want {
+			t.Errorf("isDeprecatedType(%q) = %v, want %v", tt.comments, got, tt.want)
+		}
+	}
+}
+
+func TestDanglingReferences(t *testing.T) {
+	pkgs := testPackages(t, "foo/v1")
+	old := "field UsesOld.Old refers to hidden type OldThing"
+	helper := "field UsesHelper.H refers to hidden type helperThing"
+	tests := []struct {
+		excludeDeprecated bool
+		want              []string
+	}{
+		{false, []string{helper}},
+		{true, []string{helper, old}},
+	}
+	for _, tt := range tests {
+		c := testConfig()
+		c.ExcludeDeprecated = tt.excludeDeprecated
+		c = validConfig(t, c).withPackages(pkgs)
+		got := danglingReferences(pkgs, c)
+		sort.Strings(got)
+		if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+			t.Errorf("excludeDeprecated=%v: danglingReferences() = %q, want %q", tt.excludeDeprecated, got, tt.want)
+		}
+	}
+}
+
+func TestReportDanglingReferences(t *testing.T) {
+	pkgs := testPackages(t, "foo/v1")
+	c := validConfig(t, testConfig()).withPackages(pkgs)
+	tests := []struct {
+		strict           bool
+		errors, warnings int
+	}{
+		{false, 0, 1},
+		{true, 1, 0},
+	}
+	for _, tt := range tests {
+		resetRun()
+		reportDanglingReferences(pkgs, c, tt.strict)
+		if n := len(report.errors[errDanglingRef]); n != tt.errors {
+			t.Errorf("strict=%v: %d errors, want %d", tt.strict, n, tt.errors)
+		}
+		if n := len(report.warnings[warnDanglingRef]); n != tt.warnings {
+			t.Errorf("strict=%v: %d warnings, want %d", tt.strict, n, tt.warnings)
+		}
+	}
+}
+
+func TestIntegerMapKeysUnmapped(t *testing.T) {
+	uint8Type := &types.Type{Name: types.Name{Name: "uint8"}, Kind: types.Builtin}
+	// the integer keys are rendered as numbers without a typeReplacements
+	// entry, so -strict-types does not fail on them.
+	c := validConfig(t, generatorConfig{})
+	for _, key := range []*types.Type{types.Int64, uint8Type, types.String} {
+		resetRun()
+		m := &types.Type{Name: types.Name{Name: "map[" + key.Name.Name + "]string"}, Kind: types.Map, Key: key, Elem: types.String}
+		want := "Record<number, string>"
+		if key == types.String {
+			want = "Record<string, string>"
+		}
+		if got := typeDisplayName(m, c, nil); got != want {
+			t.Errorf("typeDisplayName(%s) = %q, want %q", m.Name.Name, got, want)
+		}
+		if len(report.unmappedTypes) > 0 {
+			t.Errorf("typeDisplayName(%s): unmapped types %v", m.Name.Name, report.unmappedTypes)
+		}
+	}
+}
+
+func TestMapStyle(t *testing.T) {
+	tests := []struct {
+		style         string
+		byName, plain string
+	}{
+		{"", "Record<KeyName, Part>", "Record<string, Part>"},
+		{mapStyleRecord, "Record<KeyName, Part>", "Record<string, Part>"},
+		{mapStyleIndex, "{ [key: string]: Part }", "{ [key: string]: Part }"},
+		{mapStyleMap, "Map<KeyName, Part>", "Map<string, Part>"},
+	}
+	for _, tt := range tests {
+		c := testConfig()
+		c.MapStyle = tt.style
+		testDisplayNames(t, c, [][3]string{
+			{"KeyedMaps", "ByName", tt.byName},
+			{"KeyedMaps", "Plain", tt.plain},
+		})
+	}
+
+	c := testConfig()
+	c.MapStyle = mapStyleMap
+	testDisplayNames(t, c, [][3]string{
+		{"MapShapes", "A", "Map<string, Part[]>"},
+		{"MapShapes", "B", "Map<string, Map<string, Part>>"},
+		{"MapShapes", "C", "Map<string, string[]>[]"},
+	})
+	c.MapStyle = "object"
+	if err := c.validate(); err == nil || !strings.Contains(err.Error(), `unknown mapStyle "object"`) {
+		t.Errorf("validate() = %v, want an unknown mapStyle error", err)
+	}
+}
+
+// typeNames returns the names of typs.
+func typeNames(typs []*types.Type) []string {
+	var out []string
+	for _, t := range typs {
+		out = append(out, t.Name.Name)
+	}
+	return out
+}
+
+func TestHideConstantPatterns(t *testing.T) {
+	pkgs := testPackages(t, "foo/v1")
+	phase := findType(t, pkgs, "Phase")
+	tests := []struct {
+		patterns []string
+		want     string
+	}{
+		{nil, "PhaseA PhaseB"},
+		{[]string{"PhaseB$"}, "PhaseA"},
+		{[]string{`^example\.com/fx/apis/foo/v1\.`}, ""},
+		{[]string{"Level"}, "PhaseA PhaseB"},
+	}
+	for _, tt := range tests {
+		c := validConfig(t, generatorConfig{HideConstantPatterns: tt.patterns})
+		if got := strings.Join(typeNames(constantsOfType(phase, indexConstants(pkgs), c)), " "); got != tt.want {
+			t.Errorf("hideConstantPatterns %q: constantsOfType(Phase) = %q, want %q", tt.patterns, got, tt.want)
+		}
+	}
+
+	for setting, c := range map[string]generatorConfig{
+		"hideConstantPatterns": {HideConstantPatterns: []string{"Phase("}},
+		"hideTypePatterns":     {HideTypePatterns: []string{"List$", "Phase("}},
+	} {
+		if err := c.validate(); err == nil || !strings.Contains(err.Error(), setting) {
+			t.Errorf("validate() with an invalid %s = %v, want an error naming it", setting, err)
+		}
+	}
+}
+
+func TestAliasDisplayName(t *testing.T) {
+	pkgs := testPackages(t, "foo/v1")
+	typePkgMap := extractTypeToPackageMap(pkgs)
+	hideAll := []string{"Phase[AB]$"}
+	tests := []struct {
+		typ           string
+		hidden        []string
+		emptyEnumType string
+		want          string
+	}{
+		{"Phase", nil, "", "'A' | 'B'"},
+		{"Phase", []string{"PhaseB$"}, "", "'A'"},
+		{"Phase", hideAll, "", "string"},
+		{"Phase", hideAll, "never", "never"},
+		{"Count", nil, "never", "number"},
+	}
+	for _, tt := range tests {
+		c := testConfig()
+		c.HideConstantPatterns, c.EmptyEnumType = tt.hidden, tt.emptyEnumType
+		c = validConfig(t, c)
+		if got := aliasDisplayName(findType(t, pkgs, tt.typ), c, indexConstants(pkgs), typePkgMap); got != tt.want {
+			t.Errorf("aliasDisplayName(%s) hiding %q with emptyEnumType %q = %q, want %q",
+				tt.typ, tt.hidden, tt.emptyEnumType, got, tt.want)
+		}
+	}
+}
+
+func TestConstantsOfTypeAcrossPackages(t *testing.T) {
+	all := testPackages(t, "...")
+	mode := findType(t, all, "Mode")
+	// the constants are declared by barx/v1, a sibling of the package of
+	// their type.
+	own := &apiPackage{Types: []*types.Type{mode}}
+	tests := []struct {
+		name string
+		pkgs []*apiPackage
+		want string
+	}{
+		{"own package", []*apiPackage{own}, ""},
+		{"all packages", all, "ModeFast ModeSlow"},
+	}
+	c := validConfig(t, generatorConfig{})
+	for _, tt := range tests {
+		if got := strings.Join(typeNames(constantsOfType(mode, indexConstants(tt.pkgs), c)), " "); got != tt.want {
+			t.Errorf("%s: constantsOfType(Mode) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSiblingPackageConstantsRendered(t *testing.T) {
+	out := renderTemplate(t, "packages", testPackages(t, "..."), testConfig())
+	assertContains(t, out, []string{"export type Mode = 'fast' | 'slow';"}, nil)
+}
+
+func TestUnionTypes(t *testing.T) {
+	pkgs := testPackages(t, "foo/v1")
+	tests := []struct {
+		typ      string
+		want     string
+		warnings int
+	}{
+		{"Shape", "Circle Square", 0},
+		{"Picked", "Circle Square", 0},
+		{"Lonely", "", 1},
+		{"Widget", "", 0},
+	}
+	c := validConfig(t, testConfig())
+	for _, tt := range tests {
+		resetRun()
+		if got := strings.Join(typeNames(unionTypes(findType(t, pkgs, tt.typ), pkgs, c)), " "); got != tt.want {
+			t.Errorf("unionTypes(%s) = %q, want %q", tt.typ, got, tt.want)
+		}
+		if n := len(report.warnings[warnUnion]); n != tt.warnings {
+			t.Errorf("unionTypes(%s): %d warnings, want %d", tt.typ, n, tt.warnings)
+		}
+	}
+
+	resetRun()
+	out := renderTemplate(t, "packages", pkgs, testConfig())
+	assertContains(t, out, []string{
+		"export type Shape = Circle | Square;",
+		"export type Picked = Circle | Square;",
+		"export type Lonely = unknown;",
+	}, nil)
+	// each union is only resolved once.
+	if n := len(report.warnings[warnUnion]); n != 1 {
+		t.Errorf("rendering: %d warnings, want 1", n)
+	}
+}
+
+func TestUnionTypeNames(t *testing.T) {
+	inPackage := func(pkg, name string, comments ...string) *types.Type {
+		typ := testType(name, comments...)
+		typ.Name.Package = pkg
+		return typ
+	}
+	v1, v2 := "example.com/apis/v1", "example.com/apis/v2"
+	pkgs := []*apiPackage{
+		{apiGroup: "example.com", apiVersion: "v1", Types: []*types.Type{inPackage(v1, "Circle"), inPackage(v1, "Square")}},
+		{apiGroup: "example.com", apiVersion: "v2", Types: []*types.Type{inPackage(v2, "Circle"), inPackage(v2, "Square")}},
+	}
+	tests := []struct {
+		marker string
+		want   []string
+	}{
+		// plain names are looked up in the package of the interface.
+		{"+ts:union=Circle,Square", []string{v1 + ".Circle", v1 + ".Square"}},
+		{"+ts:union=Circle," + v2 + ".Square", []string{v1 + ".Circle", v2 + ".Square"}},
+		{"+ts:union=" + v2 + ".Circle", []string{v2 + ".Circle"}},
+	}
+	c := validConfig(t, testConfig())
+	for _, tt := range tests {
+		iface := inPackage(v1, "Shape", tt.marker)
+		iface.Kind = types.Interface
+		var got []string
+		for _, typ := range unionTypes(iface, pkgs, c) {
+			got = append(got, typ.Name.String())
+		}
+		sort.Strings(got)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("unionTypes(%s) = %v, want %v", tt.marker, got, tt.want)
+		}
+	}
+}
+
+func TestTypeNameMarker(t *testing.T) {
+	pkgs := testPackages(t, "foo/v1")
+	tests := []struct {
+		typ  *types.Type
+		want string
+	}{
+		{findType(t, pkgs, "Renamed"), "MyRenamed"},
+		{findType(t, pkgs, "Replaced"), "Ignored"},
+		{testType("Blank", "+ts:name="), "Blank"},
+		{testType("Spaced", "+ts:name= Roomy "), "Roomy"},
+		{&types.Type{
+			Name:                      types.Name{Package: "example.com/apis/v1", Name: "Detached"},
+			Kind:                      types.Struct,
+			SecondClosestCommentLines: []string{"+ts:name=Attached"},
+		}, "Attached"},
+	}
+	c := validConfig(t, testConfig())
+	for _, tt := range tests {
+		if got := localTypeName(tt.typ, c, nil); got != tt.want {
+			t.Errorf("localTypeName(%s) = %q, want %q", tt.typ.Name.Name, got, tt.want)
+		}
+	}
+
+	testDisplayNames(t, testConfig(), [][3]string{
+		{"UsesRenamed", "R", "MyRenamed"},
+		{"UsesRenamed", "RS", "Ignored[]"},
+	})
+	// TypeReplacements win over the marker.
+	c = testConfig()
+	c.TypeReplacements["Replaced"] = "string"
+	testDisplayNames(t, c, [][3]string{
+		{"UsesRenamed", "RS", "string[]"},
+	})
+}
+
+func TestTypeNameTemplate(t *testing.T) {
+	pkgs := testPackages(t, "foo/v1")
+	pkg := pkgs[0]
+	tests := []struct {
+		template string
+		typ      string
+		want     string
+	}{
+		{"", "Widget", "Widget"},
+		{"{{.name}}", "Widget", "Widget"},
+		{"{{title .shortGroup}}{{.name}}", "Widget", "FooWidget"},
+		{"{{.name}}{{upper .version}}", "Widget", "WidgetV1"},
+		// the +ts:name marker wins over the template.
+		{"{{title .shortGroup}}{{.name}}", "Renamed", "MyRenamed"},
+	}
+	for _, tt := range tests {
+		c := validConfig(t, generatorConfig{TypeNameTemplate: tt.template})
+		if got := templatedTypeName(findType(t, pkgs, tt.typ), c, pkg); got != tt.want {
+			t.Errorf("typeNameTemplate %q: templatedTypeName(%s) = %q, want %q", tt.template, tt.typ, got, tt.want)
+		}
+	}
+}
+
+func TestTypeNameTemplateInvalid(t *testing.T) {
+	tests := []struct {
+		template string
+		err      string
+	}{
+		{"{{.name", "unclosed action"},
+		{"{{.nam}}", `map has no entry for key "nam"`},
+		{"{{.group}}", `"example.com" is not a valid type name`},
+		{"{{.name}}-{{.version}}", `"Foo-v1" is not a valid type name`},
+		{"{{shout .name}}", `function "shout" not defined`},
+	}
+	for _, tt := range tests {
+		c := generatorConfig{TypeNameTemplate: tt.template}
+		err := c.validate()
+		if err == nil || !strings.Contains(err.Error(), "invalid typeNameTemplate") || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("validate() with typeNameTemplate %q = %v, want an error with %q", tt.template, err, tt.err)
+		}
+	}
+}
+
+func TestImportStatements(t *testing.T) {
+	tests := []struct {
+		imports map[string]map[string]struct{}
+		want    string
+	}{
+		{nil, ""},
+		{map[string]map[string]struct{}{
+			"@k8s/meta": {"Time": {}, "ObjectMeta": {}},
+			"./common":  {"Quantity": {}},
+		}, "import { Quantity } from './common';\nimport { ObjectMeta, Time } from '@k8s/meta';\n\n"},
+	}
+	for _, tt := range tests {
+		if got := importStatements(tt.imports); got != tt.want {
+			t.Errorf("importStatements(%v) = %q, want %q", tt.imports, got, tt.want)
+		}
+	}
+}
+
+func TestExternalImports(t *testing.T) {
+	c := testConfig()
+	c.ExternalPackages[0].Import = "@k8s/meta"
+	c.ExternalTypes["k8s.io/apimachinery/pkg/apis/meta/v1"]["ObjectMeta"] = "ObjectMeta"
+	out := renderTemplate(t, "packages", testPackages(t, "foo/v1"), c)
+	if !strings.HasPrefix(out, "import { ObjectMeta } from '@k8s/meta';\n") {
+		t.Errorf("the output does not start with the import of ObjectMeta:\n%s", out)
+	}
+	assertContains(t, out, []string{"CustomResourceDefinition<ObjectMeta, WidgetSpec, WidgetStatus>"}, nil)
+}
+
+func TestIsExportedType(t *testing.T) {
+	pkgs := testPackages(t, "foo/v1")
+	tests := []struct {
+		markers []string
+		typ     *types.Type
+		want    bool
+	}{
+		{nil, findType(t, pkgs, "Widget"), true},
+		{nil, findType(t, pkgs, "WidgetSpec"), false},
+		{nil, findType(t, pkgs, "RootFalse"), false},
+		{nil, findType(t, pkgs, "RootCommented"), false},
+		{nil, findType(t, pkgs, "RootSpaced"), true},
+		{nil, findType(t, pkgs, "RootGenclient"), false},
+		{[]string{"genclient"}, findType(t, pkgs, "RootGenclient"), true},
+		{[]string{"genclient"}, findType(t, pkgs, "Widget"), false},
+		{[]string{"kubebuilder:resource"}, testType("Foo", "+kubebuilder:resource:path=foos"), true},
+		{[]string{"kubebuilder:resource"}, testType("Foo", "+kubebuilder:resourcePath=foos"), false},
+		{nil, testType("Foo", "+kubebuilder:object:root"), true},
+		{nil, testType("Foo", "+kubebuilder:object:root=yes"), false},
+	}
+	for _, tt := range tests {
+		c := testConfig()
+		c.RootKindMarkers = tt.markers
+		if got := isExportedType(tt.typ, c); got != tt.want {
+			t.Errorf("RootKindMarkers=%v: isExportedType(%s) = %v, want %v", tt.markers, tt.typ.Name.Name, got, tt.want)
+		}
+	}
+}
+
+func TestEnumNameAffixes(t *testing.T) {
+	c := testConfig()
+	c.EnumNamePrefix = "E"
+	c.EnumNameSuffix = "Enum"
+	testDisplayNames(t, c, [][3]string{
+		{"WidgetSpec", "Phase", "EPhaseEnum"},
+		{"Leveled", "Level", "ELevelEnum"},
+		{"WidgetSpec", "Ptr", "Part"},
+		{"WidgetSpec", "Names", "string[]"},
+	})
+	assertContains(t, renderTemplate(t, "packages", testPackages(t, "foo/v1"), c),
+		[]string{"export type EPhaseEnum = 'A' | 'B';", "phase: EPhaseEnum;"},
+		[]string{"type Phase ", "phase: Phase;"})
+
+	// the types whose constants are all hidden are not enums.
+	c.HideConstantPatterns = []string{`\.Phase[AB]$`}
+	testDisplayNames(t, c, [][3]string{
+		{"WidgetSpec", "Phase", "Phase"},
+		{"Leveled", "Level", "ELevelEnum"},
+	})
+}
+
+func TestWrapComments(t *testing.T) {
+	tests := []struct {
+		lines []string
+		width int
+		want  []string
+	}{
+		{[]string{"a long line that is not wrapped"}, 0, []string{"a long line that is not wrapped"}},
+		{[]string{"short"}, 20, []string{"short"}},
+		// 20 columns leave 17 once prefixed by " * ".
+		{[]string{"the quick brown fox jumps over the dog"}, 20, []string{"the quick brown", "fox jumps over", "the dog"}},
+		{[]string{"see {@link Part the part type} here"}, 20, []string{"see", "{@link Part the part type}", "here"}},
+		{[]string{"", "  indented code that is long enough", "@deprecated use the other field instead"}, 20,
+			[]string{"", "  indented code that is long enough", "@deprecated use the other field instead"}},
+		{[]string{"averyveryverylongword and more"}, 20, []string{"averyveryverylongword", "and more"}},
+		// markers are not documentation, whether wrapping or not.
+		{[]string{"Size of it.", "", "+optional", "+kubebuilder:validation:Pattern=^abc$"}, 20, []string{"Size of it."}},
+		{[]string{"Size of it.", " +optional", "More."}, 0, []string{"Size of it.", "More."}},
+	}
+	for _, tt := range tests {
+		if got := wrapComments(tt.lines, tt.width); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("wrapComments(%q, %d) = %q, want %q", tt.lines, tt.width, got, tt.want)
+		}
+	}
+
+	out := renderTemplate(t, "packages", testPackages(t, "foo/v1"), testConfig())
+	assertContains(t, out, []string{
+		"/**\n* Size of it.\n*/\nsize?: number;",
+		"/**\n* Raw is raw.\n*/\nraw?: { foo: string };",
+		"/**\n* Shape is a shape.\n*/\nexport type Shape = Circle | Square;",
+	}, []string{"* +"})
+}
+
+func TestCommentWrapWidth(t *testing.T) {
+	c := testConfig()
+	c.CommentWrapWidth = 60
+	assertContains(t, renderTemplate(t, "packages", testPackages(t, "foo/v1"), c), []string{
+		"* Wordy has a very long comment line that goes on and on\n" +
+			"* about nothing in particular, see\n" +
+			"* {@link Part the part type} for more.\n",
+		"* @deprecated this tag line is also long enough that it would need wrapping if it were prose text.\n",
+	}, nil)
+}
+
+func TestEmitUnexportedReferenced(t *testing.T) {
+	pkgs := testPackages(t, "foo/v1")
+	tests := []struct {
+		emit bool
+		typ  string
+		want string
+	}{
+		{false, "helperThing", "unexported"},
+		{false, "deeperThing", "unexported"},
+		{true, "helperThing", ""},
+		// referenced by a type only visible because it is referenced itself.
+		{true, "deeperThing", ""},
+		{true, "orphanThing", "unexported"},
+		{true, "UsesHelper", ""},
+	}
+	for _, tt := range tests {
+		c := testConfig()
+		c.EmitUnexportedReferenced = tt.emit
+		c = validConfig(t, c).withPackages(pkgs)
+		if got := hiddenReason(findType(t, pkgs, tt.typ), c); got != tt.want {
+			t.Errorf("emitUnexportedReferenced=%v: hiddenReason(%s) = %q, want %q", tt.emit, tt.typ, got, tt.want)
+		}
+	}
+
+	c := testConfig()
+	c.EmitUnexportedReferenced = true
+	assertContains(t, renderTemplate(t, "packages", pkgs, c),
+		[]string{"export interface helperThing {\ndeep: deeperThing;\n}", "export interface deeperThing {"},
+		[]string{"orphanThing"})
+	resetRun()
+	if refs := danglingReferences(pkgs, validConfig(t, c).withPackages(pkgs)); len(refs) > 0 {
+		t.Errorf("dangling references with emitUnexportedReferenced: %v", refs)
+	}
+}
+
+func TestFieldName(t *testing.T) {
+	tests := []struct {
+		fieldCase string
+		name      string
+		tags      string
+		want      string
+	}{
+		{"", "Size", `json:"size,omitempty"`, "size"},
+		{"", "Size", "", "Size"},
+		{fieldCaseGo, "Size", "", "Size"},
+		{fieldCaseCamel, "Size", "", "size"},
+		{fieldCaseCamel, "Size", `json:",omitempty"`, "size"},
+		{fieldCaseCamel, "Size", `json:"SIZE"`, "SIZE"},
+	}
+	for _, tt := range tests {
+		c := generatorConfig{DefaultFieldCase: tt.fieldCase}
+		if got := fieldName(testMember(tt.name, types.String, tt.tags), c); got != tt.want {
+			t.Errorf("defaultFieldCase=%q: fieldName(%s `%s`) = %q, want %q", tt.fieldCase, tt.name, tt.tags, got, tt.want)
+		}
+	}
+
+	c := generatorConfig{DefaultFieldCase: "snake"}
+	if err := c.validate(); err == nil || !strings.Contains(err.Error(), `unknown defaultFieldCase "snake"`) {
+		t.Errorf("validate() = %v, want an unknown defaultFieldCase error", err)
+	}
+}
+
+func TestMemberDocs(t *testing.T) {
+	tests := []struct {
+		tags     string
+		comments []string
+		want     []string
+	}{
+		{`json:"size"`, nil, nil},
+		{`json:"size"`, []string{"Size of it."}, []string{"Size of it."}},
+		{`json:"size" description:"Size of it.\nIn bytes."`, nil, []string{"Size of it.", "In bytes."}},
+		{`json:"size" description:"Size of the tag."`, []string{"Size of it."}, []string{"Size of it."}},
+		// markers alone are no documentation.
+		{`json:"size" description:"Size of the tag."`, []string{"+optional"}, []string{"Size of the tag."}},
+	}
+	for _, tt := range tests {
+		m := testMember("Size", types.Int32, tt.tags, tt.comments...)
+		if got := memberDocs(m); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("memberDocs(`%s`, %q) = %q, want %q", tt.tags, tt.comments, got, tt.want)
+		}
+	}
+}
+
+func TestDurationDisplayName(t *testing.T) {
+	duration := &types.Type{
+		Name:       types.Name{Package: "time", Name: "Duration"},
+		Kind:       types.Alias,
+		Underlying: types.Int64,
+	}
+	tests := []struct {
+		externalTypes map[string]map[string]string
+		typ           *types.Type
+		want          string
+	}{
+		{nil, duration, "string"},
+		{nil, &types.Type{Kind: types.Pointer, Elem: duration}, "string"},
+		{nil, &types.Type{Kind: types.Slice, Elem: duration}, "string[]"},
+		{map[string]map[string]string{"time": {"Duration": "number"}}, duration, "number"},
+		{map[string]map[string]string{"time": {"Time": "Date"}}, duration, "string"},
+	}
+	for _, tt := range tests {
+		c := validConfig(t, generatorConfig{ExternalTypes: tt.externalTypes})
+		if got := typeDisplayName(tt.typ, c, nil); got != tt.want {
+			t.Errorf("externalTypes=%v: typeDisplayName(%s) = %q, want %q", tt.externalTypes, tt.typ, got, tt.want)
+		}
+	}
+}
+
+func TestUnmappedTypes(t *testing.T) {
+	meta := func(name string) *types.Type {
+		return &types.Type{Name: types.Name{Package: "k8s.io/apimachinery/pkg/apis/meta/v1", Name: name}, Kind: types.Struct}
+	}
+	tests := []struct {
+		typ      *types.Type
+		unmapped string
+	}{
+		{types.String, ""},
+		{types.Int32, ""},
+		{types.Int64, "int64"},
+		{types.Float64, "float64"},
+		{meta("Time"), ""},
+		{meta("Duration"), "k8s.io/apimachinery/pkg/apis/meta/v1.Duration"},
+		{crdNumber, ""},
+		{crdIntOrString, ""},
+	}
+	c := validConfig(t, testConfig())
+	for _, tt := range tests {
+		resetRun()
+		typeDisplayName(tt.typ, c, nil)
+		var got string
+		for k := range report.unmappedTypes {
+			got = k
+		}
+		if got != tt.unmapped {
+			t.Errorf("typeDisplayName(%s) records the unmapped type %q, want %q", tt.typ, got, tt.unmapped)
+		}
+	}
+
+	// each unmapped type is reported once.
+	resetRun()
+	typeDisplayName(types.Int64, c, nil)
+	typeDisplayName(&types.Type{Kind: types.Slice, Elem: types.Int64}, c, nil)
+	if n := len(report.warnings[warnUnmappedType]); n != 1 {
+		t.Errorf("%d unmapped type warnings, want 1", n)
+	}
+}
+
+func TestExtraMembers(t *testing.T) {
+	pkgs := testPackages(t, "foo/v1")
+	tests := []struct {
+		extra   map[string][]string
+		wantErr string
+	}{
+		{nil, ""},
+		{map[string][]string{"Part": {"__typename?: 'Part';"}}, ""},
+		{map[string][]string{"Part": nil, "Nothing": nil}, `extraMembers refers to "Nothing"`},
+		// aliases have no body to add members to.
+		{map[string][]string{"Phase": nil}, `extraMembers refers to "Phase"`},
+	}
+	for _, tt := range tests {
+		err := checkExtraMembers(pkgs, generatorConfig{ExtraMembers: tt.extra})
+		if tt.wantErr == "" {
+			if err != nil {
+				t.Errorf("checkExtraMembers(%v) = %v, want no error", tt.extra, err)
+			}
+		} else if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("checkExtraMembers(%v) = %v, want an error containing %q", tt.extra, err, tt.wantErr)
+		}
+	}
+
+	c := testConfig()
+	c.ExtraMembers = map[string][]string{"Part": {"__typename?: 'Part';", "  extra: number;  "}}
+	assertContains(t, renderTemplate(t, "packages", pkgs, c),
+		[]string{"export interface Part {\nname: string;\n__typename?: 'Part';\nextra: number;\n}"}, nil)
+}
+
+func TestTypeSortOrder(t *testing.T) {
+	pkgs := testPackages(t, "foo/v1")
+	tests := []struct {
+		order string
+		want  []string
+	}{
+		{typeSortAlphabetical, []string{"UsesHelper", "deeperThing", "helperThing"}},
+		{typeSortSource, []string{"helperThing", "deeperThing", "UsesHelper"}},
+		// referrers come before the types they refer to.
+		{typeSortDependency, []string{"UsesHelper", "helperThing", "deeperThing"}},
+	}
+	for _, tt := range tests {
+		c := testConfig()
+		c.TypeSortOrder = tt.order
+		c = validConfig(t, c).withPackages(pkgs)
+		typs := []*types.Type{findType(t, pkgs, "deeperThing"), findType(t, pkgs, "UsesHelper"), findType(t, pkgs, "helperThing")}
+		if got := typeNames(sortTypes(typs, c)); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("typeSortOrder=%q: sortTypes() = %v, want %v", tt.order, got, tt.want)
+		}
+	}
+
+	c := testConfig()
+	c.TypeSortOrder = "random"
+	if err := c.validate(); err == nil || !strings.Contains(err.Error(), `unknown typeSortOrder "random"`) {
+		t.Errorf("validate() = %v, want an unknown typeSortOrder error", err)
+	}
+}
+
+func TestDependencyOrderCycles(t *testing.T) {
+	a, b, c := testType("A"), testType("B"), testType("C")
+	a.Members = []types.Member{testMember("B", b, `json:"b"`)}
+	b.Members = []types.Member{testMember("A", &types.Type{Kind: types.Pointer, Elem: a}, `json:"a"`)}
+	c.Members = []types.Member{testMember("A", a, `json:"a"`), testMember("C", c, `json:"c"`)}
+	got := typeNames(dependencyOrder([]*types.Type{b, a, c}, generatorConfig{}))
+	if want := []string{"C", "A", "B"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("dependencyOrder() = %v, want %v", got, want)
+	}
+}
+
+func TestConstantsOfTypeAliasChain(t *testing.T) {
+	alias := func(name string, underlying *types.Type) *types.Type {
+		return &types.Type{Name: types.Name{Package: "example.com/apis/v1", Name: name}, Kind: types.Alias, Underlying: underlying}
+	}
+	constant := func(name string, typ *types.Type) *types.Type {
+		value := strings.ToLower(name)
+		return &types.Type{Name: types.Name{Package: "example.com/apis/v1", Name: name}, Kind: types.DeclarationOf, Underlying: typ, ConstValue: &value}
+	}
+	color := alias("Color", types.String)
+	shade := alias("Shade", color)
+	tint := alias("Tint", shade)
+	other := alias("Other", types.String)
+	base := alias("Base", types.String)
+	mid := alias("Mid", base)
+	pkg := &apiPackage{
+		Types: []*types.Type{color, shade, tint, other, base, mid},
+		Constants: []*types.Type{
+			constant("Plain", color),
+			constant("Dark", shade),
+			constant("Pale", tint),
+			constant("Unrelated", other),
+			constant("Low", mid),
+		},
+	}
+	tests := []struct {
+		typ  *types.Type
+		want []string
+	}{
+		{color, []string{"Dark", "Pale", "Plain"}},
+		{shade, []string{"Dark", "Pale"}},
+		{tint, []string{"Pale"}},
+		// sharing the string base does not make constants related.
+		{other, []string{"Unrelated"}},
+		{types.String, nil},
+		// the types without constants of their own are not enums.
+		{base, nil},
+		{mid, []string{"Low"}},
+	}
+	c := validConfig(t, generatorConfig{})
+	for _, tt := range tests {
+		if got := typeNames(constantsOfType(tt.typ, indexConstants([]*apiPackage{pkg}), c)); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("constantsOfType(%s) = %v, want %v", tt.typ.Name.Name, got, tt.want)
+		}
+	}
+
+	enums := findEnumTypes([]*apiPackage{pkg}, c)
+	for _, typ := range []*types.Type{color, shade, tint, other, mid} {
+		if !enums[typ] {
+			t.Errorf("findEnumTypes() misses %s", typ.Name.Name)
+		}
+	}
+	if enums[base] {
+		t.Errorf("findEnumTypes() marks %s, which has no constant of its own", base.Name.Name)
+	}
+}
+
+func TestCheckMapKeys(t *testing.T) {
+	key := testType("Key")
+	mapOf := func(k, v *types.Type) *types.Type { return &types.Type{Kind: types.Map, Key: k, Elem: v} }
+	tests := []struct {
+		name    string
+		typ     *types.Type
+		wantErr string
+	}{
+		{"string keys", mapOf(types.String, types.String), ""},
+		{"integer keys", mapOf(types.Int32, types.String), ""},
+		{"struct keys", mapOf(key, types.String), "field example.com/apis/v1.Holder.Field is a map keyed by example.com/apis/v1.Key"},
+		{"nested struct keys", mapOf(types.String, mapOf(key, types.String)), "field example.com/apis/v1.Holder.Field is a map keyed by"},
+		{"slice of maps", &types.Type{Kind: types.Slice, Elem: mapOf(types.Bool, types.String)}, "keyed by bool"},
+	}
+	for _, tt := range tests {
+		holder := testType("Holder")
+		holder.Members = []types.Member{testMember("Field", tt.typ, `json:"field"`)}
+		pkgs := []*apiPackage{{Types: []*types.Type{key, holder}}}
+		for _, stringify := range []bool{false, true} {
+			c := validConfig(t, generatorConfig{StringifyMapKeys: stringify})
+			err := checkMapKeys(pkgs, c)
+			if tt.wantErr == "" || stringify {
+				if err != nil {
+					t.Errorf("%s: stringifyMapKeys=%v: checkMapKeys() = %v, want no error", tt.name, stringify, err)
+				}
+			} else if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("%s: checkMapKeys() = %v, want an error containing %q", tt.name, err, tt.wantErr)
+			}
+		}
+	}
+
+	// aliases of maps are checked too, and hidden fields are not.
+	byKey := &types.Type{Name: types.Name{Package: "example.com/apis/v1", Name: "ByKey"}, Kind: types.Alias, Underlying: mapOf(key, types.String)}
+	err := checkMapKeys([]*apiPackage{{Types: []*types.Type{byKey}}}, validConfig(t, generatorConfig{}))
+	if err == nil || !strings.Contains(err.Error(), "type example.com/apis/v1.ByKey is a map keyed by") {
+		t.Errorf("checkMapKeys() of a map alias = %v, want an error", err)
+	}
+	holder := testType("Holder")
+	holder.Members = []types.Member{testMember("Field", mapOf(key, types.String), `json:"field"`)}
+	c := validConfig(t, generatorConfig{HiddenMemberFields: []string{"Field"}})
+	if err := checkMapKeys([]*apiPackage{{Types: []*types.Type{holder}}}, c); err != nil {
+		t.Errorf("checkMapKeys() of a hidden field = %v, want no error", err)
+	}
+}
+
+func TestMapDisplayNameKeys(t *testing.T) {
+	level := &types.Type{Name: types.Name{Package: "example.com/apis/v1", Name: "Level"}, Kind: types.Alias, Underlying: types.Int}
+	key := testType("Key")
+	tests := []struct {
+		style   string
+		key     *types.Type
+		keyName string
+		want    string
+	}{
+		{"", types.String, "string", "Record<string, Part>"},
+		{"", types.Int32, "number", "Record<number, Part>"},
+		{"", types.Uint32, "uint32", "Record<number, Part>"},
+		{"", level, "Level", "Record<Level, Part>"},
+		// stringified keys marshal to text.
+		{"", key, "Key", "Record<string, Part>"},
+		{mapStyleIndex, types.Int64, "number", "{ [key: number]: Part }"},
+		{mapStyleIndex, level, "Level", "{ [key: number]: Part }"},
+		{mapStyleMap, types.Uint16, "uint16", "Map<number, Part>"},
+	}
+	for _, tt := range tests {
+		c := generatorConfig{MapStyle: tt.style}
+		if got := mapDisplayName(c, tt.key, tt.keyName, "Part"); got != tt.want {
+			t.Errorf("mapStyle=%q: mapDisplayName(%s) = %q, want %q", tt.style, tt.key, got, tt.want)
+		}
+	}
+}
+
+func TestAnchorID(t *testing.T) {
+	tests := []struct {
+		style string
+		in    string
+		want  string
+	}{
+		{"", "serving.knative.dev/v1alpha1", "serving.knative.dev/v1alpha1"},
+		{anchorIDStyleRaw, "My  Package\tName", "My-Package-Name"},
+		{anchorIDStyleSlug, "serving.knative.dev/v1alpha1", "serving-knative-dev-v1alpha1"},
+		{anchorIDStyleSlug, "foo.example.com/v1.Widget", "foo-example-com-v1-widget"},
+		{anchorIDStyleSlug, " --My Package!! ", "my-package"},
+	}
+	for _, tt := range tests {
+		if got := anchorID(tt.in, generatorConfig{AnchorIDStyle: tt.style}); got != tt.want {
+			t.Errorf("anchorIDStyle=%q: anchorID(%q) = %q, want %q", tt.style, tt.in, got, tt.want)
+		}
+	}
+
+	c := generatorConfig{AnchorIDStyle: "kebab"}
+	if err := c.validate(); err == nil || !strings.Contains(err.Error(), `unknown anchorIDStyle "kebab"`) {
+		t.Errorf("validate() = %v, want an unknown anchorIDStyle error", err)
+	}
+}
+
+func TestSyntheticTypes(t *testing.T) {
+	// the package of Thing is never parsed, gengo only knows its name.
+	thing := &types.Type{Name: types.Name{Package: "example.com/opaque/v1", Name: "Thing"}, Kind: types.Unknown}
+	tests := []struct {
+		synthetic   syntheticType
+		typ         *types.Type
+		want        string
+		wantImports map[string]map[string]struct{}
+	}{
+		{syntheticType{Type: "Record<string, unknown>"}, thing, "Record<string, unknown>", map[string]map[string]struct{}{}},
+		{syntheticType{Type: "Thing", Import: "@opaque/types"}, thing, "Thing",
+			map[string]map[string]struct{}{"@opaque/types": {"Thing": {}}}},
+		{syntheticType{Type: "Thing", Import: "@opaque/types"}, &types.Type{Kind: types.Slice, Elem: thing}, "Thing[]",
+			map[string]map[string]struct{}{"@opaque/types": {"Thing": {}}}},
+		{syntheticType{Type: "Thing", Import: "@opaque/types"}, &types.Type{Kind: types.Pointer, Elem: thing}, "Thing",
+			map[string]map[string]struct{}{"@opaque/types": {"Thing": {}}}},
+		// only identifiers can be imported.
+		{syntheticType{Type: "{ id: string }", Import: "@opaque/types"}, thing, "{ id: string }", map[string]map[string]struct{}{}},
+	}
+	for _, tt := range tests {
+		resetRun()
+		c := testConfig()
+		c.SyntheticTypes = map[string]syntheticType{"example.com/opaque/v1.Thing": tt.synthetic}
+		c = validConfig(t, c)
+		if got := typeDisplayName(tt.typ, c, nil); got != tt.want {
+			t.Errorf("%+v: typeDisplayName(%s) = %q, want %q", tt.synthetic, tt.typ, got, tt.want)
+		}
+		if !reflect.DeepEqual(externalImports, tt.wantImports) {
+			t.Errorf("%+v: imports = %v, want %v", tt.synthetic, externalImports, tt.wantImports)
+		}
+		if !report.usedConfigEntries[configEntry("syntheticTypes", "example.com/opaque/v1.Thing")] {
+			t.Errorf("%+v: the syntheticTypes entry is not marked as used", tt.synthetic)
+		}
+	}
+
+	c := testConfig()
+	c.SyntheticTypes = map[string]syntheticType{"example.com/opaque/v1.Thing": {Import: "@opaque/types"}}
+	if err := c.validate(); err == nil {
+		t.Error("validate() accepted a syntheticTypes entry without a type")
+	}
+}
+
+func TestMemberExample(t *testing.T) {
+	tests := []struct {
+		comments []string
+		want     []string
+	}{
+		{[]string{"Size of it."}, nil},
+		{[]string{"+ts:example=3"}, []string{"3"}},
+		{[]string{"+kubebuilder:example=3"}, []string{"3"}},
+		{[]string{"+ts:example={", "+ts:example=  size: 3,", "+ts:example=}"}, []string{"{", "  size: 3,", "}"}},
+		// the ts marker takes precedence.
+		{[]string{"+kubebuilder:example=4", "+ts:example=3"}, []string{"3"}},
+		{[]string{"+ts:example=/* 3 */"}, []string{"/* 3 *\\/"}},
+	}
+	for _, tt := range tests {
+		m := testMember("Size", types.Int32, `json:"size"`, tt.comments...)
+		if got := memberExample(m); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("memberExample(%q) = %q, want %q", tt.comments, got, tt.want)
+		}
+	}
+
+	holder := testType("Holder")
+	holder.Members = []types.Member{
+		testMember("Size", types.Int32, `json:"size"`, "+kubebuilder:example=3"),
+		testMember("Spec", types.String, `json:"spec"`, "+ts:example={", "+ts:example=  size: 3,", "+ts:example=}"),
+		testMember("Name", types.String, `json:"name"`, "Name of it."),
+	}
+	pkgs := []*apiPackage{{apiGroup: "example.com", apiVersion: "v1", Types: []*types.Type{holder}}}
+	assertContains(t, renderTemplate(t, "packages", pkgs, testConfig()), []string{
+		"/**\n* Name of it.\n*/\nname: string;",
+		"/**\n* @example\n* ```\n* 3\n* ```\n*/\nsize: number;",
+		"/**\n* @example\n* ```\n* {\n*   size: 3,\n* }\n* ```\n*/\nspec: string;",
+	}, nil)
+}
+
+func TestEmbeddedExternalTypes(t *testing.T) {
+	external := func(name string, members ...types.Member) *types.Type {
+		return &types.Type{Name: types.Name{Package: "k8s.io/apimachinery/pkg/apis/example/v1", Name: name}, Kind: types.Struct, Members: members}
+	}
+	ref := external("Reference", testMember("Name", types.String, `json:"name"`))
+	tests := []struct {
+		name          string
+		typ           *types.Type
+		externalTypes map[string]string
+		imp           string
+		kind          string
+		wantEmbedded  string
+		wantExtends   string
+		wantInterface bool
+		wantImports   map[string]map[string]struct{}
+		wantUnmapped  bool
+	}{
+		{
+			name:          "mapped",
+			typ:           ref,
+			externalTypes: map[string]string{"Reference": "Ref"},
+			wantEmbedded:  "Ref",
+			wantExtends:   "Ref",
+			wantInterface: true,
+		},
+		{
+			name:          "mapped with import",
+			typ:           ref,
+			externalTypes: map[string]string{"Reference": "Ref"},
+			imp:           "@k8s/example",
+			wantEmbedded:  "Ref",
+			wantExtends:   "Ref",
+			wantInterface: true,
+			wantImports:   map[string]map[string]struct{}{"@k8s/example": {"Ref": {}}},
+		},
+		{
+			name:          "imported by its Go name",
+			typ:           ref,
+			imp:           "@k8s/example",
+			wantEmbedded:  "Reference",
+			wantExtends:   "Reference",
+			wantInterface: true,
+			wantImports:   map[string]map[string]struct{}{"@k8s/example": {"Reference": {}}},
+		},
+		{
+			// its members are inlined, which an interface cannot extend.
+			name:         "unmapped",
+			typ:          ref,
+			wantEmbedded: "{ name: string; }",
+		},
+		{
+			name:          "unmapped without members",
+			typ:           external("Opaque"),
+			wantEmbedded:  "Opaque",
+			wantExtends:   "Opaque",
+			wantInterface: true,
+			wantUnmapped:  true,
+		},
+		{
+			name:          "type declarations",
+			typ:           ref,
+			externalTypes: map[string]string{"Reference": "Ref"},
+			kind:          declarationType,
+			wantEmbedded:  "Ref",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resetRun()
+			holder := testType("Holder")
+			holder.Members = []types.Member{{Name: tt.typ.Name.Name, Type: tt.typ, Embedded: true}}
+			c := testConfig()
+			c.ExternalTypes["k8s.io/apimachinery/pkg/apis/example/v1"] = tt.externalTypes
+			c.ExternalPackages[0].Import = tt.imp
+			c.DeclarationKind = tt.kind
+			c = validConfig(t, c)
+			typePkgMap := map[*types.Type]*apiPackage{holder: {}}
+
+			if got := embeddedDisplayName(tt.typ, c, typePkgMap); got != tt.wantEmbedded {
+				t.Errorf("embeddedDisplayName() = %q, want %q", got, tt.wantEmbedded)
+			}
+			if got, ok := interfaceExtends(holder, c, typePkgMap); got != tt.wantExtends || ok != tt.wantInterface {
+				t.Errorf("interfaceExtends() = %q, %v, want %q, %v", got, ok, tt.wantExtends, tt.wantInterface)
+			}
+			if tt.wantImports == nil {
+				tt.wantImports = map[string]map[string]struct{}{}
+			}
+			if !reflect.DeepEqual(externalImports, tt.wantImports) {
+				t.Errorf("imports = %v, want %v", externalImports, tt.wantImports)
+			}
+			if _, ok := report.unmappedTypes[typeIdentifier(tt.typ)]; ok != tt.wantUnmapped {
+				t.Errorf("unmapped = %v, want %v", ok, tt.wantUnmapped)
+			}
+		})
+	}
+}
+
+func TestKindLiterals(t *testing.T) {
+	typeMeta := &types.Type{
+		Name: types.Name{Package: "k8s.io/apimachinery/pkg/apis/meta/v1", Name: "TypeMeta"},
+		Kind: types.Struct,
+		Members: []types.Member{
+			testMember("APIVersion", types.String, `json:"apiVersion,omitempty"`),
+			testMember("Kind", types.String, `json:"kind,omitempty"`),
+		},
+	}
+	root := func(name string, members ...types.Member) *types.Type {
+		t := testType(name, "+kubebuilder:object:root=true")
+		t.Members = append(members, testMember("Size", types.Int32, `json:"size"`))
+		return t
+	}
+	embedded := root("Embedded", types.Member{Name: "TypeMeta", Type: typeMeta, Tags: `json:",inline"`, Embedded: true})
+	direct := root("Direct",
+		testMember("APIVersion", types.String, `json:"apiVersion"`),
+		testMember("Kind", types.String, `json:"kind"`))
+	kindOnly := root("KindOnly", testMember("Kind", types.String, `json:"kind"`))
+	neither := root("Neither")
+	spec := testType("Spec")
+	spec.Members = direct.Members
+	pkg := &apiPackage{apiGroup: "example.com", apiVersion: "v1", Types: []*types.Type{direct, embedded, kindOnly, neither, spec}}
+
+	tests := []struct {
+		typ  *types.Type
+		want bool
+	}{
+		{embedded, true},
+		{direct, true},
+		// both fields are needed, and only root kinds have literals.
+		{kindOnly, false},
+		{neither, false},
+		{spec, false},
+	}
+	for _, enabled := range []bool{false, true} {
+		c := testConfig()
+		c.KindLiterals = enabled
+		c = validConfig(t, c)
+		for _, tt := range tests {
+			var want []string
+			if enabled && tt.want {
+				want = []string{"apiVersion: 'example.com/v1';", "kind: '" + tt.typ.Name.Name + "';"}
+			}
+			if got := kindLiterals(tt.typ, c, pkg); !reflect.DeepEqual(got, want) {
+				t.Errorf("kindLiterals=%v: kindLiterals(%s) = %q, want %q", enabled, tt.typ.Name.Name, got, want)
+			}
+		}
+	}
+
+	c := testConfig()
+	c.KindLiterals = true
+	assertContains(t, renderTemplate(t, "packages", []*apiPackage{pkg}, c), []string{
+		"export interface Direct {\napiVersion: 'example.com/v1';\nkind: 'Direct';\nsize: number;\n}",
+		"export interface Embedded {\napiVersion: 'example.com/v1';\nkind: 'Embedded';\nsize: number;\n}",
+		"export interface KindOnly {\nkind: string;\nsize: number;\n}",
+		"export interface Neither {\nsize: number;\n}",
+		"export interface Spec {\napiVersion: string;\nkind: string;\nsize: number;\n}",
+	}, nil)
+}
+
+func TestKindRegistry(t *testing.T) {
+	root := func(pkg, name string) *types.Type {
+		t := testType(name, "+kubebuilder:object:root=true")
+		t.Name.Package = pkg
+		return t
+	}
+	foo, bar := root("example.com/apis/foo/v1", "Foo"), root("example.com/apis/foo/v1", "Bar")
+	baz, otherFoo := root("example.com/apis/baz/v1", "Baz"), root("example.com/apis/baz/v1", "Foo")
+	spec := testType("FooSpec")
+	pkgs := []*apiPackage{
+		{apiGroup: "foo.example.com", apiVersion: "v1", Types: []*types.Type{foo, spec, bar}},
+		{apiGroup: "baz.example.com", apiVersion: "v1", Types: []*types.Type{otherFoo, baz}},
+	}
+
+	resetRun()
+	c := validConfig(t, testConfig())
+	got := kindRegistry(pkgs, c, extractTypeToPackageMap(pkgs))
+	want := []kindEntry{{"Bar", "Bar"}, {"Baz", "Baz"}, {"Foo", "Foo"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("kindRegistry() = %v, want %v", got, want)
+	}
+	// the first package declaring a kind keeps it.
+	wantWarnings := []string{"kind Foo of baz.example.com/v1 is already registered by foo.example.com/v1"}
+	if warnings := report.warnings[warnKindRegistry]; !reflect.DeepEqual(warnings, wantWarnings) {
+		t.Errorf("warnings = %q, want %q", warnings, wantWarnings)
+	}
+
+	for _, emit := range []bool{false, true} {
+		c := testConfig()
+		c.EmitKindRegistry = emit
+		registry := "export interface KindRegistry {\n'Bar': Bar;\n'Baz': Baz;\n'Foo': Foo;\n}"
+		want, unwanted := []string{registry}, []string(nil)
+		if !emit {
+			want, unwanted = nil, []string{"KindRegistry"}
+		}
+		assertContains(t, renderTemplate(t, "packages", pkgs, c), want, unwanted)
+	}
+}
+
+func TestUnserializableMembers(t *testing.T) {
+	fn := &types.Type{Name: types.Name{Name: "func()"}, Kind: types.Func}
+	ch := &types.Type{Name: types.Name{Name: "chan int"}, Kind: types.Chan, Elem: types.Int}
+	tests := []struct {
+		typ  *types.Type
+		want bool
+	}{
+		{types.String, false},
+		{&types.Type{Kind: types.Slice, Elem: types.String}, false},
+		{fn, true},
+		{ch, true},
+		{&types.Type{Kind: types.Pointer, Elem: ch}, true},
+		{&types.Type{Kind: types.Slice, Elem: fn}, true},
+		{&types.Type{Kind: types.Map, Key: types.String, Elem: fn}, true},
+	}
+	for _, tt := range tests {
+		if got := isUnserializable(tt.typ); got != tt.want {
+			t.Errorf("isUnserializable(%s) = %v, want %v", tt.typ, got, tt.want)
+		}
+	}
+
+	holder := testType("Holder")
+	holder.Members = []types.Member{
+		testMember("Name", types.String, `json:"name"`),
+		testMember("OnChange", fn, `json:"-"`),
+		testMember("Events", ch, `json:"events"`),
+		testMember("Size", types.Int32, `json:"size"`),
+	}
+	pkgs := []*apiPackage{{apiGroup: "example.com", apiVersion: "v1", Types: []*types.Type{holder}}}
+	c := validConfig(t, testConfig())
+	resetRun()
+	warnUnserializableMembers(pkgs, c)
+	want := []string{
+		"field example.com/apis/v1.Holder.OnChange has the unserializable type func(), omitting it",
+		"field example.com/apis/v1.Holder.Events has the unserializable type chan int, omitting it",
+	}
+	if warnings := report.warnings[warnUnserializable]; !reflect.DeepEqual(warnings, want) {
+		t.Errorf("warnings = %q, want %q", warnings, want)
+	}
+	assertContains(t, renderTemplate(t, "packages", pkgs, c),
+		[]string{"export interface Holder {\nname: string;\nsize: number;\n}"}, nil)
+}
+
+func TestEnumDocTable(t *testing.T) {
+	mode := &types.Type{Name: types.Name{Package: "example.com/apis/v1", Name: "Mode"}, Kind: types.Alias, Underlying: types.String}
+	constant := func(name, value string, comments ...string) *types.Type {
+		return &types.Type{Name: types.Name{Package: "example.com/apis/v1", Name: name}, Kind: types.DeclarationOf, Underlying: mode, ConstValue: &value, CommentLines: comments}
+	}
+	pkg := &apiPackage{
+		Types: []*types.Type{mode},
+		Constants: []*types.Type{
+			constant("ModeFast", "fast", "ModeFast is fast", "or quick | speedy.", "+kubebuilder:default"),
+			constant("ModeSlow", "slow"),
+			constant("ModeOdd", "it's", "Ends */ early."),
+		},
+	}
+	tests := []struct {
+		enabled bool
+		typ     *types.Type
+		want    []string
+	}{
+		{false, mode, nil},
+		{true, mode, []string{
+			"| Value | Description |",
+			"| --- | --- |",
+			"| `'fast'` | ModeFast is fast or quick \\| speedy. |",
+			"| `'it\\'s'` | Ends *\\/ early. |",
+			"| `'slow'` |  |",
+		}},
+		// only enums get a table.
+		{true, testType("Holder"), nil},
+	}
+	for _, tt := range tests {
+		c := validConfig(t, generatorConfig{EnumDocTable: tt.enabled})
+		if got := enumDocTable(tt.typ, indexConstants([]*apiPackage{pkg}), c); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("enumDocTable=%v: enumDocTable(%s) = %q, want %q", tt.enabled, tt.typ.Name.Name, got, tt.want)
+		}
+	}
+
+	c := testConfig()
+	c.EnumDocTable = true
+	assertContains(t, renderTemplate(t, "packages", testPackages(t, "foo/v1"), c), []string{
+		"/**\n* Phase is the phase.\n*\n* | Value | Description |\n* | --- | --- |\n* | `'A'` | PhaseA is a. |\n* | `'B'` |  |\n*/\nexport type Phase = 'A' | 'B';",
+	}, nil)
+}
+
+func TestNullableElements(t *testing.T) {
+	part := &types.Type{Name: types.Name{Package: "example.com/apis/v1", Name: "Part"}, Kind: types.Struct}
+	ptr := &types.Type{Kind: types.Pointer, Elem: part}
+	slice := func(elem *types.Type) *types.Type { return &types.Type{Kind: types.Slice, Elem: elem} }
+	mapOf := func(elem *types.Type) *types.Type { return &types.Type{Kind: types.Map, Key: types.String, Elem: elem} }
+	typePkgMap := map[*types.Type]*apiPackage{part: {}}
+	tests := []struct {
+		typ      *types.Type
+		want     string
+		nullable string
+	}{
+		{slice(ptr), "Part[]", "(Part | null)[]"},
+		{mapOf(ptr), "Record<string, Part>", "Record<string, Part | null>"},
+		// only pointer elements are nullable.
+		{slice(part), "Part[]", "Part[]"},
+		{mapOf(part), "Record<string, Part>", "Record<string, Part>"},
+		{slice(slice(ptr)), "Part[][]", "(Part | null)[][]"},
+		{mapOf(slice(ptr)), "Record<string, Part[]>", "Record<string, (Part | null)[]>"},
+		{slice(mapOf(ptr)), "Record<string, Part>[]", "Record<string, Part | null>[]"},
+	}
+	for _, nullable := range []bool{false, true} {
+		c := testConfig()
+		c.NullablePointers = nullable
+		c = validConfig(t, c)
+		for _, tt := range tests {
+			want := tt.want
+			if nullable {
+				want = tt.nullable
+			}
+			if got := typeDisplayName(tt.typ, c, typePkgMap); got != want {
+				t.Errorf("nullablePointers=%v: typeDisplayName(%s) = %q, want %q", nullable, tt.typ, got, want)
+			}
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/pkg/errors"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// indexFileName is the name of the barrel file re-exporting the others.
+const indexFileName = "index.ts"
+
+// tsExportPattern matches the names exported by a TypeScript declaration.
+var tsExportPattern = regexp.MustCompile(`(?m)^export\s+(?:declare\s+)?(?:type|interface|const|enum|class|function)\s+([A-Za-z_$][A-Za-z0-9_$]*)`)
+
+// writeIndex regenerates the index.ts barrel of dir, re-exporting the other
+// TypeScript files in it. It fails if two files export the same name, since
+// the barrel would then be ambiguous.
+func writeIndex(dir string) error {
+	files, err := filepath.Glob(filepath.Join(dir, "*.ts"))
+	if err != nil {
+		return err
+	}
+	sort.Strings(files)
+
+	var b bytes.Buffer
+	exportedBy := make(map[string]string)
+	for _, f := range files {
+		name := filepath.Base(f)
+		if name == indexFileName || strings.HasSuffix(name, ".d.ts") {
+			continue
+		}
+		src, err := ioutil.ReadFile(f)
+		if err != nil {
+			return err
+		}
+		exports := tsExportPattern.FindAllSubmatch(src, -1)
+		if len(exports) == 0 {
+			log.Warningf("%s exports nothing, leaving it out of the index", f)
+			continue
+		}
+		for _, m := range exports {
+			export := string(m[1])
+			if other, ok := exportedBy[export]; ok {
+				return errors.Errorf("%s is exported by both %s and %s", export, other, name)
+			}
+			exportedBy[export] = name
+		}
+		fmt.Fprintf(&b, "export * from './%s';\n", strings.TrimSuffix(name, ".ts"))
+	}
+	if b.Len() == 0 {
+		return errors.Errorf("no TypeScript files to index in %s", dir)
+	}
+	return ioutil.WriteFile(filepath.Join(dir, indexFileName), b.Bytes(), 0644)
+}
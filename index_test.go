@@ -0,0 +1,54 @@
+package main
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestWriteIndex(t *testing.T) {
+	tests := []struct {
+		name    string
+		files   map[string]string
+		want    string
+		wantErr string
+	}{
+		{"barrel", map[string]string{
+			"foo.ts":     "export interface Foo {}\nexport type Bar = string;\n",
+			"enums.ts":   "export declare const Mode: { Fast: 'fast' };\n",
+			"empty.ts":   "type Local = string;\n",
+			"types.d.ts": "export interface Ambient {}\n",
+			"index.ts":   "export * from './stale';\n",
+			"notes.md":   "export interface Doc {}\n",
+		}, "export * from './enums';\nexport * from './foo';\n", ""},
+		{"duplicate", map[string]string{
+			"a.ts": "export interface Foo {}\n",
+			"b.ts": "export type Foo = string;\n",
+		}, "", "Foo is exported by both a.ts and b.ts"},
+		{"nothing", map[string]string{
+			"index.ts": "export * from './foo';\n",
+		}, "", "no TypeScript files to index"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			writeTree(t, tt.files)
+			err := writeIndex(".")
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("writeIndex() = %v, want an error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, err := ioutil.ReadFile(indexFileName)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(b) != tt.want {
+				t.Errorf("index.ts = %q, want %q", b, tt.want)
+			}
+		})
+	}
+}
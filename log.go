@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"k8s.io/klog"
+)
+
+// logger receives the human-facing messages of the generator. Debug messages
+// (klog.V) and fatal errors are not routed through it.
+type logger interface {
+	Infof(format string, args ...interface{})
+	Warningf(format string, args ...interface{})
+	Errorf(format string, args ...interface{})
+}
+
+// log is the logger in use, replaced with a quietLogger by the -quiet flag.
+var log logger = klogLogger{}
+
+// klogLogger writes all messages to klog.
+type klogLogger struct{}
+
+func (klogLogger) Infof(format string, args ...interface{}) {
+	klog.InfoDepth(1, fmt.Sprintf(format, args...))
+}
+
+func (klogLogger) Warningf(format string, args ...interface{}) {
+	klog.WarningDepth(1, fmt.Sprintf(format, args...))
+}
+
+func (klogLogger) Errorf(format string, args ...interface{}) {
+	klog.ErrorDepth(1, fmt.Sprintf(format, args...))
+}
+
+// quietLogger drops everything but errors.
+type quietLogger struct{}
+
+func (quietLogger) Infof(format string, args ...interface{}) {}
+
+func (quietLogger) Warningf(format string, args ...interface{}) {}
+
+func (quietLogger) Errorf(format string, args ...interface{}) {
+	klog.ErrorDepth(1, fmt.Sprintf(format, args...))
+}
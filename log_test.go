@@ -0,0 +1,48 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"strings"
+	"testing"
+
+	"k8s.io/klog"
+)
+
+func TestLoggers(t *testing.T) {
+	var b bytes.Buffer
+	klog.SetOutput(&b)
+	flag.Set("logtostderr", "false")
+	flag.Set("alsologtostderr", "false")
+	defer func() {
+		flag.Set("logtostderr", "true")
+		flag.Set("alsologtostderr", "true")
+	}()
+
+	tests := []struct {
+		name string
+		l    logger
+		want []string
+	}{
+		{"klog", klogLogger{}, []string{"the info", "the warning", "the error"}},
+		{"quiet", quietLogger{}, []string{"the error"}},
+	}
+	for _, tt := range tests {
+		b.Reset()
+		tt.l.Infof("the %s", "info")
+		tt.l.Warningf("the %s", "warning")
+		tt.l.Errorf("the %s", "error")
+		for _, msg := range []string{"the info", "the warning", "the error"} {
+			want := false
+			for _, w := range tt.want {
+				want = want || w == msg
+			}
+			if got := strings.Contains(b.String(), msg); got != want {
+				t.Errorf("%s logger: logged %q = %v, want %v", tt.name, msg, got, want)
+			}
+		}
+		if !strings.Contains(b.String(), "log_test.go:") {
+			t.Errorf("%s logger: the messages do not point to their caller:\n%s", tt.name, b.String())
+		}
+	}
+}
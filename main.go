@@ -2,10 +2,14 @@ package main
 
 import (
 	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"flag"
 	"fmt"
 	"github.com/pkg/errors"
+	"go/token"
 	"io"
 	"io/ioutil"
 	"k8s.io/gengo/parser"
@@ -13,49 +17,544 @@ import (
 	"k8s.io/klog"
 	"net/http"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"reflect"
 	"regexp"
 	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"text/tabwriter"
 	"text/template"
 	"time"
 )
 
+// version is set at build time by goreleaser.
+var version = "dev"
+
 var (
-	flConfig      = flag.String("config", "", "path to config file")
+	flConfig      = flag.String("config", "", "comma-separated paths to config files merged in order, or - to read it from stdin")
 	flAPIDir      = flag.String("api-dir", "", "api directory (or import path), point this to pkg/apis")
+	flCRDDir      = flag.String("crd-dir", "", "directory of CustomResourceDefinition YAML manifests to generate the types from, instead of -api-dir")
 	flTemplateDir = flag.String("template-dir", "template", "path to template/ dir")
 
 	flHTTPAddr           = flag.String("http-addr", "", "start an HTTP server on specified addr to view the result (e.g. :8080)")
 	flOutFile            = flag.String("out-file", "", "path to output file to save the result")
+	flHTTPTimeout        = flag.Duration("http-timeout", time.Minute, "maximum time to generate the result of an HTTP request before answering 503 (0 to wait indefinitely)")
+	flQuiet              = flag.Bool("quiet", false, "only log errors")
+	flEnumsOutFile       = flag.String("enums-out-file", "", "path to a separate TypeScript file to save the enum declarations to, imported by the -out-file one (requires -out-file, there is no -out-dir mode)")
+	flManifest           = flag.String("manifest", "", "path to a file to save a JSON record of the generated output to (requires -out-file)")
+	flDumpModel          = flag.String("dump-model", "", "path to a file to save the parsed API model to as JSON, instead of rendering it")
+	flVersionFilter      = flag.String("version-filter", "", "only generate one apiVersion per apiGroup, either \"latest\" or an explicit version (e.g. v1beta1)")
+	flTargetVersion      = flag.String("target-version", "", "hide the fields introduced after this version (+ts:since=<version>) or removed by it (+ts:until=<version>), e.g. v1.24")
+	flListTypes          = flag.Bool("list-types", false, "print the discovered types with their package, visibility and whether they are root kinds, without rendering them")
+	flDryRun             = flag.Bool("dry-run", false, "render the result without saving it and print a summary of what would be generated")
+	flSpecOnly           = flag.Bool("spec-only", false, "only generate the spec types of the root kinds and the types they reference")
+	flFormat             = flag.String("format", formatTypeScript, "comma-separated output formats, \"typescript\" and/or \"openapi\" (OpenAPI v3 component schemas); several formats require a {format} placeholder in -out-file")
+	flIndexOnly          = flag.String("index-only", "", "only regenerate the index.ts barrel re-exporting the TypeScript files in the given directory, without parsing any API")
+	flStrictConfig       = flag.Bool("strict-config", false, "fail if any typeReplacements, externalTypes, syntheticTypes or hideTypePatterns entry never matched (with -dry-run or -out-file)")
+	flStrictTypes        = flag.Bool("strict-types", false, "fail if any type has no TypeScript mapping and is rendered as is (with -dry-run or -out-file)")
+	flPostCmd            = flag.String("post-cmd", "", "command (e.g. \"prettier --parser typescript\") receiving the TypeScript output on stdin, whose stdout replaces it")
+	flIncludeVendor      = flag.Bool("include-vendor", false, "also consider the packages under vendor/ directories as API packages (they still need a +groupName)")
+	flCPUProfile         = flag.String("cpuprofile", "", "path to a file to write a CPU profile of the run to (on exit, or on interrupt with -http-addr)")
+	flMemProfile         = flag.String("memprofile", "", "path to a file to write a heap profile to at the end of the run (or on interrupt with -http-addr)")
+	flStrictVersions     = flag.Bool("strict-versions", false, "fail if any API package has no apiVersion inferable from its name, instead of skipping it")
+	flValidateTemplates  = flag.Bool("validate-templates", false, "only check that the templates of -template-dir parse and render a synthetic API, without parsing any API (-config is optional)")
+	flStrictParse        = flag.Bool("strict-parse", false, "fail if any Go file in the api directory cannot be parsed, instead of silently skipping its package")
+	flStrictReferences   = flag.Bool("strict-references", false, "fail if any field of a rendered type refers to a hidden type, which the output does not declare (with -dry-run or -out-file)")
 	runtimeExternalTypes []*types.Type
+
+	// externalImports collects the TypeScript names of the external types
+	// referenced while rendering, by the module they are imported from.
+	externalImports = make(map[string]map[string]struct{})
 )
 
 const (
 	docCommentForceIncludes = "// +gencrdrefdocs:force"
+
+	memberOrderSource       = "source"
+	memberOrderAlphabetical = "alphabetical"
+
+	enumStyleUnion   = "union"
+	enumStyleAsConst = "asconst"
+
+	mapStyleRecord = "record"
+	mapStyleIndex  = "index"
+	mapStyleMap    = "map"
+
+	formatStyleJSDoc   = "jsdoc"
+	formatStyleBranded = "branded"
+
+	formatTypeScript = "typescript"
+	formatOpenAPI    = "openapi"
+
+	optionalStyleQuestion       = "question"
+	optionalStyleUndefinedUnion = "undefined-union"
+
+	typeSortDefault      = "default"
+	typeSortAlphabetical = "alphabetical"
+	typeSortSource       = "source"
+	typeSortDependency   = "dependency"
+
+	anchorIDStyleRaw  = "raw"
+	anchorIDStyleSlug = "slug"
+
+	fieldCaseGo    = "go"
+	fieldCaseCamel = "camel"
+
+	declarationType      = "type"
+	declarationInterface = "interface"
 )
 
 type generatorConfig struct {
+	// APIDir is used when -api-dir is not specified. Relative paths are
+	// resolved against the directory of the config file.
+	APIDir string `json:"apiDir"`
+
+	// TemplateDir is used when -template-dir is not specified. Relative paths
+	// are resolved against the directory of the config file.
+	TemplateDir string `json:"templateDir"`
+
 	// HiddenMemberFields hides fields with specified names on all types.
 	HiddenMemberFields []string `json:"hideMemberFields"`
 
+	// HideUntaggedFields hides the fields without a json tag, except the
+	// embedded ones whose fields are flattened into their parent.
+	HideUntaggedFields bool `json:"hideUntaggedFields"`
+
+	// RootFieldExclude hides the fields with the given JSON names from the
+	// root kinds only, e.g. "metadata" and "status" to keep the fields
+	// relevant to authoring them. Defaults to none. The ResourceDefinitions of
+	// the default templates still type the metadata and status of the root
+	// kinds, see rootFieldType. The apiVersion and kind fields of TypeMeta are
+	// hidden with HiddenMemberFields instead.
+	RootFieldExclude []string `json:"rootFieldExclude"`
+
+	// KindLiterals renders the apiVersion and kind fields of the root kinds as
+	// required string literals (e.g. "kind: 'Widget';"), when they have both,
+	// whether declared directly or through an embedded type like TypeMeta.
+	KindLiterals bool `json:"kindLiterals"`
+
+	// HideTagOptions hides the fields whose json tag carries any of the
+	// specified options (e.g. "omitempty").
+	HideTagOptions []string `json:"hideTagOptions"`
+
+	// ExcludeFilePatterns drops the types and constants declared in the Go
+	// files whose name matches any of the glob patterns (e.g.
+	// "zz_generated.*.go").
+	ExcludeFilePatterns []string `json:"excludeFilePatterns"`
+
 	// HideTypePatterns hides types matching the specified patterns from the
 	// output.
 	HideTypePatterns []string `json:"hideTypePatterns"`
 
+	// HideConstantPatterns hides constants matching the specified patterns
+	// from the enums they belong to, while keeping their types.
+	HideConstantPatterns []string `json:"hideConstantPatterns"`
+
+	// hideTypePatterns and hideConstantPatterns are the compiled
+	// HideTypePatterns and HideConstantPatterns, set by validate.
+	hideTypePatterns     []*regexp.Regexp
+	hideConstantPatterns []*regexp.Regexp
+
 	// ExternalPackages lists recognized external package references and how to
 	// link to them.
 	ExternalPackages []externalPackage `json:"externalPackages"`
 
 	ExternalTypes map[string]map[string]string `json:"externalTypes"`
 
+	// SyntheticTypes maps fully-qualified Go types (e.g.
+	// "example.com/opaque/v1.Thing") to the TypeScript type they are rendered
+	// as, without the Go type having to be parsed. Useful for references to
+	// packages that cannot be loaded at all.
+	SyntheticTypes map[string]syntheticType `json:"syntheticTypes"`
+
+	// DropExternalMembers lists external types (e.g.
+	// "k8s.io/apimachinery/pkg/apis/meta/v1.OwnerReference") whose fields are
+	// omitted from all types.
+	DropExternalMembers []string `json:"dropExternalMembers"`
+
 	TypeReplacements map[string]string `json:"typeReplacements"`
 
+	// ExtraMembers lists raw TypeScript member lines (e.g.
+	// "__typename?: 'Widget';") appended to the body of struct types, keyed by
+	// their Go name.
+	ExtraMembers map[string][]string `json:"extraMembers"`
+
+	// SliceTemplate is a Go template rendering slice types from the type of
+	// their elements ({{.type}}). Defaults to "{{.type}}[]".
 	SliceTemplate string `json:"sliceTemplate"`
+
+	// sliceTemplate is the compiled SliceTemplate, set by validate.
+	sliceTemplate *template.Template
+
+	// TypeNameTemplate is a Go template transforming the names of the API
+	// types without an entry in TypeReplacements. It receives the type name
+	// ({{.name}}), its Go package ({{.package}}), its apiGroup ({{.group}}),
+	// the first label of the apiGroup ({{.shortGroup}}) and its apiVersion
+	// ({{.version}}), along with the title, upper and lower functions.
+	TypeNameTemplate string `json:"typeNameTemplate"`
+
+	// typeNameTemplate is the compiled TypeNameTemplate, set by validate.
+	typeNameTemplate *template.Template
+
+	// MemberOrder controls the order of the fields within a generated type,
+	// either "source" (default) or "alphabetical".
+	MemberOrder string `json:"memberOrder"`
+
+	// TypeSortOrder controls the order of the types within a package:
+	// "default" (root kinds first, then by name), "alphabetical", "source"
+	// (by declaration in the Go files) or "dependency" (each type before the
+	// types its fields refer to, cycles being broken by the default order).
+	TypeSortOrder string `json:"typeSortOrder"`
+
+	// OptionalFromOmitempty marks fields with the "omitempty" json option as
+	// optional, in addition to the ones with the +optional marker. Defaults to
+	// true.
+	OptionalFromOmitempty *bool `json:"optionalFromOmitempty"`
+
+	// OptionalCollectionsFromOmitempty overrides OptionalFromOmitempty for the
+	// slice and map fields, e.g. to render "items: Foo[]" for consumers
+	// defaulting missing collections to empty ones. Defaults to
+	// OptionalFromOmitempty.
+	OptionalCollectionsFromOmitempty *bool `json:"optionalCollectionsFromOmitempty"`
+
+	// RequiredByDefault makes fields required unless they are marked
+	// optional. When false, fields are optional unless they have the
+	// +kubebuilder:validation:Required marker, as in CRD validation. Defaults
+	// to true.
+	RequiredByDefault *bool `json:"requiredByDefault"`
+
+	// OptionalStyle controls how optional fields are rendered, either as
+	// "field?: T" ("question", default) or as "field: T | undefined"
+	// ("undefined-union").
+	OptionalStyle string `json:"optionalStyle"`
+
+	// DefaultFieldCase controls the name of the fields without a json tag,
+	// either the Go field name verbatim as encoding/json marshals it ("go",
+	// default) or with its first letter lowercased ("camel"), as the
+	// Kubernetes API conventions name the JSON fields.
+	DefaultFieldCase string `json:"defaultFieldCase"`
+
+	// NullablePointers adds "| null" to the type of the pointer fields, which
+	// may be explicitly null. It combines with the optionality of the fields:
+	// an optional pointer renders as "field?: T | null", a required one as
+	// "field: T | null", while non-pointer fields never get "| null". Pointer
+	// elements of slices and values of maps are nullable too, e.g.
+	// "(T | null)[]" and "Record<string, T | null>".
+	NullablePointers bool `json:"nullablePointers"`
+
+	// EnumStyle controls how types with constants are rendered, either as a
+	// union of their values ("union", default) or as a const object of their
+	// values with a union type derived from it ("asconst").
+	EnumStyle string `json:"enumStyle"`
+
+	// MapStyle controls how maps are rendered, either as Record<K, V>
+	// ("record", default), as an index signature ("index") or as Map<K, V>
+	// ("map"). Beware that "map" does not describe the decoded JSON, which
+	// holds plain objects: consumers have to convert them to Map instances.
+	MapStyle string `json:"mapStyle"`
+
+	// DeclarationKind controls how struct types are declared, either as
+	// interfaces extending their embedded types ("interface", default) or as
+	// type aliases of object types intersected with them ("type"). Structs
+	// whose embedded types cannot be extended, like inlined external types,
+	// stay type aliases.
+	DeclarationKind string `json:"declarationKind"`
+
+	// StringifyMapKeys renders the maps keyed by types that are neither strings
+	// nor integers (e.g. structs implementing encoding.TextMarshaler) as keyed
+	// by strings, with a note on their fields. Such maps are an error
+	// otherwise.
+	StringifyMapKeys bool `json:"stringifyMapKeys"`
+
+	// EmptyEnumType is the type emitted for an enum whose constants are all
+	// hidden. Defaults to the underlying type of the enum.
+	EmptyEnumType string `json:"emptyEnumType"`
+
+	// EnumNamePrefix and EnumNameSuffix are added to the names of the types
+	// having constants (e.g. "Enum"), where they are declared and referenced.
+	EnumNamePrefix string `json:"enumNamePrefix"`
+	EnumNameSuffix string `json:"enumNameSuffix"`
+
+	// FormatStyle controls how the +kubebuilder:validation:Format marker of
+	// string fields is rendered: ignored (default), as a @format JSDoc tag
+	// ("jsdoc") or as a string type branded with the format ("branded").
+	FormatStyle string `json:"formatStyle"`
+
+	// EnumMemberComments renders each value of an enum on its own line,
+	// preceded by the doc comment of its constant.
+	EnumMemberComments bool `json:"enumMemberComments"`
+
+	// EmitContentHash starts the TypeScript output with a comment holding a
+	// short hash of the rest of the output, to detect changes.
+	EmitContentHash bool `json:"emitContentHash"`
+
+	// TopPragmas lists lines written at the very top of the TypeScript output,
+	// before the content hash, e.g. "// @ts-nocheck" or "/* eslint-disable */".
+	TopPragmas []string `json:"topPragmas"`
+
+	// CommentWrapWidth word-wraps the rendered doc comments to the given
+	// width. Defaults to 0, which keeps the lines of the Go comments.
+	CommentWrapWidth int `json:"commentWrapWidth"`
+
+	// PackageDisplayNames overrides the displayed name (and anchor) of
+	// packages, keyed by "<apiGroup>/<apiVersion>" or by Go import path.
+	PackageDisplayNames map[string]string `json:"packageDisplayNames"`
+
+	// IdentifierSeparator separates the apiGroup and apiVersion in the
+	// displayed name (and anchor) of packages without a PackageDisplayNames
+	// entry, e.g. "-" for "serving.knative.dev-v1". Defaults to "/". Config
+	// keys always use "/".
+	IdentifierSeparator string `json:"identifierSeparator"`
+
+	// AnchorIDStyle controls the anchor IDs of packages and types, either
+	// their display names with whitespace replaced ("raw", default) or
+	// lowercased with runs of other characters than letters and digits
+	// replaced by hyphens ("slug"), which suit URL fragments and CSS
+	// selectors.
+	AnchorIDStyle string `json:"anchorIDStyle"`
+
+	// EmitSourceLinks adds a comment pointing at the Go source file of each
+	// generated type.
+	EmitSourceLinks bool `json:"emitSourceLinks"`
+
+	// TemplatesByGroup selects the template rendering a package, keyed by
+	// "<apiGroup>/<apiVersion>" or by apiGroup. Packages without an entry are
+	// rendered with the "package" template.
+	TemplatesByGroup map[string]string `json:"templatesByGroup"`
+
+	// ExcludeDeprecated hides the types documented as deprecated, either with
+	// a "Deprecated:" paragraph or the +deprecatedversion marker.
+	ExcludeDeprecated bool `json:"excludeDeprecated"`
+
+	// EmitUnexportedReferenced keeps the types whose name starts lowercase
+	// when visible types refer to them, instead of hiding them.
+	EmitUnexportedReferenced bool `json:"emitUnexportedReferenced"`
+
+	// RootKindMarkers lists the markers flagging the root kinds (e.g.
+	// "genclient" or "kubebuilder:resource"). Defaults to
+	// "kubebuilder:object:root".
+	RootKindMarkers []string `json:"rootKindMarkers"`
+
+	// GroupByGroupOnly merges all the apiVersions of an apiGroup into a single
+	// package, keyed by the apiGroup alone. Each type keeps track of its
+	// apiVersion, which is documented with a @version tag.
+	GroupByGroupOnly bool `json:"groupByGroupOnly"`
+
+	// EmitTypeMetadataTags documents every type with the @group and @version
+	// JSDoc tags of the apiGroup and apiVersion it comes from.
+	EmitTypeMetadataTags bool `json:"emitTypeMetadataTags"`
+
+	// AmbientDeclarations declares all types with "declare", for ambient .d.ts
+	// output without any runtime code. It cannot be combined with the
+	// "asconst" EnumStyle, which emits constants.
+	AmbientDeclarations bool `json:"ambientDeclarations"`
+
+	// EnumDocTable documents enum types with a table of their values and the
+	// doc comments of their constants.
+	EnumDocTable bool `json:"enumDocTable"`
+
+	// EmitKindRegistry emits a KindRegistry interface mapping the kind of each
+	// root kind to its type, for typed lookups of decoded objects by kind.
+	EmitKindRegistry bool `json:"emitKindRegistry"`
+
+	// MaxReferenceDepth bounds how many reference hops -spec-only follows from
+	// the spec types of the root kinds, which are at depth 1. 0 means no
+	// limit.
+	MaxReferenceDepth int `json:"maxReferenceDepth"`
+
+	// enumTypes holds the types having constants, whose names are decorated
+	// with EnumNamePrefix and EnumNameSuffix, set by withPackages.
+	enumTypes map[*types.Type]bool
+
+	// typeRanks holds the position of the types in the TypeSortOrder, when it
+	// is not the default one, set by withPackages.
+	typeRanks map[*types.Type]int
+
+	// unexportedReferenced holds the lowercase-named types kept by
+	// EmitUnexportedReferenced, set by withPackages.
+	unexportedReferenced map[*types.Type]bool
+
+	// report is the runReport of the render of the HTTP server using c, see
+	// currentReport.
+	report *runReport
+
+	// targetVersion, outFile and enumsOutFile are the -target-version,
+	// -out-file and -enums-out-file flags, set by main.
+	targetVersion string
+	outFile       string
+	enumsOutFile  string
+}
+
+// currentReport returns the runReport the renders using c record their
+// errors, warnings and usage in: the one of their HTTP render, or else the one
+// of the command line run.
+func (c generatorConfig) currentReport() *runReport {
+	if c.report != nil {
+		return c.report
+	}
+	return report
+}
+
+// withPackages returns a copy of c with the settings derived from the API
+// packages to render computed for pkgs.
+func (c generatorConfig) withPackages(pkgs []*apiPackage) generatorConfig {
+	c.unexportedReferenced = nil
+	if c.EmitUnexportedReferenced {
+		c.unexportedReferenced = findUnexportedReferenced(pkgs, c)
+	}
+	c.enumTypes = findEnumTypes(pkgs, c)
+	c.typeRanks = rankTypes(pkgs, c)
+	return c
+}
+
+// requiredByDefault reports the RequiredByDefault setting, taking its default
+// into account.
+func (c generatorConfig) requiredByDefault() bool {
+	return c.RequiredByDefault == nil || *c.RequiredByDefault
+}
+
+// optionalFromOmitempty reports the OptionalFromOmitempty setting, taking its
+// default into account.
+func (c generatorConfig) optionalFromOmitempty() bool {
+	return c.OptionalFromOmitempty == nil || *c.OptionalFromOmitempty
+}
+
+// optionalCollectionsFromOmitempty reports the
+// OptionalCollectionsFromOmitempty setting, taking its default into account.
+func (c generatorConfig) optionalCollectionsFromOmitempty() bool {
+	if c.OptionalCollectionsFromOmitempty == nil {
+		return c.optionalFromOmitempty()
+	}
+	return *c.OptionalCollectionsFromOmitempty
+}
+
+// declarationKind reports the DeclarationKind setting, taking its default
+// into account.
+func (c generatorConfig) declarationKind() string {
+	if c.DeclarationKind == "" {
+		return declarationInterface
+	}
+	return c.DeclarationKind
+}
+
+// validate reports the first invalid setting in the config, and compiles its
+// templates and patterns.
+func (c *generatorConfig) validate() error {
+	tpl, err := compileSliceTemplate(c.SliceTemplate)
+	if err != nil {
+		return err
+	}
+	c.sliceTemplate = tpl
+	if c.typeNameTemplate, err = compileTypeNameTemplate(c.TypeNameTemplate); err != nil {
+		return err
+	}
+	if c.hideTypePatterns, err = compilePatterns("hideTypePatterns", c.HideTypePatterns); err != nil {
+		return err
+	}
+	if c.hideConstantPatterns, err = compilePatterns("hideConstantPatterns", c.HideConstantPatterns); err != nil {
+		return err
+	}
+	switch c.MemberOrder {
+	case "", memberOrderSource, memberOrderAlphabetical:
+	default:
+		return errors.Errorf("unknown memberOrder %q", c.MemberOrder)
+	}
+	switch c.EnumStyle {
+	case "", enumStyleUnion, enumStyleAsConst:
+	default:
+		return errors.Errorf("unknown enumStyle %q", c.EnumStyle)
+	}
+	switch c.OptionalStyle {
+	case "", optionalStyleQuestion, optionalStyleUndefinedUnion:
+	default:
+		return errors.Errorf("unknown optionalStyle %q", c.OptionalStyle)
+	}
+	switch c.TypeSortOrder {
+	case "", typeSortDefault, typeSortAlphabetical, typeSortSource, typeSortDependency:
+	default:
+		return errors.Errorf("unknown typeSortOrder %q", c.TypeSortOrder)
+	}
+	switch c.AnchorIDStyle {
+	case "", anchorIDStyleRaw, anchorIDStyleSlug:
+	default:
+		return errors.Errorf("unknown anchorIDStyle %q", c.AnchorIDStyle)
+	}
+	switch c.DefaultFieldCase {
+	case "", fieldCaseGo, fieldCaseCamel:
+	default:
+		return errors.Errorf("unknown defaultFieldCase %q", c.DefaultFieldCase)
+	}
+	switch c.FormatStyle {
+	case "", formatStyleJSDoc, formatStyleBranded:
+	default:
+		return errors.Errorf("unknown formatStyle %q", c.FormatStyle)
+	}
+	for _, p := range c.ExcludeFilePatterns {
+		if _, err := filepath.Match(p, ""); err != nil {
+			return errors.Errorf("invalid excludeFilePatterns entry %q", p)
+		}
+	}
+	if c.AmbientDeclarations && c.EnumStyle == enumStyleAsConst {
+		return errors.Errorf("ambientDeclarations cannot be combined with the %q enumStyle, which emits runtime constants", enumStyleAsConst)
+	}
+	switch c.DeclarationKind {
+	case "", declarationType, declarationInterface:
+	default:
+		return errors.Errorf("unknown declarationKind %q", c.DeclarationKind)
+	}
+	switch c.MapStyle {
+	case "", mapStyleRecord, mapStyleIndex, mapStyleMap:
+	default:
+		return errors.Errorf("unknown mapStyle %q", c.MapStyle)
+	}
+	if c.MaxReferenceDepth < 0 {
+		return errors.Errorf("invalid maxReferenceDepth %d", c.MaxReferenceDepth)
+	}
+	for id, v := range c.SyntheticTypes {
+		if v.Type == "" {
+			return errors.Errorf("syntheticTypes[%q] has no type", id)
+		}
+	}
+	return nil
+}
+
+// compilePatterns compiles the regular expressions of the setting.
+func compilePatterns(setting string, patterns []string) ([]*regexp.Regexp, error) {
+	var out []*regexp.Regexp
+	for _, p := range patterns {
+		r, err := regexp.Compile(p)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid %s entry %q", setting, p)
+		}
+		out = append(out, r)
+	}
+	return out, nil
+}
+
+// syntheticType is the TypeScript rendering of a Go type declared in config.
+type syntheticType struct {
+	// Type is the TypeScript type the Go type is rendered as.
+	Type string `json:"type"`
+
+	// Import is an optional TypeScript module specifier (e.g. "@my/types")
+	// Type is imported from.
+	Import string `json:"import"`
 }
 
 type externalPackage struct {
 	TypeMatchPrefix string `json:"typeMatchPrefix"`
+
+	// DocsURLTemplate is an optional Go template producing the documentation
+	// URL of a matched type. It receives the Go package ({{.package}}), the Go
+	// type name ({{.name}}) and the TypeScript type name ({{.type}}).
+	DocsURLTemplate string `json:"docsURLTemplate"`
+
+	// Import is an optional TypeScript module specifier (e.g. "@my/types")
+	// the names of the matched types are imported from.
+	Import string `json:"import"`
 }
 
 type apiPackage struct {
@@ -64,31 +563,94 @@ type apiPackage struct {
 	GoPackages []*types.Package
 	Types      []*types.Type // because multiple 'types.Package's can add types to an apiVersion
 	Constants  []*types.Type
+
+	// typeVersions records the apiVersion of each type of a package merging
+	// several apiVersions (see GroupByGroupOnly), whose apiVersion is empty.
+	typeVersions map[*types.Type]string
+
+	// versionSuffixed holds the types of a package merging several
+	// apiVersions whose name is declared by more than one of them. They are
+	// named with their apiVersion as a suffix (e.g. WidgetV1alpha1), so that
+	// the declarations and anchors stay unique.
+	versionSuffixed map[*types.Type]bool
+}
+
+func (v *apiPackage) identifier() string {
+	return v.identifierWith("/")
 }
 
-func (v *apiPackage) identifier() string { return fmt.Sprintf("%s/%s", v.apiGroup, v.apiVersion) }
+// identifierWith returns the identifier of the package with sep between its
+// apiGroup and apiVersion.
+func (v *apiPackage) identifierWith(sep string) string {
+	if v.apiVersion == "" {
+		return v.apiGroup
+	}
+	return v.apiGroup + sep + v.apiVersion
+}
+
+// versionOf returns the apiVersion of t, a type of the package.
+func (v *apiPackage) versionOf(t *types.Type) string {
+	if s, ok := v.typeVersions[t]; ok {
+		return s
+	}
+	return v.apiVersion
+}
 
 func init() {
 	klog.InitFlags(nil)
 	flag.Set("alsologtostderr", "true") // for klog
+}
+
+// parseFlags parses and checks the command-line flags. It is called from main
+// rather than init, so that the tests can register their own flags.
+func parseFlags() {
 	flag.Parse()
 
+	if *flQuiet {
+		log = quietLogger{}
+	}
+	if *flIndexOnly != "" || *flValidateTemplates {
+		// no API is parsed, so nothing else is needed.
+		return
+	}
 	if *flConfig == "" {
 		panic("-config not specified")
 	}
-	if *flAPIDir == "" {
-		panic("-api-dir not specified")
-	}
-	if *flHTTPAddr == "" && *flOutFile == "" {
-		panic("-out-file or -http-addr must be specified")
+	if *flHTTPAddr == "" && *flOutFile == "" && *flDumpModel == "" && !*flDryRun && !*flListTypes {
+		panic("-out-file, -http-addr, -dump-model, -dry-run or -list-types must be specified")
 	}
 	if *flHTTPAddr != "" && *flOutFile != "" {
 		panic("only -out-file or -http-addr can be specified")
 	}
-	if err := resolveTemplateDir(*flTemplateDir); err != nil {
-		panic(err)
+	for _, f := range outputFormats() {
+		if f != formatTypeScript && f != formatOpenAPI {
+			panic("-format must list \"typescript\" or \"openapi\"")
+		}
 	}
+	if len(outputFormats()) > 1 && !strings.Contains(*flOutFile, formatPlaceholder) {
+		panic("several -format values require -out-file with a " + formatPlaceholder + " placeholder")
+	}
+	if *flManifest != "" && *flOutFile == "" {
+		panic("-manifest requires -out-file")
+	}
+	if *flEnumsOutFile != "" && *flOutFile == "" {
+		panic("-enums-out-file requires -out-file")
+	}
+	if _, ok := parseVersion(*flTargetVersion); *flTargetVersion != "" && !ok {
+		panic("-target-version must be a version like v1.24")
+	}
+}
 
+// formatPlaceholder is replaced by the format in the -out-file path.
+const formatPlaceholder = "{format}"
+
+// outputFormats returns the formats listed by -format.
+func outputFormats() []string {
+	var out []string
+	for _, f := range strings.Split(*flFormat, ",") {
+		out = append(out, strings.TrimSpace(f))
+	}
+	return out
 }
 
 func resolveTemplateDir(dir string) error {
@@ -104,83 +666,796 @@ func resolveTemplateDir(dir string) error {
 	return nil
 }
 
+// readConfig reads the config file at path, or from stdin if path is "-".
+func readConfig(path string) ([]byte, error) {
+	if path == "-" {
+		return ioutil.ReadAll(os.Stdin)
+	}
+	return ioutil.ReadFile(path)
+}
+
+// decodeConfig decodes the config b into c, rejecting unknown settings.
+func decodeConfig(b []byte, c *generatorConfig) error {
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.DisallowUnknownFields()
+	return d.Decode(c)
+}
+
+// loadConfigs reads the config files at paths, applies their paths (see
+// applyConfigPaths) and returns them merged in order by mergeConfig. A
+// single file is returned as is.
+func loadConfigs(paths []string) ([]byte, error) {
+	var merged interface{}
+	var raw []byte
+	for _, path := range paths {
+		path = strings.TrimSpace(path)
+		b, err := readConfig(path)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to open config file")
+		}
+		var c generatorConfig
+		if err := decodeConfig(b, &c); err != nil {
+			return nil, errors.Wrapf(err, "failed to parse %s", path)
+		}
+		applyConfigPaths(c, path)
+
+		var v interface{}
+		if err := json.Unmarshal(b, &v); err != nil {
+			return nil, errors.Wrapf(err, "failed to parse %s", path)
+		}
+		merged = mergeConfig(merged, v)
+		raw = b
+	}
+	if len(paths) == 1 {
+		return raw, nil
+	}
+	return json.Marshal(merged)
+}
+
+// mergeConfig merges the decoded JSON config override into base: objects are
+// merged key by key, and other values, arrays included, are replaced. A list
+// can so be emptied or reordered, at the cost of repeating its entries to
+// extend it.
+func mergeConfig(base, override interface{}) interface{} {
+	o, ok := override.(map[string]interface{})
+	if !ok {
+		return override
+	}
+	b, ok := base.(map[string]interface{})
+	if !ok {
+		return o
+	}
+	for k, v := range o {
+		b[k] = mergeConfig(b[k], v)
+	}
+	return b
+}
+
+// applyConfigPaths sets -api-dir and -template-dir from the config when they
+// are not given on the command line. Relative paths in the config are resolved
+// against the directory containing the config file, or the working directory
+// when it is read from stdin.
+func applyConfigPaths(c generatorConfig, configPath string) {
+	set := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	base := filepath.Dir(configPath)
+
+	if !set["api-dir"] && c.APIDir != "" {
+		*flAPIDir = c.APIDir
+		// only local paths are resolved, others are Go import paths
+		if dir := filepath.ToSlash(c.APIDir); dir == "." || dir == ".." ||
+			strings.HasPrefix(dir, "./") || strings.HasPrefix(dir, "../") {
+			*flAPIDir = localDirPath(filepath.Join(base, c.APIDir))
+		}
+	}
+	if !set["template-dir"] && c.TemplateDir != "" {
+		*flTemplateDir = c.TemplateDir
+		if !filepath.IsAbs(c.TemplateDir) {
+			*flTemplateDir = filepath.Join(base, c.TemplateDir)
+		}
+	}
+}
+
+// localDirPath turns dir into a path relative to the working directory that
+// the go/build package recognizes as a local directory (i.e. "./foo"), as
+// absolute paths cannot be parsed. go/build only recognizes forward slashes,
+// so the result uses them on all platforms.
+func localDirPath(dir string) string {
+	wd, err := os.Getwd()
+	if err != nil {
+		return dir
+	}
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return dir
+	}
+	rel, err := filepath.Rel(wd, abs)
+	if err != nil {
+		return dir
+	}
+	rel = filepath.ToSlash(rel)
+	if rel != "." && rel != ".." && !strings.HasPrefix(rel, "../") {
+		rel = "./" + rel
+	}
+	return rel
+}
+
 func main() {
+	parseFlags()
 	wd, err := os.Getwd()
 	if err != nil {
 		klog.Fatalf("failed to local current working directory")
 	}
-	klog.Infof("working directory is %s", wd)
+	log.Infof("working directory is %s", wd)
 	defer klog.Flush()
 
-	f, err := os.Open(*flConfig)
-	if err != nil {
-		klog.Fatalf("failed to open config file: %+v", err)
+	stopProfiles, err := startProfiles(*flCPUProfile, *flMemProfile)
+	if err != nil {
+		klog.Fatal(err)
+	}
+	defer stopProfiles()
+
+	if *flIndexOnly != "" {
+		if err := writeIndex(*flIndexOnly); err != nil {
+			klog.Fatalf("failed to write the index: %+v", err)
+		}
+		log.Infof("index written to %s", filepath.Join(*flIndexOnly, indexFileName))
+		return
+	}
+
+	var rawConfig []byte
+	var config generatorConfig
+	if *flConfig != "" {
+		// only -validate-templates runs without a config.
+		rawConfig, err = loadConfigs(strings.Split(*flConfig, ","))
+		if err != nil {
+			klog.Fatalf("failed to parse config file: %+v", err)
+		}
+		if err := decodeConfig(rawConfig, &config); err != nil {
+			klog.Fatalf("failed to parse config file: %+v", err)
+		}
+	}
+	if err := config.validate(); err != nil {
+		klog.Fatalf("invalid config file: %+v", err)
+	}
+	config.targetVersion, config.outFile, config.enumsOutFile = *flTargetVersion, *flOutFile, *flEnumsOutFile
+
+	if *flValidateTemplates {
+		if err := resolveTemplateDir(*flTemplateDir); err != nil {
+			klog.Fatal(err)
+		}
+		if err := validateTemplates(config); err != nil {
+			fmt.Fprintf(os.Stderr, "invalid templates in %s:\n%v\n", *flTemplateDir, err)
+			os.Exit(1)
+		}
+		log.Infof("templates in %s are valid", *flTemplateDir)
+		return
+	}
+	if config.AmbientDeclarations && *flOutFile != "" && !strings.HasSuffix(*flOutFile, ".d.ts") {
+		log.Warningf("ambientDeclarations is meant for .d.ts files, but -out-file is %s", *flOutFile)
+	}
+	if *flAPIDir == "" && *flCRDDir == "" {
+		klog.Fatalf("-api-dir or -crd-dir not specified")
+	}
+	if err := resolveTemplateDir(*flTemplateDir); err != nil {
+		klog.Fatal(err)
+	}
+
+	var apiPackages []*apiPackage
+	if *flCRDDir != "" {
+		log.Infof("parsing CRD manifests in directory %s", *flCRDDir)
+		apiPackages, err = parseCRDPackages(*flCRDDir)
+		if err != nil {
+			klog.Fatal(err)
+		}
+		if len(apiPackages) == 0 {
+			klog.Fatalf("no CustomResourceDefinitions found in %s", *flCRDDir)
+		}
+	} else {
+		log.Infof("parsing go packages in directory %s", *flAPIDir)
+		if *flStrictParse {
+			if err := checkParse(*flAPIDir); err != nil {
+				klog.Fatalf("strict parse failed: %v", err)
+			}
+		}
+		pkgs, err := parseAPIPackages(*flAPIDir)
+		if err != nil {
+			klog.Fatal(err)
+		}
+		if len(pkgs) == 0 {
+			klog.Fatalf("no API packages found in %s", *flAPIDir)
+		}
+		for _, p := range pkgs {
+			if err := excludeFiles(p, config.ExcludeFilePatterns); err != nil {
+				klog.Fatal(err)
+			}
+		}
+
+		apiPackages, err = combineAPIPackages(pkgs, *flStrictVersions)
+		if err != nil {
+			klog.Fatal(err)
+		}
+		if len(apiPackages) == 0 {
+			klog.Fatalf("no API packages with an apiVersion found in %s", *flAPIDir)
+		}
+	}
+
+	if *flVersionFilter != "" {
+		filtered := filterVersions(apiPackages, *flVersionFilter)
+		if len(filtered) == 0 {
+			klog.Fatalf("no API packages left with -version-filter=%s", *flVersionFilter)
+		}
+		for _, v := range crossPackageReferences(filtered, apiPackages) {
+			warnf(warnCrossPackage, "%s", v)
+		}
+		apiPackages = filtered
+	}
+
+	if config.GroupByGroupOnly {
+		apiPackages = mergeVersions(apiPackages, config)
+	}
+
+	if *flSpecOnly {
+		apiPackages = specSubtrees(apiPackages, config)
+	}
+
+	config = config.withPackages(apiPackages)
+
+	reportDanglingReferences(apiPackages, config, *flStrictReferences)
+
+	if err := checkExtraMembers(apiPackages, config); err != nil {
+		klog.Fatalf("invalid config file: %v", err)
+	}
+	warnUnserializableMembers(apiPackages, config)
+	if err := checkMapKeys(apiPackages, config); err != nil {
+		klog.Fatal(err)
+	}
+	anchors := make(map[string]string)
+	for _, p := range apiPackages {
+		name := packageDisplayName(p, config)
+		id := anchorID(name, config)
+		if other, ok := anchors[id]; ok {
+			warnf(warnAnchorID, "packages %s and %s have the same anchor ID %q", other, name, id)
+		}
+		anchors[id] = name
+	}
+	idx := precompute(apiPackages)
+
+	mkOutput := func(r *runReport) (string, error) {
+		c := config
+		c.report = r
+		var b bytes.Buffer
+		// only files can hold several formats, so use the first one.
+		err := writeResult(&b, outputFormats()[0], apiPackages, idx, c, *flPostCmd)
+		return b.String(), err
+	}
+
+	if *flListTypes {
+		listTypes(os.Stdout, apiPackages, config)
+		return
+	}
+
+	if *flDumpModel != "" {
+		var b bytes.Buffer
+		if err := dumpModel(&b, apiPackages, config); err != nil {
+			klog.Fatalf("failed to serialize the model: %+v", err)
+		}
+		if err := ioutil.WriteFile(*flDumpModel, b.Bytes(), 0644); err != nil {
+			klog.Fatalf("failed to write to model file: %v", err)
+		}
+		log.Infof("model written to %s", *flDumpModel)
+		return
+	}
+
+	if *flDryRun {
+		if _, err := mkOutput(report); err != nil {
+			klog.Fatalf("failed: %+v", err)
+		}
+		printSummary(os.Stdout, apiPackages, config)
+		printReport(config)
+		return
+	}
+
+	if *flOutFile != "" {
+		for _, format := range outputFormats() {
+			path := strings.Replace(*flOutFile, formatPlaceholder, format, -1)
+			dir := filepath.Dir(path)
+			if err := os.MkdirAll(dir, 0755); err != nil {
+				klog.Fatalf("failed to create dir %s: %v", dir, err)
+			}
+			// stream to the file, the output of large APIs can be big.
+			err := writeFileAtomic(path, func(w io.Writer) error { return writeResult(w, format, apiPackages, idx, config, *flPostCmd) })
+			if err != nil {
+				klog.Fatalf("failed to write to out file: %+v", err)
+			}
+			log.Infof("written to %s", path)
+		}
+
+		if *flEnumsOutFile != "" {
+			b := bytes.NewBufferString(topPragmas(config))
+			nw := newNormalizingWriter(b)
+			if err := render(nw, "enums", apiPackages, idx, config); err != nil {
+				klog.Fatalf("failed to render the enums: %+v", err)
+			}
+			if err := nw.Close(); err != nil {
+				klog.Fatalf("failed to render the enums: %v", err)
+			}
+			out := b.Bytes()
+			if *flPostCmd != "" {
+				var err error
+				if out, err = runPostCmd(*flPostCmd, out); err != nil {
+					klog.Fatalf("failed: %+v", err)
+				}
+			}
+			err := writeFileAtomic(*flEnumsOutFile, func(w io.Writer) error {
+				_, err := w.Write(out)
+				return err
+			})
+			if err != nil {
+				klog.Fatalf("failed to write to enums file: %v", err)
+			}
+			log.Infof("enums written to %s", *flEnumsOutFile)
+		}
+
+		if *flManifest != "" {
+			var b bytes.Buffer
+			if err := writeManifest(&b, buildManifest(apiPackages, config, rawConfig)); err != nil {
+				klog.Fatalf("failed to serialize the manifest: %+v", err)
+			}
+			if err := writeFileAtomic(*flManifest, func(w io.Writer) error {
+				_, err := b.WriteTo(w)
+				return err
+			}); err != nil {
+				klog.Fatalf("failed to write to manifest file: %v", err)
+			}
+			log.Infof("manifest written to %s", *flManifest)
+		}
+		printReport(config)
+	}
+
+	if *flHTTPAddr != "" {
+		render := func(r *runReport) (string, error) {
+			s, err := mkOutput(r)
+			if !*flQuiet {
+				r.print(os.Stderr, isTerminal(os.Stderr))
+			}
+			return s, err
+		}
+		http.Handle("/", renderHandler(&sharedRender{render: render}, *flHTTPTimeout))
+		srv := &http.Server{
+			Addr:              *flHTTPAddr,
+			ReadHeaderTimeout: 10 * time.Second,
+			ReadTimeout:       30 * time.Second,
+		}
+		if *flHTTPTimeout > 0 {
+			// leave time to send the result once it is generated.
+			srv.WriteTimeout = *flHTTPTimeout + 30*time.Second
+		}
+		log.Infof("server listening at %s", *flHTTPAddr)
+		klog.Fatal(srv.ListenAndServe())
+	}
+}
+
+// renderHandler serves the results of renders, failing with 503 Service
+// Unavailable when a render takes longer than timeout (if non-zero), and with
+// 500 Internal Server Error along with the last good result when it fails.
+func renderHandler(renders *sharedRender, timeout time.Duration) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		now := time.Now()
+		defer func() { log.Infof("request took %v", time.Since(now)) }()
+
+		var expired <-chan time.Time
+		if timeout > 0 {
+			expired = time.After(timeout)
+		}
+		res := renders.start()
+		select {
+		case <-res.done:
+		case <-expired:
+			// the render goes on, and the next requests wait for it.
+			http.Error(w, fmt.Sprintf("generation did not finish within %v", timeout), http.StatusServiceUnavailable)
+			log.Errorf("generation timed out after %v", timeout)
+			return
+		}
+		if res.err != nil {
+			// keep showing the last good result while the templates are
+			// being edited, under the error.
+			log.Errorf("failed: %+v", res.err)
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprint(w, errorBanner(res.err, res.s != ""), res.s)
+			return
+		}
+		if err := writeOutput(w, r, res.s); err != nil {
+			log.Errorf("response write error: %v", err)
+		}
+	}
+}
+
+// renderResult renders pkgs to w in the given output format: the OpenAPI
+// schemas, or the TypeScript types prefixed, with EmitContentHash, with the
+// hash of their body.
+func renderResult(w io.Writer, format string, pkgs []*apiPackage, idx renderIndex, c generatorConfig) error {
+	if format == formatOpenAPI {
+		return errors.Wrap(writeOpenAPI(w, pkgs, c), "failed to render the OpenAPI schemas")
+	}
+	if _, err := io.WriteString(w, topPragmas(c)); err != nil {
+		return err
+	}
+	if !c.EmitContentHash {
+		nw := newNormalizingWriter(w)
+		if err := render(nw, "packages", pkgs, idx, c); err != nil {
+			return errors.Wrap(err, "failed to render the result")
+		}
+		return nw.Close()
+	}
+
+	// the hash covers the normalized body, so it has to be held first.
+	var b bytes.Buffer
+	nw := newNormalizingWriter(&b)
+	if err := render(nw, "packages", pkgs, idx, c); err != nil {
+		return errors.Wrap(err, "failed to render the result")
+	}
+	if err := nw.Close(); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintf(w, "// content-hash: %s\n%s", contentHash(b.Bytes()), b.Bytes())
+	return err
+}
+
+// writeFileAtomic writes the file at path with write, through a temporary
+// file of the same directory renamed over path once write succeeds, so that a
+// failed render leaves the previous file intact.
+func writeFileAtomic(path string, write func(w io.Writer) error) error {
+	f, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name()) // fails once renamed.
+	if err := write(f); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	// TempFile creates the file readable by its owner only.
+	if err := os.Chmod(f.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// topPragmas renders the TopPragmas of c, one per line.
+func topPragmas(c generatorConfig) string {
+	var b strings.Builder
+	for _, v := range c.TopPragmas {
+		b.WriteString(strings.TrimSpace(v) + "\n")
+	}
+	return b.String()
+}
+
+// contentHash returns a short hash of the output body b, which changes
+// whenever the body does.
+func contentHash(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:4])
+}
+
+// writeResult renders pkgs to w in the given output format like renderResult,
+// piping the TypeScript output through the command line postCmd if not empty.
+func writeResult(w io.Writer, format string, pkgs []*apiPackage, idx renderIndex, c generatorConfig, postCmd string) error {
+	if postCmd == "" || format != formatTypeScript {
+		return renderResult(w, format, pkgs, idx, c)
+	}
+	var b bytes.Buffer
+	if err := renderResult(&b, format, pkgs, idx, c); err != nil {
+		return err
+	}
+	out, err := runPostCmd(postCmd, b.Bytes())
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(out)
+	return err
+}
+
+// runPostCmd runs the command line cmd, split into words, with in as its
+// stdin and returns its stdout, or an error holding its stderr if it fails.
+func runPostCmd(cmd string, in []byte) ([]byte, error) {
+	args := strings.Fields(cmd)
+	if len(args) == 0 {
+		return in, nil
+	}
+	var stdout, stderr bytes.Buffer
+	c := exec.Command(args[0], args[1:]...)
+	c.Stdin = bytes.NewReader(in)
+	c.Stdout = &stdout
+	c.Stderr = &stderr
+	if err := c.Run(); err != nil {
+		return nil, errors.Wrapf(err, "-post-cmd %q failed: %s", cmd, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.Bytes(), nil
+}
+
+// normalizingWriter cleans up the whitespace left over by the templates in
+// what is written to it, line by line so the output can be streamed instead of
+// held in memory: it strips leading and trailing whitespace from each line,
+// including the carriage returns of CRLF line endings, drops blank lines and
+// ends the output with a single newline. Close must be called to flush the
+// last line.
+type normalizingWriter struct {
+	w       io.Writer
+	line    []byte
+	written bool
+	err     error
+}
+
+func newNormalizingWriter(w io.Writer) *normalizingWriter {
+	return &normalizingWriter{w: w}
+}
+
+func (n *normalizingWriter) Write(p []byte) (int, error) {
+	for _, c := range p {
+		if c != '\n' {
+			n.line = append(n.line, c)
+			continue
+		}
+		n.flushLine()
+	}
+	return len(p), n.err
+}
+
+// flushLine writes the pending line, unless it is blank.
+func (n *normalizingWriter) flushLine() {
+	// remove leading whitespace from each html line for markdown renderers
+	l := bytes.TrimRight(bytes.TrimLeft(n.line, " \t\f\r"), " \t\r")
+	n.line = n.line[:0]
+	if len(l) == 0 || n.err != nil {
+		return
+	}
+	_, n.err = n.w.Write(append(l, '\n'))
+	n.written = true
+}
+
+// Close flushes the last line, ending the output with a newline even if it is
+// empty.
+func (n *normalizingWriter) Close() error {
+	n.flushLine()
+	if !n.written && n.err == nil {
+		_, n.err = io.WriteString(n.w, "\n")
+	}
+	return n.err
+}
+
+// writeOutput writes the rendered result s as the response to r, answering
+// with 304 Not Modified when the client already has it and compressing the
+// body when the client accepts gzip. The ETag differs by encoding, as the
+// bodies do.
+func writeOutput(w http.ResponseWriter, r *http.Request, s string) error {
+	sum := sha256.Sum256([]byte(s))
+	gzipped := acceptsGzip(r.Header.Get("Accept-Encoding"))
+	tag := hex.EncodeToString(sum[:8])
+	if gzipped {
+		tag += "-gzip"
+	}
+	etag := fmt.Sprintf("%q", tag)
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Vary", "Accept-Encoding")
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return nil
+	}
+
+	if !gzipped {
+		_, err := fmt.Fprint(w, s)
+		return err
+	}
+	w.Header().Set("Content-Encoding", "gzip")
+	gz := gzip.NewWriter(w)
+	if _, err := fmt.Fprint(gz, s); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// etagMatches determines if the If-None-Match header h lists etag, or is "*".
+// The entity tags of h are compared weakly, as If-None-Match requires: a W/
+// prefix is ignored. A malformed header matches nothing.
+func etagMatches(h, etag string) bool {
+	h = strings.TrimSpace(h)
+	if h == "*" {
+		return true
+	}
+	for {
+		h = strings.TrimLeft(h, " \t,")
+		if h == "" {
+			return false
+		}
+		h = strings.TrimPrefix(h, "W/")
+		if !strings.HasPrefix(h, `"`) {
+			return false
+		}
+		end := strings.IndexByte(h[1:], '"')
+		if end < 0 {
+			return false
+		}
+		if h[:end+2] == etag {
+			return true
+		}
+		h = h[end+2:]
+	}
+}
+
+// acceptsGzip determines if the Accept-Encoding header h accepts gzip, either
+// by name or through the "*" wildcard, with a non-zero quality value (e.g. not
+// "gzip;q=0").
+func acceptsGzip(h string) bool {
+	wildcard := false
+	for _, v := range strings.Split(h, ",") {
+		parts := strings.Split(v, ";")
+		q := 1.0
+		for _, p := range parts[1:] {
+			p = strings.TrimSpace(p)
+			if strings.HasPrefix(p, "q=") {
+				f, err := strconv.ParseFloat(p[len("q="):], 64)
+				if err != nil {
+					f = 0
+				}
+				q = f
+			}
+		}
+		switch strings.ToLower(strings.TrimSpace(parts[0])) {
+		case "gzip", "x-gzip":
+			// an explicit entry takes precedence over the wildcard.
+			return q > 0
+		case "*":
+			wildcard = q > 0
+		}
+	}
+	return wildcard
+}
+
+// sharedRender runs the renders of the HTTP server one at a time: requests
+// arriving while a render is in progress share its result instead of queueing
+// renders of their own, including after they time out. Each render records
+// its errors, warnings and usage in a runReport of its own.
+type sharedRender struct {
+	render func(r *runReport) (string, error)
+
+	mu       sync.Mutex
+	current  *renderCall
+	lastGood string
+}
+
+// renderCall is a render of a sharedRender. Its result is set once done is
+// closed: the output, or the last successful one along with the error, and
+// the report of the render.
+type renderCall struct {
+	done   chan struct{}
+	s      string
+	err    error
+	report *runReport
+}
+
+// start returns the render in progress, or starts a new one.
+func (r *sharedRender) start() *renderCall {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.current != nil {
+		return r.current
+	}
+	call := &renderCall{done: make(chan struct{}), report: newRunReport()}
+	r.current = call
+	go func() {
+		s, err := r.render(call.report)
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		if err == nil {
+			r.lastGood = s
+		} else {
+			s = r.lastGood
+		}
+		call.s, call.err = s, err
+		r.current = nil
+		close(call.done)
+	}()
+	return call
+}
+
+// templateErrorLocation matches the template name and line that text/template
+// prefixes its parse and execution errors with.
+var templateErrorLocation = regexp.MustCompile(`template: ([^:\s]+):(\d+)`)
+
+// errorBanner renders the error of a failed HTTP request as a comment block,
+// naming the offending template and line if err comes from one. stale tells
+// that the last good result follows it.
+func errorBanner(err error, stale bool) string {
+	var b strings.Builder
+	b.WriteString("/*\n * GENERATION FAILED\n")
+	if m := templateErrorLocation.FindStringSubmatch(err.Error()); m != nil {
+		fmt.Fprintf(&b, " * template %s, line %s\n", m[1], m[2])
 	}
-	d := json.NewDecoder(f)
-	d.DisallowUnknownFields()
-	var config generatorConfig
-	if err := d.Decode(&config); err != nil {
-		klog.Fatalf("failed to parse config file: %+v", err)
+	b.WriteString(" *\n")
+	for _, l := range strings.Split(fmt.Sprintf("%v", err), "\n") {
+		fmt.Fprintf(&b, " * %s\n", strings.Replace(l, "*/", "* /", -1))
 	}
+	if stale {
+		b.WriteString(" *\n * showing the last successful result below.\n")
+	}
+	b.WriteString(" */\n")
+	return b.String()
+}
 
-	klog.Infof("parsing go packages in directory %s", *flAPIDir)
-	pkgs, err := parseAPIPackages(*flAPIDir)
-	if err != nil {
-		klog.Fatal(err)
+// printReport writes the summary of the errors and warnings of the run to
+// stderr, unless -quiet is set, after warning about the config entries that
+// never matched. Any error is fatal, and so are those entries with
+// -strict-config and the unmapped types with -strict-types.
+func printReport(c generatorConfig) {
+	r := c.currentReport()
+	unused := unusedConfigEntries(c)
+	for _, v := range unused {
+		r.warnf(warnUnusedConfig, "config entry %s never matched", v)
 	}
-	if len(pkgs) == 0 {
-		klog.Fatalf("no API packages found in %s", *flAPIDir)
+	if !*flQuiet {
+		r.print(os.Stderr, isTerminal(os.Stderr))
 	}
-
-	apiPackages, err := combineAPIPackages(pkgs)
-	if err != nil {
-		klog.Fatal(err)
+	if n := count(r.errors); n > 0 {
+		klog.Fatalf("%d error(s) reported, the output refers to types it does not declare", n)
 	}
-
-	mkOutput := func() (string, error) {
-		var b bytes.Buffer
-		err := render(&b, apiPackages, config)
-		if err != nil {
-			return "", errors.Wrap(err, "failed to render the result")
+	if *flStrictConfig && len(unused) > 0 {
+		klog.Fatalf("%d config entries never matched (-strict-config)", len(unused))
+	}
+	if *flStrictTypes && len(r.unmappedTypes) > 0 {
+		var unmapped []string
+		for k := range r.unmappedTypes {
+			unmapped = append(unmapped, k)
 		}
+		sort.Strings(unmapped)
+		klog.Fatalf("types without a TypeScript mapping (-strict-types): %s", strings.Join(unmapped, ", "))
+	}
+}
 
-		// remove trailing whitespace from each html line for markdown renderers
-		s := regexp.MustCompile(`(?m)^\s+`).ReplaceAllString(b.String(), "")
-		return s, nil
+// printSummary writes a human-readable report of the packages and types that
+// would be generated.
+func printSummary(w io.Writer, pkgs []*apiPackage, config generatorConfig) {
+	fmt.Fprintf(w, "apiPackages: %d\n", len(pkgs))
+	for _, pkg := range pkgs {
+		visible := len(visibleTypes(pkg.Types, config))
+		fmt.Fprintf(w, "  %s: %d visible types, %d hidden types, %d constants\n",
+			pkg.identifier(), visible, len(pkg.Types)-visible, len(pkg.Constants))
 	}
 
-	if *flOutFile != "" {
-		dir := filepath.Dir(*flOutFile)
-		if err := os.MkdirAll(dir, 0755); err != nil {
-			klog.Fatalf("failed to create dir %s: %v", dir, err)
-		}
-		s, err := mkOutput()
-		if err != nil {
-			klog.Fatalf("failed: %+v", err)
-		}
-		if err := ioutil.WriteFile(*flOutFile, []byte(s), 0644); err != nil {
-			klog.Fatalf("failed to write to out file: %v", err)
-		}
-		klog.Infof("written to %s", *flOutFile)
+	var unresolved []string
+	for k := range config.currentReport().unresolvedTypes {
+		unresolved = append(unresolved, k)
 	}
+	sort.Strings(unresolved)
+	fmt.Fprintf(w, "unresolved types: %d\n", len(unresolved))
+	for _, v := range unresolved {
+		fmt.Fprintf(w, "  %s\n", v)
+	}
+}
 
-	if *flHTTPAddr != "" {
-		h := func(w http.ResponseWriter, r *http.Request) {
-			now := time.Now()
-			defer func() { klog.Infof("request took %v", time.Since(now)) }()
-			s, err := mkOutput()
-			if err != nil {
-				fmt.Fprintf(w, "error: %+v", err)
-				klog.Warningf("failed: %+v", err)
+// listTypes writes every type of pkgs to w, with its package, whether it is
+// visible or why it is hidden, and whether it is a root kind.
+func listTypes(w io.Writer, pkgs []*apiPackage, config generatorConfig) {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "PACKAGE\tTYPE\tVISIBILITY\tROOT")
+	for _, pkg := range pkgs {
+		for _, t := range sortTypes(pkg.Types, config) {
+			visibility := "visible"
+			if r := hiddenReason(t, config); r != "" {
+				visibility = "hidden: " + r
 			}
-			if _, err := fmt.Fprint(w, s); err != nil {
-				klog.Warningf("response write error: %v", err)
+			root := ""
+			if isExportedType(t, config) {
+				root = "root"
 			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", pkg.identifier(), t.Name.Name, visibility, root)
 		}
-		http.HandleFunc("/", h)
-		klog.Infof("server listening at %s", *flHTTPAddr)
-		klog.Fatal(http.ListenAndServe(*flHTTPAddr, nil))
 	}
+	tw.Flush()
 }
 
 // groupName extracts the "//+groupName" meta-comment from the specified
@@ -195,9 +1470,19 @@ func groupName(pkg *types.Package) string {
 }
 
 func parseAPIPackages(dir string) ([]*types.Package, error) {
+	dir, file, err := apiFile(dir)
+	if err != nil {
+		return nil, err
+	}
 	b := parser.New()
 	// the following will silently fail (turn on -v=4 to see logs)
-	if err := b.AddDirRecursive(*flAPIDir); err != nil {
+	if file != "" {
+		// a single file only needs its own package.
+		err = b.AddDir(dir)
+	} else {
+		err = b.AddDirRecursive(dir)
+	}
+	if err != nil {
 		return nil, err
 	}
 	scan, err := b.FindTypes()
@@ -211,21 +1496,38 @@ func parseAPIPackages(dir string) ([]*types.Package, error) {
 
 		// Do not pick up packages that are in vendor/ as API packages. (This
 		// happened in knative/eventing-sources/vendor/..., where a package
-		// matched the pattern, but it didn't have a compatible import path).
-		if isVendorPackage(pkg) {
-			klog.V(3).Infof("package=%v coming from vendor/, ignoring.", p)
+		// matched the pattern, but it didn't have a compatible import path),
+		// unless -include-vendor says they are.
+		if isVendorPackage(pkg) && !*flIncludeVendor {
+			if groupName(pkg) != "" {
+				warnf(warnVendorSkip, "package=%v coming from vendor/, ignoring it (see -include-vendor)", p)
+			} else {
+				klog.V(3).Infof("package=%v coming from vendor/, ignoring.", p)
+			}
 			continue
 		}
 
-		if groupName(pkg) != "" && len(pkg.Types) > 0 || containsString(pkg.DocComments, docCommentForceIncludes) {
-			klog.V(3).Infof("package=%v has groupName and has types", p)
+		// packages with only constants are kept, as they may hold the values
+		// of an enum type declared in a sibling package.
+		hasDecls := len(pkg.Types) > 0 || len(pkg.Constants) > 0
+		if groupName(pkg) != "" && hasDecls || containsString(pkg.DocComments, docCommentForceIncludes) {
+			klog.V(3).Infof("package=%v has groupName and has types or constants", p)
 			pkgNames = append(pkgNames, p)
 		}
 	}
 	sort.Strings(pkgNames)
 	var pkgs []*types.Package
 	for _, p := range pkgNames {
-		klog.Infof("using package=%s", p)
+		log.Infof("using package=%s", p)
+		if file != "" {
+			if err := restrictToFile(scan[p], file); err != nil {
+				return nil, err
+			}
+		}
+		dropInstantiations(scan[p])
+		if err := resolveInvalidMembers(scan[p]); err != nil {
+			return nil, err
+		}
 		pkgs = append(pkgs, scan[p])
 	}
 	return pkgs, nil
@@ -241,10 +1543,13 @@ func containsString(sl []string, str string) bool {
 }
 
 // combineAPIPackages groups the Go packages by the <apiGroup+apiVersion> they
-// offer, and combines the types in them.
-func combineAPIPackages(pkgs []*types.Package) ([]*apiPackage, error) {
+// offer, and combines the types in them. The packages whose apiVersion cannot
+// be inferred are skipped with a warning, or all reported as an error if
+// strict is set.
+func combineAPIPackages(pkgs []*types.Package, strict bool) ([]*apiPackage, error) {
 	pkgMap := make(map[string]*apiPackage)
 	var pkgIds []string
+	var failed []string
 
 	flattenTypes := func(typeMap map[string]*types.Type) []*types.Type {
 		typeList := make([]*types.Type, 0, len(typeMap))
@@ -259,7 +1564,8 @@ func combineAPIPackages(pkgs []*types.Package) ([]*apiPackage, error) {
 	for _, pkg := range pkgs {
 		apiGroup, apiVersion, err := apiVersionForPackage(pkg)
 		if err != nil {
-			return nil, errors.Wrapf(err, "could not get apiVersion for package %s", pkg.Path)
+			failed = append(failed, fmt.Sprintf("could not get apiVersion for package %s: %v", pkg.Path, err))
+			continue
 		}
 
 		typeList := make([]*types.Type, 0, len(pkg.Types))
@@ -280,11 +1586,18 @@ func combineAPIPackages(pkgs []*types.Package) ([]*apiPackage, error) {
 			pkgIds = append(pkgIds, id)
 		} else {
 			v.Types = append(v.Types, flattenTypes(pkg.Types)...)
-			v.Constants = append(v.Types, flattenTypes(pkg.Constants)...)
+			v.Constants = append(v.Constants, flattenTypes(pkg.Constants)...)
 			v.GoPackages = append(v.GoPackages, pkg)
 		}
 	}
 
+	if strict && len(failed) > 0 {
+		return nil, errors.Errorf("%d package(s) have no apiVersion (-strict-versions):\n%s", len(failed), strings.Join(failed, "\n"))
+	}
+	for _, v := range failed {
+		warnf(warnVersionSkip, "%s, skipping it", v)
+	}
+
 	sort.Sort(sort.StringSlice(pkgIds))
 
 	out := make([]*apiPackage, 0, len(pkgMap))
@@ -294,10 +1607,14 @@ func combineAPIPackages(pkgs []*types.Package) ([]*apiPackage, error) {
 	return out, nil
 }
 
-// isVendorPackage determines if package is coming from vendor/ dir.
+// isVendorPackage determines if package is coming from vendor/ dir. The
+// source path may use either separator on Windows, so it is normalized first.
 func isVendorPackage(pkg *types.Package) bool {
-	vendorPattern := string(os.PathSeparator) + "vendor" + string(os.PathSeparator)
-	return strings.Contains(pkg.SourcePath, vendorPattern)
+	return isVendorPath(pkg.SourcePath)
+}
+
+func isVendorPath(path string) bool {
+	return strings.Contains(filepath.ToSlash(path), "/vendor/")
 }
 
 func findTypeReferences(pkgs []*apiPackage) map[*types.Type][]*types.Type {
@@ -314,21 +1631,70 @@ func findTypeReferences(pkgs []*apiPackage) map[*types.Type][]*types.Type {
 	return m
 }
 
+// hiddenMemberOf determines if the member m of the type t is hidden, either
+// like any member by hiddenMember or, for root kinds, by RootFieldExclude.
+func hiddenMemberOf(t *types.Type, m types.Member, c generatorConfig) bool {
+	return hiddenMember(m, c) || isExportedType(t, c) && containsString(c.RootFieldExclude, fieldName(m, c))
+}
+
 func hiddenMember(m types.Member, c generatorConfig) bool {
+	_, tagged := reflect.StructTag(m.Tags).Lookup("json")
+	if c.HideUntaggedFields && !tagged && !fieldEmbedded(m) {
+		return true
+	}
+	for _, v := range c.HideTagOptions {
+		if hasJSONOption(m, v) {
+			return true
+		}
+	}
 	for _, v := range c.HiddenMemberFields {
 		if m.Name == v {
 			return true
 		}
 	}
-	return false
+	if id := typeIdentifier(m.Type); isExternalType(c, id) && containsString(c.DropExternalMembers, id) {
+		return true
+	}
+	if c.targetVersion != "" && !inTargetVersion(m, c.targetVersion) {
+		return true
+	}
+	// neither are unexported fields, unless embedded: the exported fields of
+	// an embedded struct are promoted.
+	if !fieldEmbedded(m) && !token.IsExported(m.Name) {
+		return true
+	}
+	// func and chan fields are never part of the JSON, so they are omitted.
+	return isUnserializable(m.Type)
 }
 
-func packageDisplayName(pkg *types.Package, apiVersions map[string]string) string {
-	apiGroupVersion, ok := apiVersions[pkg.Path]
-	if ok {
-		return apiGroupVersion
+// packageDisplayName returns the name configured in PackageDisplayNames for
+// the package, looked up by its group/version and then by its Go import paths,
+// or falls back to its group/version joined by IdentifierSeparator.
+func packageDisplayName(p *apiPackage, c generatorConfig) string {
+	if v, ok := c.PackageDisplayNames[p.identifier()]; ok {
+		return v
 	}
-	return pkg.Path // go import path
+	for _, pkg := range p.GoPackages {
+		if v, ok := c.PackageDisplayNames[pkg.Path]; ok {
+			return v
+		}
+	}
+	if c.IdentifierSeparator != "" {
+		return p.identifierWith(c.IdentifierSeparator)
+	}
+	return p.identifier()
+}
+
+// packageTemplateName returns the name of the template configured in
+// TemplatesByGroup for the package, or the default "package" template.
+func packageTemplateName(p *apiPackage, c generatorConfig) string {
+	if v, ok := c.TemplatesByGroup[p.identifier()]; ok {
+		return v
+	}
+	if v, ok := c.TemplatesByGroup[p.apiGroup]; ok {
+		return v
+	}
+	return "package"
 }
 
 func filterCommentTags(comments []string) []string {
@@ -341,10 +1707,177 @@ func filterCommentTags(comments []string) []string {
 	return out
 }
 
-func isOptionalMember(m types.Member) bool {
+func isOptionalMember(m types.Member, c generatorConfig) bool {
+	tags := types.ExtractCommentTags("+", m.CommentLines)
+	if _, ok := tags["kubebuilder:validation:Required"]; ok {
+		return false
+	}
+	if _, ok := tags["optional"]; ok {
+		return true
+	}
+	if _, ok := tags["kubebuilder:validation:Optional"]; ok {
+		return true
+	}
+	if !c.requiredByDefault() {
+		return true
+	}
+	if !hasJSONOption(m, "omitempty") {
+		return false
+	}
+	if isCollection(m.Type) {
+		return c.optionalCollectionsFromOmitempty()
+	}
+	return c.optionalFromOmitempty()
+}
+
+// isCollection determines if t is a slice or a map, possibly through aliases,
+// i.e. a type that omitempty omits when empty rather than only when unset.
+func isCollection(t *types.Type) bool {
+	for t.Kind == types.Alias {
+		t = t.Underlying
+	}
+	return t.Kind == types.Slice || t.Kind == types.Map
+}
+
+// isNullableMember determines if the member may be explicitly null, i.e. if it
+// is a pointer and NullablePointers is set.
+func isNullableMember(m types.Member, c generatorConfig) bool {
+	return c.NullablePointers && m.Type.Kind == types.Pointer
+}
+
+// memberType returns the full TypeScript type of the member: its
+// memberTypeOverride or else the display name of its type, followed by
+// "| null" if it is nullable and by "| undefined" if it is optional in the
+// "undefined-union" OptionalStyle. The "?" of the other optional members is
+// left to the field name.
+func memberType(m types.Member, c generatorConfig, typePkgMap map[*types.Type]*apiPackage) string {
+	s := memberTypeOverride(m, c, typePkgMap)
+	if s == "" {
+		s = typeDisplayName(m.Type, c, typePkgMap)
+	}
+	if isNullableMember(m, c) {
+		s += " | null"
+	}
+	if c.OptionalStyle == optionalStyleUndefinedUnion && isOptionalMember(m, c) {
+		s += " | undefined"
+	}
+	return s
+}
+
+// memberTypeOverride returns the TypeScript type forced on the member via the
+// "+ts:type=<type>" marker, or the union of the types named by its
+// "+ts:oneof=A,B" marker, or "string | number" for the
+// +kubebuilder:validation:XIntOrString marker, or its branded format type when
+// FormatStyle is "branded", or empty string if the member has none.
+func memberTypeOverride(m types.Member, c generatorConfig, typePkgMap map[*types.Type]*apiPackage) string {
+	tags := types.ExtractCommentTags("+", m.CommentLines)
+	if v := tags["ts:type"]; len(v) > 0 {
+		return strings.TrimSpace(v[0])
+	}
+	if v := tags["ts:oneof"]; len(v) > 0 {
+		var alternatives []string
+		for _, name := range strings.Split(v[0], ",") {
+			alternatives = append(alternatives, oneOfTypeName(strings.TrimSpace(name), c, typePkgMap))
+		}
+		return strings.Join(alternatives, " | ")
+	}
+	if _, ok := validationMarker(m, "XIntOrString"); ok {
+		return "string | number"
+	}
+	if c.FormatStyle == formatStyleBranded {
+		if f := memberFormat(m); f != "" {
+			if knownFormats[f] {
+				return fmt.Sprintf("string & { readonly __format: '%s' }", f)
+			}
+			c.currentReport().warnf(warnFormat, "field %s has the unknown format %q, not branding it", m.Name, f)
+		}
+	}
+	return ""
+}
+
+// oneOfTypeName returns the emitted name of the API type named name, or name
+// itself if there is no such type (e.g. "string").
+func oneOfTypeName(name string, c generatorConfig, typePkgMap map[*types.Type]*apiPackage) string {
+	var candidates []*types.Type
+	for t := range typePkgMap {
+		if t.Name.Name == name && t.Kind != types.DeclarationOf {
+			candidates = append(candidates, t)
+		}
+	}
+	if len(candidates) == 0 {
+		return name
+	}
+	t := sortTypes(candidates, c)[0]
+	return localTypeName(t, c, typePkgMap[t])
+}
+
+// validationMarker returns the value of the +kubebuilder:validation:<name>
+// marker of the member, and whether it has one.
+func validationMarker(m types.Member, name string) (string, bool) {
+	v, ok := types.ExtractCommentTags("+", m.CommentLines)["kubebuilder:validation:"+name]
+	if !ok {
+		return "", false
+	}
+	return strings.TrimSpace(v[0]), true
+}
+
+// parseVersion parses a release version like "v1.24" or "1.24.3" into its
+// numbers.
+func parseVersion(s string) ([]int, bool) {
+	var out []int
+	for _, p := range strings.Split(strings.TrimPrefix(strings.TrimSpace(s), "v"), ".") {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 {
+			return nil, false
+		}
+		out = append(out, n)
+	}
+	return out, true
+}
+
+// compareVersions returns -1, 0 or 1 as the version a is lower than, equal to
+// or greater than b, missing numbers counting as zeros.
+func compareVersions(a, b []int) int {
+	for i := 0; i < len(a) || i < len(b); i++ {
+		var x, y int
+		if i < len(a) {
+			x = a[i]
+		}
+		if i < len(b) {
+			y = b[i]
+		}
+		if x != y {
+			if x < y {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+// inTargetVersion determines if the member exists in the target version,
+// i.e. if it was introduced by its "+ts:since=<version>" marker at or before
+// the target, and removed by its "+ts:until=<version>" marker after it.
+// Markers that are not versions are ignored with a warning.
+func inTargetVersion(m types.Member, target string) bool {
+	t, _ := parseVersion(target)
 	tags := types.ExtractCommentTags("+", m.CommentLines)
-	_, ok := tags["optional"]
-	return ok
+	for _, marker := range []string{"ts:since", "ts:until"} {
+		v, ok := tags[marker]
+		if !ok {
+			continue
+		}
+		bound, ok := parseVersion(v[0])
+		if !ok {
+			log.Warningf("field %s has the invalid +%s=%s marker, ignoring it", m.Name, marker, v[0])
+			continue
+		}
+		if marker == "ts:since" && compareVersions(t, bound) < 0 || marker == "ts:until" && compareVersions(t, bound) >= 0 {
+			return false
+		}
+	}
+	return true
 }
 
 func apiVersionForPackage(pkg *types.Package) (string, string, error) {
@@ -357,6 +1890,202 @@ func apiVersionForPackage(pkg *types.Package) (string, string, error) {
 	return group, version, nil
 }
 
+// versionPriority orders Kubernetes apiVersions: GA versions come first, then
+// betas and alphas, each ordered by major version and then by their own
+// number, highest first. It returns true if v1 has a higher priority than v2.
+func versionPriority(v1, v2 string) bool {
+	r := regexp.MustCompile(`^v(\d+)(?:(alpha|beta)(\d+))?$`)
+	parse := func(v string) (stability, major, minor int) {
+		m := r.FindStringSubmatch(v)
+		if m == nil {
+			return -1, 0, 0
+		}
+		major, _ = strconv.Atoi(m[1])
+		minor, _ = strconv.Atoi(m[3])
+		switch m[2] {
+		case "alpha":
+			return 0, major, minor
+		case "beta":
+			return 1, major, minor
+		}
+		return 2, major, minor
+	}
+	s1, maj1, min1 := parse(v1)
+	s2, maj2, min2 := parse(v2)
+	if s1 != s2 {
+		return s1 > s2
+	}
+	if maj1 != maj2 {
+		return maj1 > maj2
+	}
+	return min1 > min2
+}
+
+// filterVersions keeps a single apiVersion of each apiGroup in pkgs: the one
+// with the highest priority if filter is "latest", or the one named by filter.
+func filterVersions(pkgs []*apiPackage, filter string) []*apiPackage {
+	selected := make(map[string]*apiPackage)
+	for _, p := range pkgs {
+		if filter == "latest" {
+			if v, ok := selected[p.apiGroup]; !ok || versionPriority(p.apiVersion, v.apiVersion) {
+				selected[p.apiGroup] = p
+			}
+		} else if p.apiVersion == filter {
+			selected[p.apiGroup] = p
+		}
+	}
+
+	var out []*apiPackage
+	for _, p := range pkgs {
+		if selected[p.apiGroup] == p {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+// mergeVersions combines the packages of pkgs sharing an apiGroup into a single
+// package without apiVersion, recording the apiVersion of each type instead.
+// The types whose name is declared by several apiVersions are suffixed with
+// their apiVersion, with a warning.
+func mergeVersions(pkgs []*apiPackage, c generatorConfig) []*apiPackage {
+	merged := make(map[string]*apiPackage)
+	var out []*apiPackage
+	for _, p := range pkgs {
+		m, ok := merged[p.apiGroup]
+		if !ok {
+			m = &apiPackage{apiGroup: p.apiGroup, typeVersions: make(map[*types.Type]string)}
+			merged[p.apiGroup] = m
+			out = append(out, m)
+		}
+		m.GoPackages = append(m.GoPackages, p.GoPackages...)
+		m.Types = append(m.Types, p.Types...)
+		m.Constants = append(m.Constants, p.Constants...)
+		for _, t := range append(p.Types, p.Constants...) {
+			m.typeVersions[t] = p.versionOf(t)
+		}
+	}
+	for _, m := range out {
+		byName := make(map[string][]*types.Type)
+		for _, t := range m.Types {
+			byName[t.Name.Name] = append(byName[t.Name.Name], t)
+		}
+		m.versionSuffixed = make(map[*types.Type]bool)
+		for _, t := range sortTypes(m.Types, c) {
+			same := byName[t.Name.Name]
+			if len(same) < 2 || m.versionSuffixed[t] {
+				continue
+			}
+			var versions []string
+			for _, v := range same {
+				m.versionSuffixed[v] = true
+				versions = append(versions, m.versionOf(v))
+			}
+			warnf(warnNameCollision, "type %s is declared by %s of %s, suffixing it with the apiVersion",
+				t.Name.Name, strings.Join(versions, ", "), m.apiGroup)
+		}
+	}
+	return out
+}
+
+// specSubtrees keeps in pkgs only the types of the "spec" members of the root
+// types, and the types they transitively reference, up to the
+// MaxReferenceDepth of c hops away from the root types unless it is 0.
+func specSubtrees(pkgs []*apiPackage, c generatorConfig) []*apiPackage {
+	maxDepth := c.MaxReferenceDepth
+	typePkgMap := extractTypeToPackageMap(pkgs)
+	keep := make(map[*types.Type]bool)
+	// walk the references level by level, so that types are kept at the
+	// shortest depth they are referenced at.
+	var level []*types.Type
+	var visit func(t *types.Type)
+	visit = func(t *types.Type) {
+		for t.Kind == types.Pointer || t.Kind == types.Slice || t.Kind == types.Map {
+			if t.Kind == types.Map {
+				visit(t.Key)
+			}
+			t = t.Elem
+		}
+		if _, ok := typePkgMap[t]; ok && !keep[t] {
+			level = append(level, t)
+		}
+	}
+	for _, p := range pkgs {
+		for _, t := range p.Types {
+			if !isExportedType(t, c) {
+				continue
+			}
+			for _, m := range t.Members {
+				if fieldName(m, c) == "spec" {
+					visit(m.Type)
+				}
+			}
+		}
+	}
+	for depth := 1; len(level) > 0; depth++ {
+		if maxDepth > 0 && depth > maxDepth {
+			truncated := make(map[*types.Type]bool)
+			for _, t := range level {
+				truncated[t] = true
+			}
+			warnf(warnReferenceDepth, "maxReferenceDepth=%d leaves out %d referenced type(s)", maxDepth, len(truncated))
+			break
+		}
+		current := level
+		level = nil
+		for _, t := range current {
+			keep[t] = true
+		}
+		for _, t := range current {
+			if t.Kind == types.Alias {
+				visit(t.Underlying)
+			}
+			for _, m := range t.Members {
+				if hiddenMember(m, c) {
+					continue
+				}
+				visit(m.Type)
+			}
+		}
+	}
+
+	var out []*apiPackage
+	for _, p := range pkgs {
+		np := *p
+		np.Types = nil
+		for _, t := range p.Types {
+			if keep[t] {
+				np.Types = append(np.Types, t)
+			}
+		}
+		out = append(out, &np)
+	}
+	return out
+}
+
+// crossPackageReferences reports the fields of the types in pkgs that refer to
+// a type only found in the packages of all that were left out.
+func crossPackageReferences(pkgs, all []*apiPackage) []string {
+	kept := extractTypeToPackageMap(pkgs)
+	allTypes := extractTypeToPackageMap(all)
+	var out []string
+	for _, pkg := range pkgs {
+		for _, t := range pkg.Types {
+			for _, m := range t.Members {
+				ref := tryDereference(m.Type)
+				if _, ok := kept[ref]; ok {
+					continue
+				}
+				if p, ok := allTypes[ref]; ok {
+					out = append(out, fmt.Sprintf("field %s.%s refers to type %s of the excluded package %s",
+						t.Name.Name, m.Name, ref.Name.Name, p.identifier()))
+				}
+			}
+		}
+	}
+	return out
+}
+
 // extractTypeToPackageMap creates a *types.Type map to apiPackage
 func extractTypeToPackageMap(pkgs []*apiPackage) map[*types.Type]*apiPackage {
 	out := make(map[*types.Type]*apiPackage)
@@ -382,54 +2111,259 @@ func packageMapToList(pkgs map[string]*apiPackage) []*apiPackage {
 	return out
 }
 
-func render(w io.Writer, pkgs []*apiPackage, config generatorConfig) error {
-	references := findTypeReferences(pkgs)
-	typePkgMap := extractTypeToPackageMap(pkgs)
+// renderIndex holds the lookups render derives from the packages. They only
+// depend on the packages, so they are computed once and shared by the renders
+// of the HTTP server.
+type renderIndex struct {
+	references map[*types.Type][]*types.Type
+	typePkgMap map[*types.Type]*apiPackage
+	constants  map[*types.Type][]*types.Type
+}
+
+// precompute builds the renderIndex of pkgs.
+func precompute(pkgs []*apiPackage) renderIndex {
+	return renderIndex{
+		references: findTypeReferences(pkgs),
+		typePkgMap: extractTypeToPackageMap(pkgs),
+		constants:  indexConstants(pkgs),
+	}
+}
+
+// render executes the template name ("packages" or "enums") for pkgs.
+func render(w io.Writer, name string, pkgs []*apiPackage, idx renderIndex, config generatorConfig) error {
+	t, err := parseTemplates(pkgs, idx, config)
+	if err != nil {
+		return err
+	}
+
+	data := map[string]interface{}{
+		"packages": pkgs,
+		"config":   config,
+	}
+	if !hasExternalImports(config) {
+		return errors.Wrap(t.ExecuteTemplate(w, name, data), "template execution error")
+	}
+
+	// imports are only known once everything has been rendered.
+	externalImports = make(map[string]map[string]struct{})
+	var b bytes.Buffer
+	if err := t.ExecuteTemplate(&b, name, data); err != nil {
+		return errors.Wrap(err, "template execution error")
+	}
+	if _, err := io.WriteString(w, importStatements(externalImports)); err != nil {
+		return err
+	}
+	_, err = b.WriteTo(w)
+	return err
+}
+
+// validateTemplates parses the templates of -template-dir, checks that they
+// define the "packages" template and renders it, and the "enums" one if
+// defined, for a synthetic API with a root kind, a struct and an enum, so that
+// their errors show up without any API to parse.
+func validateTemplates(c generatorConfig) error {
+	pkgs := []*apiPackage{syntheticPackage()}
+	c = c.withPackages(pkgs)
+	idx := precompute(pkgs)
+
+	t, err := parseTemplates(pkgs, idx, c)
+	if err != nil {
+		return err
+	}
+	for _, name := range []string{"packages", "enums"} {
+		if t.Lookup(name) == nil {
+			if name == "packages" {
+				return errors.Errorf("no %q template defined", name)
+			}
+			continue
+		}
+		if err := render(ioutil.Discard, name, pkgs, idx, c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// syntheticPackage builds the API package validateTemplates renders: an
+// Example root kind whose spec holds a field of each kind of type.
+func syntheticPackage() *apiPackage {
+	const path = "example.com/apis/example/v1"
+	phase := &types.Type{
+		Name:         types.Name{Package: path, Name: "Phase"},
+		Kind:         types.Alias,
+		Underlying:   crdString,
+		CommentLines: []string{"Phase is the phase of an Example."},
+	}
+	ready := "Ready"
+	constant := &types.Type{
+		Name:       types.Name{Package: path, Name: "PhaseReady"},
+		Kind:       types.DeclarationOf,
+		Underlying: phase,
+		ConstValue: &ready,
+	}
+	spec := &types.Type{
+		Name:         types.Name{Package: path, Name: "ExampleSpec"},
+		Kind:         types.Struct,
+		CommentLines: []string{"ExampleSpec is the spec of an Example."},
+		Members: []types.Member{
+			{Name: "Phase", Type: phase, Tags: `json:"phase"`, CommentLines: []string{"Phase of the example."}},
+			{Name: "Count", Type: &types.Type{Kind: types.Pointer, Elem: crdNumber}, Tags: `json:"count,omitempty"`},
+			{Name: "Names", Type: &types.Type{Kind: types.Slice, Elem: crdString}, Tags: `json:"names,omitempty"`},
+			{Name: "Labels", Type: &types.Type{Kind: types.Map, Key: crdString, Elem: crdString}, Tags: `json:"labels,omitempty"`},
+		},
+	}
+	root := &types.Type{
+		Name:                      types.Name{Package: path, Name: "Example"},
+		Kind:                      types.Struct,
+		CommentLines:              []string{"Example is a synthetic root kind."},
+		SecondClosestCommentLines: []string{"+kubebuilder:object:root=true"},
+		Members: []types.Member{
+			{Name: "Spec", Type: spec, Tags: `json:"spec"`},
+		},
+	}
+	return &apiPackage{
+		apiGroup:   "example.com",
+		apiVersion: "v1",
+		Types:      []*types.Type{root, spec, phase},
+		Constants:  []*types.Type{constant},
+	}
+}
 
+// parseTemplates parses the templates of -template-dir with the funcs
+// rendering pkgs.
+func parseTemplates(pkgs []*apiPackage, idx renderIndex, config generatorConfig) (*template.Template, error) {
+	references, typePkgMap, constants := idx.references, idx.typePkgMap, idx.constants
+	var sources map[*types.Type]token.Position
+
+	var t *template.Template
 	t, err := template.New("").Funcs(map[string]interface{}{
-		"isExportedType":     isExportedType,
-		"fieldName":          fieldName,
-		"fieldEmbedded":      fieldEmbedded,
-		"hasEmbeddedTypes":   hasEmbeddedTypes,
-		"embeddedTypes":      embeddedTypes,
-		"typeIdentifier":     func(t *types.Type) string { return typeIdentifier(t) },
-		"typeDisplayName":    func(t *types.Type) string { return typeDisplayName(t, config, typePkgMap) },
+		"renderPackage": func(p *apiPackage) (string, error) {
+			var b bytes.Buffer
+			err := t.ExecuteTemplate(&b, packageTemplateName(p, config), p)
+			return b.String(), err
+		},
+		"config": func() generatorConfig { return config },
+		"typeSource": func(t *types.Type) string {
+			if sources == nil {
+				sources = findTypeSources(pkgs)
+			}
+			pos, ok := sources[t]
+			if !ok {
+				return ""
+			}
+			return sourceLink(pos, *flAPIDir)
+		},
+		"isExportedType":   func(t *types.Type) bool { return isExportedType(t, config) },
+		"fieldName":        func(m types.Member) string { return fieldName(m, config) },
+		"fieldEmbedded":    fieldEmbedded,
+		"hasEmbeddedTypes": hasEmbeddedTypes,
+		"embeddedTypes":    embeddedTypes,
+		"typeIdentifier":   func(t *types.Type) string { return typeIdentifier(t) },
+		"typeDisplayName":  func(t *types.Type) string { return typeDisplayName(t, config, typePkgMap) },
+		"rootFieldType": func(t *types.Type, name string) string {
+			if m, ok := memberNamed(t, name, config); ok {
+				return typeDisplayName(m.Type, config, typePkgMap)
+			}
+			return "unknown"
+		},
+		"kindLiterals": func(t *types.Type) []string { return kindLiterals(t, config, typePkgMap[t]) },
+		"kindRegistry": func() []kindEntry { return kindRegistry(pkgs, config, typePkgMap) },
+		"asInterface": func(t *types.Type) bool {
+			_, ok := interfaceExtends(t, config, typePkgMap)
+			return ok
+		},
+		"interfaceExtends": func(t *types.Type) string {
+			s, _ := interfaceExtends(t, config, typePkgMap)
+			return s
+		},
+		"embeddedDisplayName": func(t *types.Type) string {
+			return embeddedDisplayName(t, config, typePkgMap)
+		},
 		"visibleTypes":       func(t []*types.Type) []*types.Type { return visibleTypes(t, config) },
 		"hasComments":        hasComments,
-		"renderComments":     func(s []string) string { return renderComments(s) },
-		"packageDisplayName": func(p *apiPackage) string { return p.identifier() },
-		"apiGroup":           func(t *types.Type) string { return apiGroupForType(t, typePkgMap) },
-		"packageAnchorID": func(p *apiPackage) string {
-			// TODO(ahmetb): currently this is the same as packageDisplayName
-			// func, and it's fine since it retuns valid DOM id strings like
-			// 'serving.knative.dev/v1alpha1' which is valid per HTML5, except
-			// spaces, so just trim those.
-			return strings.Replace(p.identifier(), " ", "", -1)
+		"memberDocs":         memberDocs,
+		"renderComments":     func(s []string) string { return renderComments(wrapComments(s, config.CommentWrapWidth)) },
+		"wrapComments":       func(s []string) []string { return wrapComments(s, config.CommentWrapWidth) },
+		"packageDisplayName": func(p *apiPackage) string { return packageDisplayName(p, config) },
+		"apiGroup":           func(t *types.Type) string { return apiGroupForType(t, config, typePkgMap) },
+		"packageAnchorID":    func(p *apiPackage) string { return anchorID(packageDisplayName(p, config), config) },
+		"typeAnchorID": func(t *types.Type) string {
+			p := typePkgMap[t]
+			if p == nil {
+				return anchorID(t.Name.Name, config)
+			}
+			return anchorID(packageDisplayName(p, config)+"."+localTypeName(t, config, p), config)
 		},
-		"sortedTypes":      sortTypes,
-		"typeReferences":   func(t *types.Type) []*types.Type { return typeReferences(t, config, references) },
-		"hiddenMember":     func(m types.Member) bool { return hiddenMember(m, config) },
-		"isLocalType":      isLocalType,
-		"isOptionalMember": isOptionalMember,
-		"constantsOfType":  func(t *types.Type) []*types.Type { return constantsOfType(t, typePkgMap[t]) },
-		"constantsType": func(t *types.Type) string {
-			typs := constantsOfType(t, typePkgMap[t])
-			var values []string
-			for _, typ := range typs {
-				if typ.ConstValue != nil {
-					values = append(values, fmt.Sprintf("'%s'", *typ.ConstValue))
-				}
+		"sortedTypes":        func(typs []*types.Type) []*types.Type { return sortTypes(typs, config) },
+		"typeReferences":     func(t *types.Type) []*types.Type { return typeReferences(t, config, references) },
+		"hiddenMember":       func(m types.Member) bool { return hiddenMember(m, config) },
+		"isLocalType":        isLocalType,
+		"isOptionalMember":   func(m types.Member) bool { return isOptionalMember(m, config) },
+		"isNullableMember":   func(m types.Member) bool { return isNullableMember(m, config) },
+		"memberType":         func(m types.Member) string { return memberType(m, config, typePkgMap) },
+		"sortedMembers":      func(t *types.Type) []types.Member { return sortedMembers(t, config) },
+		"memberTypeOverride": func(m types.Member) string { return memberTypeOverride(m, config, typePkgMap) },
+		"mapKeyNote": func(m types.Member) string {
+			if k := invalidMapKey(m.Type); k != nil {
+				return fmt.Sprintf("The keys are %s values marshaled to text.", k.Name.Name)
 			}
-
-			return strings.Join(values, " | ")
+			return ""
+		},
+		"jsdocFormat": func(m types.Member) string {
+			if config.FormatStyle != formatStyleJSDoc {
+				return ""
+			}
+			return memberFormat(m)
+		},
+		"memberExample":       memberExample,
+		"externalTypeDocsURL": func(t *types.Type) string { return externalTypeDocsURL(config, t) },
+		"constantsOfType":     func(t *types.Type) []*types.Type { return constantsOfType(t, constants, config) },
+		"extraMembers":        func(t *types.Type) []string { return config.ExtraMembers[t.Name.Name] },
+		"typeParams":          typeParams,
+		"isEnum":              func(t *types.Type) bool { return len(constantsOfType(t, constants, config)) > 0 },
+		"inEnumsFile": func(t *types.Type) bool {
+			return config.enumsOutFile != "" && len(constantsOfType(t, constants, config)) > 0
 		},
+		"enumsImport": func() (string, error) {
+			if config.enumsOutFile == "" {
+				return "", nil
+			}
+			return enumsImport(pkgs, config, config.outFile, config.enumsOutFile)
+		},
+		"constantValue": constantValue,
+		"declare": func() string {
+			if config.AmbientDeclarations {
+				return "declare "
+			}
+			return ""
+		},
+		"enumDocTable": func(t *types.Type) []string { return enumDocTable(t, constants, config) },
+		"enumStyle": func() string {
+			if config.EnumStyle == "" {
+				return enumStyleUnion
+			}
+			return config.EnumStyle
+		},
+		"constantsType": func(t *types.Type) string { return constantsType(t, constants, config) },
+		"typeVersion": func(t *types.Type) string {
+			if p := typePkgMap[t]; p != nil {
+				return p.versionOf(t)
+			}
+			return ""
+		},
+		"typeGroup": func(t *types.Type) string {
+			if p := typePkgMap[t]; p != nil {
+				return p.apiGroup
+			}
+			return ""
+		},
+		"typeName": func(t *types.Type) string { return localTypeName(t, config, typePkgMap[t]) },
+		"isUnion": func(t *types.Type) bool {
+			_, ok := unionMarker(t)
+			return ok
+		},
+		"unionTypes":       func(t *types.Type) []*types.Type { return unionTypes(t, pkgs, config) },
+		"aliasDisplayName": func(t *types.Type) string { return aliasDisplayName(t, config, constants, typePkgMap) },
 	}).ParseGlob(filepath.Join(*flTemplateDir, "*.tpl"))
-	if err != nil {
-		return errors.Wrap(err, "parse error")
-	}
-
-	return errors.Wrap(t.ExecuteTemplate(w, "packages", map[string]interface{}{
-		"packages": pkgs,
-		"config":   config,
-	}), "template execution error")
+	return t, errors.Wrap(err, "parse error")
 }
@@ -0,0 +1,2086 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"k8s.io/gengo/types"
+)
+
+// testAPIDir is the module holding the API packages the tests parse, with a
+// stub of k8s.io/apimachinery.
+const testAPIDir = "testdata/fx"
+
+func TestMain(m *testing.M) {
+	log = quietLogger{}
+	os.Exit(m.Run())
+}
+
+// parsedTestPackages caches the Go packages parsed by testPackages.
+var parsedTestPackages = make(map[string][]*types.Package)
+
+// testPackages parses the Go packages of testAPIDir matching pattern, relative
+// to its example.com/fx/apis directory, and combines them into API packages.
+func testPackages(t *testing.T, pattern string) []*apiPackage {
+	t.Helper()
+	pkgs, ok := parsedTestPackages[pattern]
+	if !ok {
+		wd, err := os.Getwd()
+		if err != nil {
+			t.Fatal(err)
+		}
+		// the packages are resolved within the module of the working
+		// directory.
+		if err := os.Chdir(testAPIDir); err != nil {
+			t.Fatal(err)
+		}
+		// parseAPIPackages parses the -api-dir.
+		dir := *flAPIDir
+		*flAPIDir = "example.com/fx/apis/" + pattern
+		pkgs, err = parseAPIPackages(*flAPIDir)
+		*flAPIDir = dir
+		if err := os.Chdir(wd); err != nil {
+			t.Fatal(err)
+		}
+		if err != nil {
+			t.Fatalf("failed to parse %s: %v", pattern, err)
+		}
+		parsedTestPackages[pattern] = pkgs
+	}
+	apiPackages, err := combineAPIPackages(pkgs, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return apiPackages
+}
+
+// resetRun clears what the previous renders collected.
+func resetRun() {
+	report = newRunReport()
+	externalImports = make(map[string]map[string]struct{})
+}
+
+// validConfig returns c once validated.
+func validConfig(t *testing.T, c generatorConfig) generatorConfig {
+	t.Helper()
+	if err := c.validate(); err != nil {
+		t.Fatalf("invalid config: %v", err)
+	}
+	return c
+}
+
+// renderTemplate renders the template name of the default templates for pkgs
+// with the config c, the way main does.
+func renderTemplate(t *testing.T, name string, pkgs []*apiPackage, c generatorConfig) string {
+	t.Helper()
+	resetRun()
+	c = validConfig(t, c).withPackages(pkgs)
+	var b bytes.Buffer
+	nw := newNormalizingWriter(&b)
+	if err := render(nw, name, pkgs, precompute(pkgs), c); err != nil {
+		t.Fatalf("failed to render %s: %v", name, err)
+	}
+	if err := nw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return b.String()
+}
+
+// testType returns a struct type of an example.com/apis/v1 package with the
+// given doc comments.
+func testType(name string, comments ...string) *types.Type {
+	return &types.Type{
+		Name:         types.Name{Package: "example.com/apis/v1", Name: name},
+		Kind:         types.Struct,
+		CommentLines: comments,
+	}
+}
+
+// testConfig returns the settings of example-config.json, mapping the Go
+// builtins and the apimachinery types of testAPIDir.
+func testConfig() generatorConfig {
+	return generatorConfig{
+		HiddenMemberFields: []string{"TypeMeta"},
+		HideTypePatterns:   []string{"List$"},
+		ExternalPackages: []externalPackage{
+			{TypeMatchPrefix: `^k8s\.io/apimachinery/`},
+		},
+		ExternalTypes: map[string]map[string]string{
+			"k8s.io/apimachinery/pkg/apis/meta/v1": {"Time": "string", "ObjectMeta": "ObjectMetadata"},
+		},
+		TypeReplacements: map[string]string{"int": "number", "int32": "number", "bool": "boolean"},
+		SliceTemplate:    "{{.type}}[]",
+	}
+}
+
+// findType returns the type called name among the types of pkgs.
+func findType(t *testing.T, pkgs []*apiPackage, name string) *types.Type {
+	t.Helper()
+	for _, p := range pkgs {
+		for _, typ := range p.Types {
+			if typ.Name.Name == name {
+				return typ
+			}
+		}
+	}
+	t.Fatalf("no type %s", name)
+	return nil
+}
+
+// findMember returns the member of typ called name.
+func findMember(t *testing.T, typ *types.Type, name string) types.Member {
+	t.Helper()
+	for _, m := range typ.Members {
+		if m.Name == name {
+			return m
+		}
+	}
+	t.Fatalf("no member %s in %s", name, typ.Name)
+	return types.Member{}
+}
+
+// testMember returns a member of type typ with the struct tags and doc
+// comments given.
+func testMember(name string, typ *types.Type, tags string, comments ...string) types.Member {
+	return types.Member{Name: name, Type: typ, Tags: tags, CommentLines: comments}
+}
+
+func TestMemberTypeOverride(t *testing.T) {
+	tests := []struct {
+		comments []string
+		want     string
+	}{
+		{nil, ""},
+		{[]string{"Raw is raw.", "+ts:type={ foo: string }"}, "{ foo: string }"},
+		{[]string{"+ts:type= Record<string, unknown> "}, "Record<string, unknown>"},
+	}
+	for _, tt := range tests {
+		m := testMember("Raw", types.String, `json:"raw"`, tt.comments...)
+		if got := memberTypeOverride(m, generatorConfig{}, nil); got != tt.want {
+			t.Errorf("memberTypeOverride(%q) = %q, want %q", tt.comments, got, tt.want)
+		}
+	}
+}
+
+func TestMemberTypeOverrideRendered(t *testing.T) {
+	out := renderTemplate(t, "packages", testPackages(t, "foo/v1"), testConfig())
+	if !strings.Contains(out, "raw?: { foo: string };") {
+		t.Errorf("the +ts:type marker of Overrides.Raw is not honored:\n%s", out)
+	}
+}
+
+func TestPrintSummary(t *testing.T) {
+	resetRun()
+	report.unresolvedTypes["example.com/other.Thing"] = struct{}{}
+	pkgs := []*apiPackage{{
+		apiGroup:   "example.com",
+		apiVersion: "v1",
+		Types:      []*types.Type{testType("Widget"), testType("WidgetSpec"), testType("widget")},
+		Constants:  []*types.Type{testType("PhaseReady")},
+	}}
+	var b bytes.Buffer
+	printSummary(&b, pkgs, generatorConfig{})
+	want := `apiPackages: 1
+  example.com/v1: 2 visible types, 1 hidden types, 1 constants
+unresolved types: 1
+  example.com/other.Thing
+`
+	if b.String() != want {
+		t.Errorf("printSummary() =\n%s\nwant:\n%s", b.String(), want)
+	}
+}
+
+func TestAcceptsGzip(t *testing.T) {
+	tests := []struct {
+		header string
+		want   bool
+	}{
+		{"", false},
+		{"gzip", true},
+		{"deflate, gzip;q=0.5", true},
+		{"x-gzip", true},
+		{"GZIP", true},
+		{"gzip;q=0", false},
+		{"gzip; q=0.0", false},
+		{"br", false},
+		{"*", true},
+		{"*;q=0", false},
+		{"*, gzip;q=0", false},
+		{"gzip;q=0, *", false},
+		{"identity, *;q=0.1", true},
+	}
+	for _, tt := range tests {
+		if got := acceptsGzip(tt.header); got != tt.want {
+			t.Errorf("acceptsGzip(%q) = %v, want %v", tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestWriteOutput(t *testing.T) {
+	const body = "export interface Widget {};\n"
+	get := func(headers map[string]string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		for k, v := range headers {
+			r.Header.Set(k, v)
+		}
+		w := httptest.NewRecorder()
+		if err := writeOutput(w, r, body); err != nil {
+			t.Fatal(err)
+		}
+		return w
+	}
+
+	plain := get(nil)
+	etag := plain.Header().Get("ETag")
+	if plain.Code != http.StatusOK || plain.Body.String() != body || etag == "" {
+		t.Fatalf("plain response: %d %q, ETag %q", plain.Code, plain.Body.String(), etag)
+	}
+	if enc := plain.Header().Get("Content-Encoding"); enc != "" {
+		t.Errorf("plain response has Content-Encoding %q", enc)
+	}
+
+	gz := get(map[string]string{"Accept-Encoding": "gzip"})
+	if enc := gz.Header().Get("Content-Encoding"); enc != "gzip" {
+		t.Fatalf("gzip response has Content-Encoding %q", enc)
+	}
+	zr, err := gzip.NewReader(gz.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, err := ioutil.ReadAll(zr); err != nil || string(b) != body {
+		t.Errorf("gzip response body = %q, %v", b, err)
+	}
+	gzETag := gz.Header().Get("ETag")
+	if gzETag == etag {
+		t.Errorf("the plain and gzip responses have the same ETag %s", etag)
+	}
+
+	if w := get(map[string]string{"If-None-Match": etag}); w.Code != http.StatusNotModified || w.Body.Len() != 0 {
+		t.Errorf("matching If-None-Match: %d %q, want 304 without body", w.Code, w.Body.String())
+	}
+	if w := get(map[string]string{"If-None-Match": `"stale"`}); w.Code != http.StatusOK {
+		t.Errorf("stale If-None-Match: %d, want 200", w.Code)
+	}
+	if w := get(map[string]string{"If-None-Match": gzETag, "Accept-Encoding": "gzip"}); w.Code != http.StatusNotModified {
+		t.Errorf("matching gzip If-None-Match: %d, want 304", w.Code)
+	}
+	// the plain response does not match the tag of the gzip one.
+	if w := get(map[string]string{"If-None-Match": gzETag}); w.Code != http.StatusOK {
+		t.Errorf("gzip If-None-Match of a plain request: %d, want 200", w.Code)
+	}
+}
+
+func TestETagMatches(t *testing.T) {
+	const etag = `"abc"`
+	tests := []struct {
+		header string
+		want   bool
+	}{
+		{"", false},
+		{`"abc"`, true},
+		{`W/"abc"`, true},
+		{`"x", "abc"`, true},
+		{`"x",W/"abc" ,"y"`, true},
+		{"*", true},
+		{` * `, true},
+		{`"abcd"`, false},
+		{`"ab"`, false},
+		{`"x, abc"`, false},
+		{`abc`, false},
+		{`"abc`, false},
+	}
+	for _, tt := range tests {
+		if got := etagMatches(tt.header, etag); got != tt.want {
+			t.Errorf("etagMatches(%s) = %v, want %v", tt.header, got, tt.want)
+		}
+	}
+}
+
+// boolPtr returns a pointer to b, for the optional settings.
+func boolPtr(b bool) *bool {
+	return &b
+}
+
+func TestIsOptionalMemberOmitempty(t *testing.T) {
+	omitempty := testMember("Size", types.Int32, `json:"size,omitempty"`)
+	plain := testMember("Size", types.Int32, `json:"size"`)
+	marked := testMember("Size", types.Int32, `json:"size"`, "+optional")
+	tests := []struct {
+		setting *bool
+		m       types.Member
+		want    bool
+	}{
+		{nil, omitempty, true},
+		{nil, plain, false},
+		{boolPtr(true), omitempty, true},
+		{boolPtr(false), omitempty, false},
+		{boolPtr(false), marked, true},
+		{boolPtr(false), plain, false},
+	}
+	for _, tt := range tests {
+		c := validConfig(t, generatorConfig{OptionalFromOmitempty: tt.setting})
+		if got := isOptionalMember(tt.m, c); got != tt.want {
+			t.Errorf("optionalFromOmitempty=%v: isOptionalMember(%s %q) = %v, want %v",
+				c.optionalFromOmitempty(), tt.m.Tags, tt.m.CommentLines, got, tt.want)
+		}
+	}
+}
+
+func TestIsOptionalMemberCollections(t *testing.T) {
+	slice := testMember("Items", &types.Type{Kind: types.Slice, Elem: types.String}, `json:"items,omitempty"`)
+	mapped := testMember("Labels", &types.Type{Kind: types.Map, Key: types.String, Elem: types.String}, `json:"labels,omitempty"`)
+	scalar := testMember("Size", types.Int32, `json:"size,omitempty"`)
+	marked := testMember("Items", slice.Type, `json:"items,omitempty"`, "+optional")
+	tests := []struct {
+		omitempty, collections *bool
+		m                      types.Member
+		want                   bool
+	}{
+		// defaults to optionalFromOmitempty.
+		{nil, nil, slice, true},
+		{boolPtr(false), nil, slice, false},
+		{nil, boolPtr(false), slice, false},
+		{nil, boolPtr(false), mapped, false},
+		{nil, boolPtr(false), scalar, true},
+		{nil, boolPtr(false), marked, true},
+		{boolPtr(false), boolPtr(true), mapped, true},
+		{boolPtr(false), boolPtr(true), scalar, false},
+	}
+	for _, tt := range tests {
+		c := validConfig(t, generatorConfig{OptionalFromOmitempty: tt.omitempty, OptionalCollectionsFromOmitempty: tt.collections})
+		if got := isOptionalMember(tt.m, c); got != tt.want {
+			t.Errorf("optionalFromOmitempty=%v optionalCollectionsFromOmitempty=%v: isOptionalMember(%s %q) = %v, want %v",
+				c.optionalFromOmitempty(), c.optionalCollectionsFromOmitempty(), tt.m.Name, tt.m.CommentLines, got, tt.want)
+		}
+	}
+}
+
+// assertContains fails unless out holds each of the lines of want, and none of
+// those of unwanted.
+func assertContains(t *testing.T, out string, want, unwanted []string) {
+	t.Helper()
+	for _, w := range want {
+		if !strings.Contains(out, w) {
+			t.Errorf("output lacks %q", w)
+		}
+	}
+	for _, w := range unwanted {
+		if strings.Contains(out, w) {
+			t.Errorf("output has %q", w)
+		}
+	}
+	if t.Failed() {
+		t.Logf("output:\n%s", out)
+	}
+}
+
+func TestEnumStyle(t *testing.T) {
+	asConst := []string{
+		"export const PhaseValues = {\nPhaseA: 'A',\nPhaseB: 'B',\n} as const;\n",
+		"export type Phase = typeof PhaseValues[keyof typeof PhaseValues];",
+		"export const LevelValues = {\nLevelHigh: 2,\nLevelLow: 0,\nLevelMid: 1,\n} as const;\n",
+	}
+	union := []string{"export type Phase = 'A' | 'B';", "export type Level = 2 | 0 | 1;"}
+	tests := []struct {
+		style          string
+		hidden         []string
+		want, unwanted []string
+	}{
+		{"", nil, union, asConst},
+		{enumStyleUnion, nil, union, asConst},
+		{enumStyleAsConst, nil, append(asConst, "export type KeyName = string;"), append(union, "KeyNameValues")},
+		// the types whose constants are all hidden are not enums.
+		{enumStyleAsConst, []string{`\.Phase[AB]$`}, []string{"export type Phase = string;", asConst[2]}, asConst[:2]},
+	}
+	pkgs := testPackages(t, "foo/v1")
+	for _, tt := range tests {
+		c := testConfig()
+		c.EnumStyle = tt.style
+		c.HideConstantPatterns = tt.hidden
+		t.Run("enumStyle="+tt.style, func(t *testing.T) {
+			assertContains(t, renderTemplate(t, "packages", pkgs, c), tt.want, tt.unwanted)
+		})
+	}
+}
+
+func TestPackageDisplayName(t *testing.T) {
+	p := &apiPackage{
+		apiGroup:   "foo.example.com",
+		apiVersion: "v1",
+		GoPackages: []*types.Package{{Path: "example.com/fx/apis/foo/v1"}},
+	}
+	tests := []struct {
+		names     map[string]string
+		separator string
+		want      string
+	}{
+		{nil, "", "foo.example.com/v1"},
+		{map[string]string{"foo.example.com/v1": "Foo"}, "", "Foo"},
+		{map[string]string{"example.com/fx/apis/foo/v1": "FooPkg"}, "", "FooPkg"},
+		{map[string]string{"foo.example.com/v1": "Foo", "example.com/fx/apis/foo/v1": "FooPkg"}, "", "Foo"},
+		{map[string]string{"bar.example.com/v1": "Bar"}, "", "foo.example.com/v1"},
+		{nil, "-", "foo.example.com-v1"},
+		{nil, "_", "foo.example.com_v1"},
+		// the keys keep the "/" separator.
+		{map[string]string{"foo.example.com/v1": "Foo"}, "-", "Foo"},
+		{map[string]string{"foo.example.com-v1": "Foo"}, "-", "foo.example.com-v1"},
+	}
+	for _, tt := range tests {
+		c := generatorConfig{PackageDisplayNames: tt.names, IdentifierSeparator: tt.separator}
+		if got := packageDisplayName(p, c); got != tt.want {
+			t.Errorf("packageDisplayName() with %v and separator %q = %q, want %q", tt.names, tt.separator, got, tt.want)
+		}
+	}
+	if got := p.identifier(); got != "foo.example.com/v1" {
+		t.Errorf("identifier() = %q, want %q", got, "foo.example.com/v1")
+	}
+}
+
+func TestIdentifierSeparator(t *testing.T) {
+	useTemplateDir(t, map[string]string{
+		"header.tpl": `{{ define "header" }}// {{ packageDisplayName . }} #{{ packageAnchorID . }}
+{{ range sortedTypes .Types }}// #{{ typeAnchorID . }}: {{ typeDisplayName . }}
+{{ end }}{{ end }}`,
+	})
+	tests := []struct {
+		separator string
+		want      []string
+	}{
+		{"", []string{"// bar.example.com/v1 #bar.example.com/v1", "// #bar.example.com/v1.Gadget: Gadget"}},
+		{"-", []string{"// bar.example.com-v1 #bar.example.com-v1", "// #bar.example.com-v1.Gadget: Gadget"}},
+	}
+	for _, tt := range tests {
+		c := testConfig()
+		c.IdentifierSeparator = tt.separator
+		// config keys are still looked up by group/version.
+		c.TemplatesByGroup = map[string]string{"bar.example.com/v1": "header"}
+		t.Run("identifierSeparator="+tt.separator, func(t *testing.T) {
+			assertContains(t, renderTemplate(t, "packages", testPackages(t, ""), c), tt.want, []string{"export interface Gadget {"})
+		})
+	}
+}
+
+func TestApplyConfigPaths(t *testing.T) {
+	apiDir, templateDir := *flAPIDir, *flTemplateDir
+	defer func() { *flAPIDir, *flTemplateDir = apiDir, templateDir }()
+
+	tests := []struct {
+		name                  string
+		configPath            string
+		c                     generatorConfig
+		wantAPI, wantTemplate string
+	}{
+		{"unset", "testdata/cfg/config.json", generatorConfig{}, "", "template"},
+		{"relative", "testdata/cfg/config.json", generatorConfig{APIDir: "./apis", TemplateDir: "tpl"},
+			"./testdata/cfg/apis", filepath.Join("testdata", "cfg", "tpl")},
+		{"parent", "testdata/cfg/config.json", generatorConfig{APIDir: "../apis/..."}, "./testdata/apis/...", "template"},
+		{"import path", "testdata/cfg/config.json", generatorConfig{APIDir: "example.com/fx/apis/..."}, "example.com/fx/apis/...", "template"},
+		{"absolute", "testdata/cfg/config.json", generatorConfig{TemplateDir: "/srv/tpl"}, "", "/srv/tpl"},
+		{"stdin", "-", generatorConfig{APIDir: "./apis", TemplateDir: "tpl"}, "./apis", "tpl"},
+	}
+	for _, tt := range tests {
+		*flAPIDir, *flTemplateDir = "", "template"
+		applyConfigPaths(tt.c, tt.configPath)
+		if *flAPIDir != tt.wantAPI || *flTemplateDir != tt.wantTemplate {
+			t.Errorf("%s: -api-dir=%q -template-dir=%q, want %q and %q",
+				tt.name, *flAPIDir, *flTemplateDir, tt.wantAPI, tt.wantTemplate)
+		}
+	}
+}
+
+// useTemplateDir makes the tests render the templates of -template-dir
+// copied to a temporary directory, along with the extra templates given by
+// file name, or replaced by them, until the test ends.
+func useTemplateDir(t *testing.T, extra map[string]string) string {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "templates")
+	if err != nil {
+		t.Fatal(err)
+	}
+	files, err := filepath.Glob(filepath.Join(*flTemplateDir, "*.tpl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range files {
+		b, err := ioutil.ReadFile(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, filepath.Base(f)), b, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for name, s := range extra {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(s), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	templateDir := *flTemplateDir
+	*flTemplateDir = dir
+	t.Cleanup(func() {
+		*flTemplateDir = templateDir
+		os.RemoveAll(dir)
+	})
+	return dir
+}
+
+func TestPackageTemplateName(t *testing.T) {
+	p := &apiPackage{apiGroup: "foo.example.com", apiVersion: "v1"}
+	tests := []struct {
+		byGroup map[string]string
+		want    string
+	}{
+		{nil, "package"},
+		{map[string]string{"foo.example.com": "compact"}, "compact"},
+		{map[string]string{"foo.example.com/v1": "versioned"}, "versioned"},
+		{map[string]string{"foo.example.com": "compact", "foo.example.com/v1": "versioned"}, "versioned"},
+		{map[string]string{"bar.example.com": "compact"}, "package"},
+	}
+	for _, tt := range tests {
+		if got := packageTemplateName(p, generatorConfig{TemplatesByGroup: tt.byGroup}); got != tt.want {
+			t.Errorf("packageTemplateName() with %v = %q, want %q", tt.byGroup, got, tt.want)
+		}
+	}
+}
+
+func TestTemplatesByGroup(t *testing.T) {
+	useTemplateDir(t, map[string]string{
+		"compact.tpl": `{{ define "compact" }}// compact {{ packageDisplayName . }}{{ end }}`,
+	})
+	c := testConfig()
+	c.TemplatesByGroup = map[string]string{"bar.example.com": "compact"}
+	out := renderTemplate(t, "packages", testPackages(t, "..."), c)
+	assertContains(t, out, []string{"// compact bar.example.com/v1", "export interface Widget {"},
+		[]string{"export interface Gadget {"})
+}
+
+func TestIsVendorPath(t *testing.T) {
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{"/src/example.com/fx/apis/foo/v1", false},
+		{"/src/example.com/fx/vendor/k8s.io/api/core/v1", true},
+		{filepath.Join("src", "vendor", "example.com", "v1"), true},
+		{"/src/example.com/vendored/v1", false},
+	}
+	for _, tt := range tests {
+		if got := isVendorPath(tt.path); got != tt.want {
+			t.Errorf("isVendorPath(%q) = %v, want %v", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestLocalDirPath(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		dir, want string
+	}{
+		{wd, "."},
+		{filepath.Join(wd, "testdata", "fx"), "./testdata/fx"},
+		{filepath.Join("testdata", "fx", "apis", "..."), "./testdata/fx/apis/..."},
+		{filepath.Dir(wd), ".."},
+		{filepath.Join(filepath.Dir(wd), "other", "apis"), "../other/apis"},
+	}
+	for _, tt := range tests {
+		if got := localDirPath(tt.dir); got != tt.want {
+			t.Errorf("localDirPath(%q) = %q, want %q", tt.dir, got, tt.want)
+		}
+	}
+}
+
+func TestNormalizingWriter(t *testing.T) {
+	tests := []struct {
+		name   string
+		writes []string
+		want   string
+	}{
+		{"empty", nil, "\n"},
+		{"blank", []string{"  \n\t\n"}, "\n"},
+		{"no final newline", []string{"a"}, "a\n"},
+		{"indentation", []string{"    export type A {\n\t\tb: string;  \n}\n"}, "export type A {\nb: string;\n}\n"},
+		{"blank lines", []string{"a\n\n\n  \nb\n\n"}, "a\nb\n"},
+		{"crlf", []string{"a \r\n\r\nb\r\n"}, "a\nb\n"},
+		{"split lines", []string{"  exp", "ort type", " A = string;", "  \n", "\nb"}, "export type A = string;\nb\n"},
+	}
+	for _, tt := range tests {
+		var b bytes.Buffer
+		nw := newNormalizingWriter(&b)
+		for _, s := range tt.writes {
+			if n, err := nw.Write([]byte(s)); err != nil || n != len(s) {
+				t.Fatalf("%s: Write() = %d, %v", tt.name, n, err)
+			}
+		}
+		if err := nw.Close(); err != nil {
+			t.Fatalf("%s: Close() = %v", tt.name, err)
+		}
+		if b.String() != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, b.String(), tt.want)
+		}
+	}
+}
+
+func TestVersionPriority(t *testing.T) {
+	// each version has a higher priority than the next ones.
+	ordered := []string{"v2", "v1", "v2beta1", "v1beta2", "v1beta1", "v2alpha1", "v1alpha3", "v1alpha1", "foo"}
+	for i, v1 := range ordered {
+		for j, v2 := range ordered {
+			if got, want := versionPriority(v1, v2), i < j; got != want {
+				t.Errorf("versionPriority(%s, %s) = %v, want %v", v1, v2, got, want)
+			}
+		}
+	}
+}
+
+// packageIdentifiers returns the identifiers of pkgs.
+func packageIdentifiers(pkgs []*apiPackage) []string {
+	var out []string
+	for _, p := range pkgs {
+		out = append(out, p.identifier())
+	}
+	return out
+}
+
+func TestFilterVersions(t *testing.T) {
+	var pkgs []*apiPackage
+	for _, id := range []string{"bar.example.com/v1beta1", "foo.example.com/v1", "foo.example.com/v1beta1", "foo.example.com/v2alpha1"} {
+		parts := strings.Split(id, "/")
+		pkgs = append(pkgs, &apiPackage{apiGroup: parts[0], apiVersion: parts[1]})
+	}
+	tests := []struct {
+		filter string
+		want   string
+	}{
+		{"latest", "bar.example.com/v1beta1 foo.example.com/v1"},
+		{"v1beta1", "bar.example.com/v1beta1 foo.example.com/v1beta1"},
+		{"v2alpha1", "foo.example.com/v2alpha1"},
+		{"v3", ""},
+	}
+	for _, tt := range tests {
+		if got := strings.Join(packageIdentifiers(filterVersions(pkgs, tt.filter)), " "); got != tt.want {
+			t.Errorf("filterVersions(%s) = %q, want %q", tt.filter, got, tt.want)
+		}
+	}
+}
+
+func TestCrossPackageReferences(t *testing.T) {
+	var all []*apiPackage
+	for _, p := range testPackages(t, "...") {
+		if p.apiGroup == "foo.example.com" {
+			all = append(all, p)
+		}
+	}
+	tests := []struct {
+		filter string
+		want   []string
+	}{
+		{"v1", nil},
+		{"v1beta1", []string{"field BetaThing.Part refers to type Part of the excluded package foo.example.com/v1"}},
+	}
+	for _, tt := range tests {
+		got := crossPackageReferences(filterVersions(all, tt.filter), all)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("-version-filter=%s: crossPackageReferences() = %q, want %q", tt.filter, got, tt.want)
+		}
+	}
+}
+
+func TestIsOptionalMemberMarkers(t *testing.T) {
+	tests := []struct {
+		requiredByDefault *bool
+		tags              string
+		comments          []string
+		want              bool
+	}{
+		{nil, `json:"req,omitempty"`, []string{"+kubebuilder:validation:Required"}, false},
+		{nil, `json:"opt"`, []string{"+optional"}, true},
+		{nil, `json:"opt"`, []string{"+kubebuilder:validation:Optional"}, true},
+		{nil, `json:"plain"`, nil, false},
+		{boolPtr(true), `json:"plain"`, nil, false},
+		{boolPtr(false), `json:"plain"`, nil, true},
+		{boolPtr(false), `json:"req"`, []string{"+kubebuilder:validation:Required"}, false},
+	}
+	for _, tt := range tests {
+		c := validConfig(t, generatorConfig{RequiredByDefault: tt.requiredByDefault})
+		m := testMember("Field", types.String, tt.tags, tt.comments...)
+		if got := isOptionalMember(m, c); got != tt.want {
+			t.Errorf("requiredByDefault=%v: isOptionalMember(%s %q) = %v, want %v",
+				c.requiredByDefault(), tt.tags, tt.comments, got, tt.want)
+		}
+	}
+}
+
+func TestEnumMemberComments(t *testing.T) {
+	documented := "export type Phase =\n/**\n* PhaseA is a.\n*/\n| 'A'\n| 'B';\n"
+	documentedConst := "export const PhaseValues = {\n/**\n* PhaseA is a.\n*/\nPhaseA: 'A',\nPhaseB: 'B',\n} as const;\n"
+	tests := []struct {
+		comments       bool
+		style          string
+		want, unwanted []string
+	}{
+		{false, "", []string{"export type Phase = 'A' | 'B';"}, []string{"* PhaseA is a."}},
+		{true, "", []string{documented}, nil},
+		{true, enumStyleAsConst, []string{documentedConst}, nil},
+	}
+	pkgs := testPackages(t, "foo/v1")
+	for _, tt := range tests {
+		c := testConfig()
+		c.EnumMemberComments, c.EnumStyle = tt.comments, tt.style
+		t.Run(fmt.Sprintf("enumMemberComments=%v,enumStyle=%s", tt.comments, tt.style), func(t *testing.T) {
+			assertContains(t, renderTemplate(t, "packages", pkgs, c), tt.want, tt.unwanted)
+		})
+	}
+}
+
+func TestFormatStyle(t *testing.T) {
+	tests := []struct {
+		style          string
+		warnings       int
+		want, unwanted []string
+	}{
+		{"", 0, []string{"when: string;", "mail: string;", "num: number;"}, []string{"@format", "__format"}},
+		{formatStyleJSDoc, 0, []string{
+			"/**\n* @format date-time\n*/\nwhen: string;",
+			"/**\n* @format email\n*/\nmail: string;",
+			"/**\n* @format weird\n*/\nodd: string;",
+			"*/\nodd: string;\nnum: number;",
+		}, []string{"__format"}},
+		{formatStyleBranded, 1, []string{
+			"when: string & { readonly __format: 'date-time' };",
+			"mail: string & { readonly __format: 'email' };",
+			"odd: string;",
+			"num: number;",
+		}, []string{"@format"}},
+	}
+	pkgs := testPackages(t, "foo/v1")
+	for _, tt := range tests {
+		c := testConfig()
+		c.FormatStyle = tt.style
+		t.Run("formatStyle="+tt.style, func(t *testing.T) {
+			assertContains(t, renderTemplate(t, "packages", pkgs, c), tt.want, tt.unwanted)
+			if n := len(report.warnings[warnFormat]); n != tt.warnings {
+				t.Errorf("%d unknown format warnings, want %d", n, tt.warnings)
+			}
+		})
+	}
+}
+
+func TestSpecSubtrees(t *testing.T) {
+	tests := []struct {
+		maxDepth int
+		want     map[string][]string
+		warning  string
+	}{
+		{0, map[string][]string{
+			"bar.example.com/v1": {"GadgetSpec"},
+			"foo.example.com/v1": {"Part", "Phase", "WidgetSpec"},
+		}, ""},
+		{1, map[string][]string{
+			"bar.example.com/v1": {"GadgetSpec"},
+			"foo.example.com/v1": {"WidgetSpec"},
+		}, "maxReferenceDepth=1 leaves out 2 referenced type(s)"},
+		{2, map[string][]string{
+			"bar.example.com/v1": {"GadgetSpec"},
+			"foo.example.com/v1": {"Part", "Phase", "WidgetSpec"},
+		}, ""},
+	}
+	for _, tt := range tests {
+		resetRun()
+		c := testConfig()
+		c.MaxReferenceDepth = tt.maxDepth
+		pkgs := testPackages(t, "...")
+		got := specSubtrees(pkgs, c)
+		if len(got) != len(pkgs) {
+			t.Errorf("maxReferenceDepth=%d: %d packages, want %d", tt.maxDepth, len(got), len(pkgs))
+		}
+		for _, p := range got {
+			names := typeNames(p.Types)
+			sort.Strings(names)
+			if !reflect.DeepEqual(names, tt.want[p.identifier()]) {
+				t.Errorf("maxReferenceDepth=%d: types of %s = %v, want %v", tt.maxDepth, p.identifier(), names, tt.want[p.identifier()])
+			}
+		}
+		warnings := report.warnings[warnReferenceDepth]
+		if tt.warning == "" && len(warnings) > 0 || tt.warning != "" && !reflect.DeepEqual(warnings, []string{tt.warning}) {
+			t.Errorf("maxReferenceDepth=%d: warnings = %q, want %q", tt.maxDepth, warnings, tt.warning)
+		}
+	}
+
+	// a chain of references through pointers, slices and maps, back to its
+	// start.
+	root, a, b, c, d := testType("Root", "+kubebuilder:object:root=true"), testType("A"), testType("B"), testType("C"), testType("D")
+	root.Members = []types.Member{testMember("Spec", a, `json:"spec"`)}
+	a.Members = []types.Member{testMember("B", &types.Type{Kind: types.Pointer, Elem: b}, `json:"b"`)}
+	b.Members = []types.Member{testMember("C", &types.Type{Kind: types.Slice, Elem: c}, `json:"c"`)}
+	c.Members = []types.Member{testMember("D", &types.Type{Kind: types.Map, Key: types.String, Elem: d}, `json:"d"`)}
+	d.Members = []types.Member{testMember("A", a, `json:"a"`)}
+	chain := []*apiPackage{{apiGroup: "example.com", apiVersion: "v1", Types: []*types.Type{root, a, b, c, d}}}
+	for maxDepth, want := range [][]string{{"A", "B", "C", "D"}, {"A"}, {"A", "B"}, {"A", "B", "C"}, {"A", "B", "C", "D"}} {
+		resetRun()
+		cfg := testConfig()
+		cfg.MaxReferenceDepth = maxDepth
+		if got := typeNames(specSubtrees(chain, cfg)[0].Types); !reflect.DeepEqual(got, want) {
+			t.Errorf("maxReferenceDepth=%d: types = %v, want %v", maxDepth, got, want)
+		}
+		if truncated := maxDepth > 0 && maxDepth < 4; truncated != (len(report.warnings[warnReferenceDepth]) > 0) {
+			t.Errorf("maxReferenceDepth=%d: warnings = %q", maxDepth, report.warnings[warnReferenceDepth])
+		}
+	}
+}
+
+func TestReadConfig(t *testing.T) {
+	f, err := ioutil.TempFile("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	const config = `{"hideTypePatterns": ["List$"]}`
+	if _, err := f.WriteString(config); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Seek(0, 0); err != nil {
+		t.Fatal(err)
+	}
+	stdin := os.Stdin
+	os.Stdin = f
+	defer func() {
+		os.Stdin = stdin
+		f.Close()
+	}()
+
+	tests := []struct {
+		path    string
+		want    string
+		wantErr bool
+	}{
+		{"-", config, false},
+		{f.Name(), config, false},
+		{filepath.Join(os.TempDir(), "missing-config.json"), "", true},
+	}
+	for _, tt := range tests {
+		if _, err := f.Seek(0, 0); err != nil {
+			t.Fatal(err)
+		}
+		b, err := readConfig(tt.path)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("readConfig(%s) error = %v, want error %v", tt.path, err, tt.wantErr)
+		}
+		if string(b) != tt.want {
+			t.Errorf("readConfig(%s) = %q, want %q", tt.path, b, tt.want)
+		}
+	}
+}
+
+func TestOptionalStyle(t *testing.T) {
+	tests := []struct {
+		style          string
+		want, unwanted []string
+	}{
+		{"", []string{"size?: number;", "ptr?: Part;", "names: string[];"}, []string{"| undefined"}},
+		{optionalStyleQuestion, []string{"size?: number;", "ptr?: Part;"}, []string{"| undefined"}},
+		{optionalStyleUndefinedUnion, []string{"size: number | undefined;", "ptr: Part | undefined;", "names: string[];"}, []string{"size?:", "ptr?:"}},
+	}
+	pkgs := testPackages(t, "foo/v1")
+	for _, tt := range tests {
+		c := testConfig()
+		c.OptionalStyle = tt.style
+		t.Run("optionalStyle="+tt.style, func(t *testing.T) {
+			assertContains(t, renderTemplate(t, "packages", pkgs, c), tt.want, tt.unwanted)
+		})
+	}
+
+	c := testConfig()
+	c.OptionalStyle = "maybe"
+	if err := c.validate(); err == nil || !strings.Contains(err.Error(), `unknown optionalStyle "maybe"`) {
+		t.Errorf("validate() = %v, want an unknown optionalStyle error", err)
+	}
+}
+
+func TestMergeVersions(t *testing.T) {
+	resetRun()
+	c := testConfig()
+	c.GroupByGroupOnly = true
+	pkgs := mergeVersions(testPackages(t, "..."), generatorConfig{})
+
+	var groups []string
+	for _, p := range pkgs {
+		groups = append(groups, p.apiGroup)
+		if p.apiVersion != "" {
+			t.Errorf("merged package %s has apiVersion %q", p.apiGroup, p.apiVersion)
+		}
+	}
+	sort.Strings(groups)
+	if want := []string{"bar.example.com", "foo.example.com"}; !reflect.DeepEqual(groups, want) {
+		t.Errorf("merged groups = %v, want %v", groups, want)
+	}
+	want := []string{"type Part is declared by v1, v1alpha1 of foo.example.com, suffixing it with the apiVersion"}
+	if got := report.warnings[warnNameCollision]; !reflect.DeepEqual(got, want) {
+		t.Errorf("name collision warnings = %q, want %q", got, want)
+	}
+
+	out := renderTemplate(t, "packages", pkgs, c)
+	assertContains(t, out, []string{
+		"export interface PartV1 {\nname: string;\n}",
+		"* @version v1alpha1\n*/\nexport interface PartV1alpha1 {\nlegacy: string;\n}",
+		"export interface BetaThing {\npart: PartV1;\n}",
+		"export interface AlphaThing {",
+		"parts: PartV1[];",
+	}, []string{"export interface Part {", ": Part;"})
+}
+
+func TestHiddenMemberTags(t *testing.T) {
+	part := &types.Type{Name: types.Name{Package: "example.com/fx/apis/foo/v1", Name: "Part"}, Kind: types.Struct}
+	embedded := testMember("Part", part, "")
+	embedded.Embedded = true
+	unexportedEmbedded := testMember("part", part, "")
+	unexportedEmbedded.Embedded = true
+	tests := []struct {
+		untagged bool
+		options  []string
+		m        types.Member
+		want     bool
+	}{
+		{false, nil, testMember("Note", types.String, ""), false},
+		{true, nil, testMember("Note", types.String, ""), true},
+		{true, nil, testMember("Note", types.String, `yaml:"note"`), true},
+		{true, nil, testMember("Note", types.String, `json:"note"`), false},
+		{true, nil, embedded, false},
+		{true, nil, testMember("Part", part, ""), true},
+		{false, []string{"omitempty"}, testMember("Note", types.String, `json:"note,omitempty"`), true},
+		{false, []string{"omitempty"}, testMember("Note", types.String, `json:"note"`), false},
+		{false, []string{"string", "inline"}, testMember("Part", part, `json:",inline"`), true},
+		{false, []string{"omitempty"}, testMember("Note", types.String, `json:"omitempty"`), false},
+		// unexported fields are not marshaled, but embedded ones are promoted.
+		{false, nil, testMember("note", types.String, `json:"note"`), true},
+		{false, nil, unexportedEmbedded, false},
+	}
+	for _, tt := range tests {
+		c := testConfig()
+		c.HideUntaggedFields = tt.untagged
+		c.HideTagOptions = tt.options
+		if got := hiddenMember(tt.m, c); got != tt.want {
+			t.Errorf("hideUntaggedFields=%v hideTagOptions=%v: hiddenMember(%s `%s`) = %v, want %v",
+				tt.untagged, tt.options, tt.m.Name, tt.m.Tags, got, tt.want)
+		}
+	}
+}
+
+func TestSharedRender(t *testing.T) {
+	release := make(chan struct{})
+	calls := 0
+	results := []struct {
+		s   string
+		err error
+	}{
+		{"first", nil},
+		{"", fmt.Errorf("broken")},
+		{"third", nil},
+	}
+	r := &sharedRender{render: func(*runReport) (string, error) {
+		<-release
+		res := results[calls]
+		calls++
+		return res.s, res.err
+	}}
+
+	tests := []struct {
+		want    string
+		wantErr bool
+	}{
+		{"first", false},
+		// a failed render returns the last successful output.
+		{"first", true},
+		{"third", false},
+	}
+	for i, tt := range tests {
+		a, b := r.start(), r.start()
+		if a != b {
+			t.Fatalf("render %d: concurrent starts do not share the render", i)
+		}
+		release <- struct{}{}
+		<-a.done
+		if calls != i+1 {
+			t.Errorf("render %d: %d renders, want %d", i, calls, i+1)
+		}
+		if a.s != tt.want || (a.err != nil) != tt.wantErr {
+			t.Errorf("render %d: result = %q, %v, want %q, error %v", i, a.s, a.err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestSharedRenderReports(t *testing.T) {
+	resetRun()
+	pkgs := testPackages(t, "foo/v1")
+	c := validConfig(t, testConfig()).withPackages(pkgs)
+	r := &sharedRender{render: func(rep *runReport) (string, error) {
+		c := c
+		c.report = rep
+		var b bytes.Buffer
+		err := renderResult(&b, formatTypeScript, pkgs, precompute(pkgs), c)
+		return b.String(), err
+	}}
+	var reports []*runReport
+	for i := 0; i < 2; i++ {
+		call := r.start()
+		<-call.done
+		if call.err != nil {
+			t.Fatal(call.err)
+		}
+		reports = append(reports, call.report)
+	}
+	if reports[0] == reports[1] {
+		t.Fatal("renders share their report")
+	}
+	// each render records the union without implementations once.
+	for i, rep := range reports {
+		if n := len(rep.warnings[warnUnion]); n != 1 {
+			t.Errorf("render %d: %d union warnings, want 1", i, n)
+		}
+		if len(rep.usedConfigEntries) == 0 {
+			t.Errorf("render %d: no config entry used", i)
+		}
+	}
+	if n := count(report.warnings) + len(report.usedConfigEntries); n != 0 {
+		t.Errorf("the renders recorded %d entries in the report of the run", n)
+	}
+}
+
+func TestRenderHandler(t *testing.T) {
+	dir := useTemplateDir(t, nil)
+	pkgs := testPackages(t, "foo/v1")
+	c := validConfig(t, testConfig()).withPackages(pkgs)
+	renders := &sharedRender{render: func(*runReport) (string, error) {
+		resetRun()
+		var b bytes.Buffer
+		err := renderResult(&b, formatTypeScript, pkgs, precompute(pkgs), c)
+		return b.String(), err
+	}}
+	h := renderHandler(renders, 0)
+	get := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		h(w, httptest.NewRequest(http.MethodGet, "/", nil))
+		return w
+	}
+
+	good := get()
+	if good.Code != http.StatusOK || !strings.Contains(good.Body.String(), "export interface Widget {") {
+		t.Fatalf("good templates: status %d, body:\n%s", good.Code, good.Body)
+	}
+
+	tests := []struct {
+		name     string
+		template string
+		want     []string
+	}{
+		{
+			name:     "parse error",
+			template: "{{ define \"members\" }}{{ if }}{{ end }}",
+			want:     []string{"template members.tpl, line 1"},
+		},
+		{
+			name:     "execution error",
+			template: "{{ define \"members\" }}{{ template \"missing\" }}{{ end }}",
+			want:     []string{"template \"missing\" not defined"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := ioutil.WriteFile(filepath.Join(dir, "members.tpl"), []byte(tt.template), 0644); err != nil {
+				t.Fatal(err)
+			}
+			w := get()
+			if w.Code != http.StatusInternalServerError {
+				t.Errorf("status = %d, want %d", w.Code, http.StatusInternalServerError)
+			}
+			// the last good result follows the error.
+			want := append([]string{"GENERATION FAILED", "showing the last successful result below.", good.Body.String()}, tt.want...)
+			assertContains(t, w.Body.String(), want, nil)
+		})
+	}
+}
+
+func TestRenderHandlerTimeout(t *testing.T) {
+	release := make(chan struct{})
+	renders := &sharedRender{render: func(*runReport) (string, error) {
+		<-release
+		return "done", nil
+	}}
+	h := renderHandler(renders, 10*time.Millisecond)
+	w := httptest.NewRecorder()
+	h(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusServiceUnavailable)
+	}
+	close(release)
+
+	// the next request gets the result of the render that timed out.
+	w = httptest.NewRecorder()
+	h(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if w.Code != http.StatusOK || w.Body.String() != "done" {
+		t.Errorf("after the render: status %d, body %q", w.Code, w.Body)
+	}
+}
+
+func TestErrorBanner(t *testing.T) {
+	tests := []struct {
+		err   error
+		stale bool
+		want  string
+	}{
+		{
+			err:  fmt.Errorf("broken"),
+			want: "/*\n * GENERATION FAILED\n *\n * broken\n */\n",
+		},
+		{
+			err:   fmt.Errorf("template: type.tpl:12:3: executing \"type\": bad\nsecond */ line"),
+			stale: true,
+			want: "/*\n * GENERATION FAILED\n * template type.tpl, line 12\n *\n" +
+				" * template: type.tpl:12:3: executing \"type\": bad\n * second * / line\n" +
+				" *\n * showing the last successful result below.\n */\n",
+		},
+	}
+	for _, tt := range tests {
+		if got := errorBanner(tt.err, tt.stale); got != tt.want {
+			t.Errorf("errorBanner(%q, %v) = %q, want %q", tt.err, tt.stale, got, tt.want)
+		}
+	}
+}
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir, err := ioutil.TempDir("", "output")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	out := filepath.Join(dir, "out.ts")
+
+	tests := []struct {
+		content string
+		err     error
+		want    string
+	}{
+		{"first\n", nil, "first\n"},
+		{"second\n", nil, "second\n"},
+		// a failed write leaves the previous file intact.
+		{"partial", fmt.Errorf("render failed"), "second\n"},
+	}
+	for _, tt := range tests {
+		err := writeFileAtomic(out, func(w io.Writer) error {
+			if _, err := io.WriteString(w, tt.content); err != nil {
+				return err
+			}
+			return tt.err
+		})
+		if err != tt.err {
+			t.Errorf("writeFileAtomic(%q) = %v, want %v", tt.content, err, tt.err)
+		}
+		b, err := ioutil.ReadFile(out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != tt.want {
+			t.Errorf("after writing %q: file = %q, want %q", tt.content, b, tt.want)
+		}
+		fi, err := os.Stat(out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fi.Mode().Perm() != 0644 {
+			t.Errorf("file mode = %v, want 0644", fi.Mode().Perm())
+		}
+		files, err := ioutil.ReadDir(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(files) != 1 {
+			t.Errorf("after writing %q: %d files left in the directory, want 1", tt.content, len(files))
+		}
+	}
+}
+
+func TestDropExternalMembers(t *testing.T) {
+	external := func(name string) *types.Type {
+		return &types.Type{Name: types.Name{Package: "k8s.io/apimachinery/pkg/apis/meta/v1", Name: name}, Kind: types.Struct}
+	}
+	local := &types.Type{Name: types.Name{Package: "example.com/fx/apis/foo/v1", Name: "Part"}, Kind: types.Struct}
+	drop := []string{"k8s.io/apimachinery/pkg/apis/meta/v1.OwnerReference", "example.com/fx/apis/foo/v1.Part"}
+	tests := []struct {
+		typ  *types.Type
+		want bool
+	}{
+		{external("OwnerReference"), true},
+		{external("Time"), false},
+		// only the types of ExternalPackages can be dropped.
+		{local, false},
+	}
+	c := testConfig()
+	c.DropExternalMembers = drop
+	for _, tt := range tests {
+		m := testMember("Field", tt.typ, `json:"field"`)
+		if got := hiddenMember(m, c); got != tt.want {
+			t.Errorf("hiddenMember(%s) = %v, want %v", tt.typ, got, tt.want)
+		}
+	}
+}
+
+func TestRenderResultContentHash(t *testing.T) {
+	pkgs := testPackages(t, "foo/v1")
+	body := renderTemplate(t, "packages", pkgs, testConfig())
+	tests := []struct {
+		emit bool
+		want string
+	}{
+		{false, body},
+		{true, "// content-hash: " + contentHash([]byte(body)) + "\n" + body},
+	}
+	for _, tt := range tests {
+		c := testConfig()
+		c.EmitContentHash = tt.emit
+		c = validConfig(t, c).withPackages(pkgs)
+		var b bytes.Buffer
+		if err := renderResult(&b, formatTypeScript, pkgs, precompute(pkgs), c); err != nil {
+			t.Fatal(err)
+		}
+		if b.String() != tt.want {
+			t.Errorf("emitContentHash=%v: output starts with %q, want %q", tt.emit, firstLine(b.String()), firstLine(tt.want))
+		}
+	}
+
+	if h := contentHash([]byte(body)); len(h) != 8 || h == contentHash([]byte(body+"\n")) {
+		t.Errorf("contentHash() = %q, want 8 hex digits changing with the body", h)
+	}
+}
+
+// firstLine returns the first line of s.
+func firstLine(s string) string {
+	return strings.SplitN(s, "\n", 2)[0]
+}
+
+func TestMemberTypeOverrideOneOf(t *testing.T) {
+	testOverrides := func(t *testing.T, c generatorConfig, tests [][2]string) {
+		t.Helper()
+		pkgs := testPackages(t, "foo/v1")
+		c = validConfig(t, c).withPackages(pkgs)
+		typePkgMap := extractTypeToPackageMap(pkgs)
+		for _, tt := range tests {
+			m := findMember(t, findType(t, pkgs, "OneOfs"), tt[0])
+			if got := memberTypeOverride(m, c, typePkgMap); got != tt[1] {
+				t.Errorf("OneOfs.%s: memberTypeOverride() = %q, want %q", tt[0], got, tt[1])
+			}
+		}
+	}
+	testOverrides(t, testConfig(), [][2]string{
+		{"Port", "string | number"},
+		{"Any", "Circle | Square | string"},
+		// the alternatives are named like the types they refer to.
+		{"Ren", "MyRenamed"},
+	})
+	c := testConfig()
+	c.TypeNameTemplate = "{{title .shortGroup}}{{.name}}"
+	testOverrides(t, c, [][2]string{
+		{"Any", "FooCircle | FooSquare | string"},
+	})
+	assertContains(t, renderTemplate(t, "packages", testPackages(t, "foo/v1"), testConfig()),
+		[]string{"port: string | number;", "any: Circle | Square | string;", "ren: MyRenamed;"}, nil)
+}
+
+func TestOutputFormats(t *testing.T) {
+	format := *flFormat
+	defer func() { *flFormat = format }()
+
+	tests := []struct {
+		flag string
+		want []string
+	}{
+		{formatTypeScript, []string{"typescript"}},
+		{"typescript, openapi", []string{"typescript", "openapi"}},
+		{"openapi", []string{"openapi"}},
+	}
+	for _, tt := range tests {
+		*flFormat = tt.flag
+		if got := outputFormats(); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("-format=%q: outputFormats() = %q, want %q", tt.flag, got, tt.want)
+		}
+	}
+
+	pkgs := testPackages(t, "foo/v1")
+	c := testConfig()
+	c.TopPragmas = []string{"// @ts-nocheck"}
+	c = validConfig(t, c).withPackages(pkgs)
+	for _, format := range []string{formatTypeScript, formatOpenAPI} {
+		var b bytes.Buffer
+		if err := renderResult(&b, format, pkgs, precompute(pkgs), c); err != nil {
+			t.Fatalf("failed to render %s: %v", format, err)
+		}
+		switch format {
+		case formatTypeScript:
+			assertContains(t, b.String(), []string{"// @ts-nocheck\n", "export interface Part {"}, []string{`"components"`})
+		case formatOpenAPI:
+			var doc struct {
+				Components struct {
+					Schemas map[string]openAPISchema `json:"schemas"`
+				} `json:"components"`
+			}
+			if err := json.Unmarshal(b.Bytes(), &doc); err != nil {
+				t.Fatalf("the OpenAPI output is not JSON: %v", err)
+			}
+			if _, ok := doc.Components.Schemas["Part"]; !ok {
+				t.Errorf("the OpenAPI output has no Part schema")
+			}
+			assertContains(t, b.String(), nil, []string{"@ts-nocheck"})
+		}
+	}
+}
+
+func TestPrecompute(t *testing.T) {
+	pkgs := testPackages(t, "...")
+	idx := precompute(pkgs)
+	for _, p := range pkgs {
+		for _, typ := range p.Types {
+			if idx.typePkgMap[typ] != p {
+				t.Errorf("type %s is mapped to package %v, want %s", typ.Name, idx.typePkgMap[typ], p.identifier())
+			}
+		}
+	}
+	tests := []struct {
+		typ  string
+		want []string
+	}{
+		{"WidgetSpec", []string{"Widget"}},
+		{"helperThing", []string{"UsesHelper"}},
+		{"UsesHelper", nil},
+	}
+	for _, tt := range tests {
+		got := typeNames(idx.references[findType(t, pkgs, tt.typ)])
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("types referring to %s = %v, want %v", tt.typ, got, tt.want)
+		}
+	}
+	// the constants are indexed under their type, wherever they are declared.
+	for _, typ := range []string{"Phase", "Level", "Mode", "WidgetSpec"} {
+		got := typeNames(idx.constants[findType(t, pkgs, typ)])
+		var want []string
+		for _, p := range pkgs {
+			for _, v := range p.Constants {
+				if v.Underlying == findType(t, pkgs, typ) {
+					want = append(want, v.Name.Name)
+				}
+			}
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("constants of %s = %v, want %v", typ, got, want)
+		}
+	}
+
+	// the index is shared by the renders, which must not alter it.
+	c := validConfig(t, testConfig()).withPackages(pkgs)
+	var outputs []string
+	for i := 0; i < 2; i++ {
+		var b bytes.Buffer
+		if err := renderResult(&b, formatTypeScript, pkgs, idx, c); err != nil {
+			t.Fatal(err)
+		}
+		outputs = append(outputs, b.String())
+	}
+	if outputs[0] != outputs[1] {
+		t.Errorf("renders sharing the index differ")
+	}
+}
+
+// largePackages builds n API packages of m enums and m structs each, every
+// struct referring to an enum and to the previous struct.
+func largePackages(n, m int) []*apiPackage {
+	var pkgs []*apiPackage
+	for i := 0; i < n; i++ {
+		path := fmt.Sprintf("example.com/apis/group%d/v1", i)
+		p := &apiPackage{apiGroup: fmt.Sprintf("group%d.example.com", i), apiVersion: "v1"}
+		var prev *types.Type
+		for j := 0; j < m; j++ {
+			enum := &types.Type{Name: types.Name{Package: path, Name: fmt.Sprintf("Enum%d", j)}, Kind: types.Alias, Underlying: types.String}
+			for k := 0; k < 4; k++ {
+				value := fmt.Sprintf("value%d", k)
+				p.Constants = append(p.Constants, &types.Type{
+					Name:       types.Name{Package: path, Name: fmt.Sprintf("Enum%dValue%d", j, k)},
+					Kind:       types.DeclarationOf,
+					Underlying: enum,
+					ConstValue: &value,
+				})
+			}
+			members := []types.Member{{Name: "Enum", Type: enum, Tags: `json:"enum"`}}
+			if prev != nil {
+				members = append(members, types.Member{Name: "Prev", Type: prev, Tags: `json:"prev,omitempty"`})
+			}
+			prev = &types.Type{Name: types.Name{Package: path, Name: fmt.Sprintf("Struct%d", j)}, Kind: types.Struct, Members: members}
+			p.Types = append(p.Types, enum, prev)
+		}
+		pkgs = append(pkgs, p)
+	}
+	return pkgs
+}
+
+func BenchmarkRender(b *testing.B) {
+	pkgs := largePackages(20, 50)
+	c := testConfig()
+	if err := c.validate(); err != nil {
+		b.Fatal(err)
+	}
+	c = c.withPackages(pkgs)
+	b.Run("precomputed", func(b *testing.B) {
+		idx := precompute(pkgs)
+		for i := 0; i < b.N; i++ {
+			if err := render(ioutil.Discard, "packages", pkgs, idx, c); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("inline", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if err := render(ioutil.Discard, "packages", pkgs, precompute(pkgs), c); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func TestEnumsOutFile(t *testing.T) {
+	pkgs := testPackages(t, "foo/v1")
+	c := validConfig(t, testConfig()).withPackages(pkgs)
+	tests := []struct {
+		outFile, enumsFile string
+		want               string
+	}{
+		{"out/types.ts", "out/enums.ts", "import { Level, Phase } from './enums';\n\n"},
+		{"out/types.ts", "out/gen/enums.ts", "import { Level, Phase } from './gen/enums';\n\n"},
+		{"out/types/all.ts", "out/enums.ts", "import { Level, Phase } from '../enums';\n\n"},
+	}
+	for _, tt := range tests {
+		got, err := enumsImport(pkgs, c, tt.outFile, tt.enumsFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("enumsImport(%s, %s) = %q, want %q", tt.outFile, tt.enumsFile, got, tt.want)
+		}
+	}
+
+	c = testConfig()
+	c.outFile, c.enumsOutFile = "out/types.ts", "out/enums.ts"
+	enums := renderTemplate(t, "enums", pkgs, c)
+	assertContains(t, enums, []string{"export type Phase = 'A' | 'B';", "export type Level = 2 | 0 | 1;"},
+		[]string{"export interface", "import "})
+	out := renderTemplate(t, "packages", pkgs, c)
+	assertContains(t, out, []string{"import { Level, Phase } from './enums';\n", "phase: Phase;"},
+		[]string{"export type Phase =", "export type Level ="})
+}
+
+func TestNullablePointers(t *testing.T) {
+	nullable := []string{
+		"mail: string | null;",
+		"ptr?: Part | null;",
+		"parts: (Part | null)[];",
+		"a: (Part | null)[];",
+		"b: Part[] | null;",
+		"e: (Part | null)[] | null;",
+		"b: Record<string, Record<string, Part | null>>;",
+	}
+	tests := []struct {
+		nullable       bool
+		want, unwanted []string
+	}{
+		{false, []string{"mail: string;", "ptr?: Part;", "parts: Part[];", "e: Part[];"}, []string{"null"}},
+		{true, append(nullable, "size?: number;", "names: string[];"), []string{"size?: number | null;"}},
+	}
+	pkgs := testPackages(t, "foo/v1")
+	for _, tt := range tests {
+		c := testConfig()
+		c.NullablePointers = tt.nullable
+		t.Run(fmt.Sprintf("nullablePointers=%v", tt.nullable), func(t *testing.T) {
+			assertContains(t, renderTemplate(t, "packages", pkgs, c), tt.want, tt.unwanted)
+		})
+	}
+}
+
+func TestListTypes(t *testing.T) {
+	pkgs := testPackages(t, "bar/v1")
+	c := testConfig()
+	c.HideTypePatterns = []string{"Spec$"}
+	var b bytes.Buffer
+	listTypes(&b, pkgs, validConfig(t, c).withPackages(pkgs))
+	want := [][]string{
+		{"PACKAGE", "TYPE", "VISIBILITY", "ROOT"},
+		{"bar.example.com/v1", "Gadget", "visible", "root"},
+		{"bar.example.com/v1", "GadgetSpec", `hidden: matches hideTypePatterns "Spec$"`},
+		{"bar.example.com/v1", "Mode", "visible"},
+	}
+	var got [][]string
+	columns := regexp.MustCompile(`\s{2,}`)
+	for _, l := range strings.Split(strings.TrimSuffix(b.String(), "\n"), "\n") {
+		got = append(got, columns.Split(strings.TrimSpace(l), -1))
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("listTypes() = %q, want %q", got, want)
+	}
+}
+
+func TestTopPragmas(t *testing.T) {
+	tests := []struct {
+		pragmas []string
+		want    string
+	}{
+		{nil, ""},
+		{[]string{"// @ts-nocheck"}, "// @ts-nocheck\n"},
+		{[]string{"  /* eslint-disable */ ", "// @ts-nocheck"}, "/* eslint-disable */\n// @ts-nocheck\n"},
+	}
+	for _, tt := range tests {
+		if got := topPragmas(generatorConfig{TopPragmas: tt.pragmas}); got != tt.want {
+			t.Errorf("topPragmas(%q) = %q, want %q", tt.pragmas, got, tt.want)
+		}
+	}
+
+	// the pragmas come before the content hash.
+	pkgs := testPackages(t, "foo/v1")
+	c := testConfig()
+	c.TopPragmas = []string{"/* eslint-disable */"}
+	c.EmitContentHash = true
+	c = validConfig(t, c).withPackages(pkgs)
+	var b bytes.Buffer
+	if err := renderResult(&b, formatTypeScript, pkgs, precompute(pkgs), c); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(b.String(), "/* eslint-disable */\n// content-hash: ") {
+		t.Errorf("output starts with %q, want the pragmas then the content hash", firstLine(b.String()))
+	}
+}
+
+func TestParseVersion(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []int
+		ok   bool
+	}{
+		{"v1.24", []int{1, 24}, true},
+		{"1.24.3", []int{1, 24, 3}, true},
+		{" v2 ", []int{2}, true},
+		{"v1.x", nil, false},
+		{"v1.-2", nil, false},
+		{"", nil, false},
+	}
+	for _, tt := range tests {
+		got, ok := parseVersion(tt.in)
+		if !reflect.DeepEqual(got, tt.want) || ok != tt.ok {
+			t.Errorf("parseVersion(%q) = %v, %v, want %v, %v", tt.in, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestCompareVersions(t *testing.T) {
+	tests := []struct {
+		a, b []int
+		want int
+	}{
+		{[]int{1, 24}, []int{1, 24}, 0},
+		{[]int{1, 24}, []int{1, 24, 0}, 0},
+		{[]int{1, 9}, []int{1, 24}, -1},
+		{[]int{2}, []int{1, 99, 9}, 1},
+		{[]int{1, 24, 1}, []int{1, 24}, 1},
+	}
+	for _, tt := range tests {
+		if got := compareVersions(tt.a, tt.b); got != tt.want {
+			t.Errorf("compareVersions(%v, %v) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestInTargetVersion(t *testing.T) {
+	tests := []struct {
+		target   string
+		comments []string
+		want     bool
+	}{
+		{"v1.24", nil, true},
+		{"v1.24", []string{"+ts:since=v1.24"}, true},
+		{"v1.23", []string{"+ts:since=v1.24"}, false},
+		{"v1.24", []string{"+ts:until=v1.24"}, false},
+		{"v1.23.9", []string{"+ts:until=v1.24"}, true},
+		{"v1.25", []string{"+ts:since=v1.20", "+ts:until=v1.26"}, true},
+		{"v1.26", []string{"+ts:since=v1.20", "+ts:until=v1.26"}, false},
+		// invalid markers are ignored.
+		{"v1.0", []string{"+ts:since=later"}, true},
+	}
+	for _, tt := range tests {
+		m := testMember("Field", types.String, `json:"field"`, tt.comments...)
+		if got := inTargetVersion(m, tt.target); got != tt.want {
+			t.Errorf("inTargetVersion(%q, %s) = %v, want %v", tt.comments, tt.target, got, tt.want)
+		}
+	}
+
+	// -target-version hides the fields out of the target version.
+	c := testConfig()
+	c.targetVersion = "v1.23"
+	if !hiddenMember(testMember("Field", types.String, `json:"field"`, "+ts:since=v1.24"), c) {
+		t.Errorf("a field introduced after -target-version is not hidden")
+	}
+}
+
+func TestMemberType(t *testing.T) {
+	part := &types.Type{Name: types.Name{Package: "example.com/apis/v1", Name: "Part"}, Kind: types.Struct}
+	ptr := &types.Type{Kind: types.Pointer, Elem: part}
+	typePkgMap := map[*types.Type]*apiPackage{part: {}}
+	tests := []struct {
+		nullable bool
+		style    string
+		m        types.Member
+		want     string
+	}{
+		{false, "", testMember("Ptr", ptr, `json:"ptr,omitempty"`), "Part"},
+		{true, "", testMember("Ptr", ptr, `json:"ptr,omitempty"`), "Part | null"},
+		{true, optionalStyleUndefinedUnion, testMember("Ptr", ptr, `json:"ptr,omitempty"`), "Part | null | undefined"},
+		{true, optionalStyleUndefinedUnion, testMember("Ptr", ptr, `json:"ptr"`), "Part | null"},
+		{false, optionalStyleUndefinedUnion, testMember("Raw", types.String, `json:"raw,omitempty"`, "+ts:type={ foo: string }"), "{ foo: string } | undefined"},
+	}
+	for _, tt := range tests {
+		c := testConfig()
+		c.NullablePointers = tt.nullable
+		c.OptionalStyle = tt.style
+		c = validConfig(t, c)
+		if got := memberType(tt.m, c, typePkgMap); got != tt.want {
+			t.Errorf("nullablePointers=%v optionalStyle=%q: memberType(%s `%s`) = %q, want %q",
+				tt.nullable, tt.style, tt.m.Name, tt.m.Tags, got, tt.want)
+		}
+	}
+}
+
+func TestRootFieldExclude(t *testing.T) {
+	definition := "'Widget': CustomResourceDefinition<ObjectMetadata, WidgetSpec, WidgetStatus>;"
+	tests := []struct {
+		exclude []string
+		want    string
+	}{
+		// no field is excluded by default.
+		{nil, "export interface Widget {\nmetadata?: ObjectMetadata;\nspec?: WidgetSpec;\nstatus?: WidgetStatus;\n}"},
+		{[]string{"metadata", "status"}, "export interface Widget {\nspec?: WidgetSpec;\n}"},
+		{[]string{"spec"}, "export interface Widget {\nmetadata?: ObjectMetadata;\nstatus?: WidgetStatus;\n}"},
+	}
+	pkgs := testPackages(t, "foo/v1")
+	for _, tt := range tests {
+		c := testConfig()
+		c.RootFieldExclude = tt.exclude
+		t.Run(fmt.Sprintf("rootFieldExclude=%v", tt.exclude), func(t *testing.T) {
+			// only the root kinds lose the fields, and their definition keeps them.
+			assertContains(t, renderTemplate(t, "packages", pkgs, c),
+				[]string{tt.want, definition, "export interface Embeds extends Part {\nstatus: WidgetStatus;"}, nil)
+		})
+	}
+}
+
+func TestWriteResultPostCmd(t *testing.T) {
+	pkgs := testPackages(t, "foo/v1")
+	c := validConfig(t, testConfig()).withPackages(pkgs)
+	idx := precompute(pkgs)
+	results := make(map[string]string)
+	for _, format := range []string{formatTypeScript, formatOpenAPI} {
+		resetRun()
+		var b bytes.Buffer
+		if err := renderResult(&b, format, pkgs, idx, c); err != nil {
+			t.Fatal(err)
+		}
+		results[format] = b.String()
+	}
+
+	tests := []struct {
+		postCmd string
+		format  string
+		wantErr string
+	}{
+		{"", formatTypeScript, ""},
+		{"cat", formatTypeScript, ""},
+		{"cat -", formatTypeScript, ""},
+		{"false", formatTypeScript, `-post-cmd "false" failed`},
+		{"cat /nonexistent", formatTypeScript, "/nonexistent"},
+		// only the TypeScript output is post-processed.
+		{"false", formatOpenAPI, ""},
+	}
+	for _, tt := range tests {
+		resetRun()
+		var b bytes.Buffer
+		err := writeResult(&b, tt.format, pkgs, idx, c, tt.postCmd)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("-post-cmd %q: error = %v, want one containing %q", tt.postCmd, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("-post-cmd %q: %v", tt.postCmd, err)
+		} else if b.String() != results[tt.format] {
+			t.Errorf("-post-cmd %q changed the %s output:\n%s", tt.postCmd, tt.format, b.String())
+		}
+	}
+}
+
+func TestLoadConfigs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "configs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	files := map[string]string{
+		"base.json": `{
+			"hideTypePatterns": ["List$"],
+			"typeReplacements": {"example.com/apis/v1.Quantity": "string"},
+			"sliceTemplate": "Array<{{ . }}>",
+			"nullablePointers": true
+		}`,
+		"override.json": `{
+			"hideTypePatterns": ["Status$"],
+			"typeReplacements": {"example.com/apis/v1.Duration": "number"},
+			"sliceTemplate": "ReadonlyArray<{{ . }}>"
+		}`,
+		"clear.json":   `{"hideTypePatterns": []}`,
+		"unknown.json": `{"sliceTemplates": "Array<{{ . }}>"}`,
+	}
+	for name, s := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(s), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		files   []string
+		want    generatorConfig
+		wantErr string
+	}{
+		{
+			files: []string{"base.json"},
+			want: generatorConfig{
+				HideTypePatterns: []string{"List$"},
+				TypeReplacements: map[string]string{"example.com/apis/v1.Quantity": "string"},
+				SliceTemplate:    "Array<{{ . }}>",
+				NullablePointers: true,
+			},
+		},
+		{
+			// later files win for values and lists, and add to maps.
+			files: []string{"base.json", "override.json"},
+			want: generatorConfig{
+				HideTypePatterns: []string{"Status$"},
+				TypeReplacements: map[string]string{
+					"example.com/apis/v1.Quantity": "string",
+					"example.com/apis/v1.Duration": "number",
+				},
+				SliceTemplate:    "ReadonlyArray<{{ . }}>",
+				NullablePointers: true,
+			},
+		},
+		{
+			files: []string{"override.json", "base.json"},
+			want: generatorConfig{
+				HideTypePatterns: []string{"List$"},
+				TypeReplacements: map[string]string{
+					"example.com/apis/v1.Quantity": "string",
+					"example.com/apis/v1.Duration": "number",
+				},
+				SliceTemplate:    "Array<{{ . }}>",
+				NullablePointers: true,
+			},
+		},
+		{
+			// an empty list clears the list of the previous files.
+			files: []string{"base.json", "clear.json"},
+			want: generatorConfig{
+				HideTypePatterns: []string{},
+				TypeReplacements: map[string]string{"example.com/apis/v1.Quantity": "string"},
+				SliceTemplate:    "Array<{{ . }}>",
+				NullablePointers: true,
+			},
+		},
+		// each file is checked for unknown settings.
+		{files: []string{"base.json", "unknown.json"}, wantErr: "unknown.json"},
+		{files: []string{"base.json", "missing.json"}, wantErr: "failed to open config file"},
+	}
+	for _, tt := range tests {
+		var paths []string
+		for _, f := range tt.files {
+			paths = append(paths, filepath.Join(dir, f))
+		}
+		b, err := loadConfigs(paths)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("loadConfigs(%v) error = %v, want one containing %q", tt.files, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("loadConfigs(%v): %v", tt.files, err)
+			continue
+		}
+		var got generatorConfig
+		if err := decodeConfig(b, &got); err != nil {
+			t.Fatalf("loadConfigs(%v) = %s: %v", tt.files, b, err)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("loadConfigs(%v) = %+v, want %+v", tt.files, got, tt.want)
+		}
+	}
+}
+
+func TestTypeMetadataTags(t *testing.T) {
+	tests := []struct {
+		emit           bool
+		want, unwanted []string
+	}{
+		{false, []string{
+			"/**\n* Gadget is a gadget.\n*/\nexport interface Gadget {",
+			"export interface innerThing {",
+		}, []string{"@group", "@version"}},
+		{true, []string{
+			"/**\n* Gadget is a gadget.\n* @group bar.example.com\n* @version v1\n*/\nexport interface Gadget {",
+			"/**\n* Widget is a widget.\n* @group foo.example.com\n* @version v1\n*/\nexport interface Widget {",
+			// undocumented types get the tags too.
+			"/**\n* @group foo.example.com\n* @version v1\n*/\nexport interface innerThing {",
+		}, nil},
+	}
+	pkgs := testPackages(t, "...")
+	for _, tt := range tests {
+		c := testConfig()
+		c.EmitTypeMetadataTags = tt.emit
+		t.Run(fmt.Sprintf("emitTypeMetadataTags=%v", tt.emit), func(t *testing.T) {
+			assertContains(t, renderTemplate(t, "packages", pkgs, c), tt.want, tt.unwanted)
+		})
+	}
+}
+
+func TestDeclarationKind(t *testing.T) {
+	phase := "export type Phase = 'A' | 'B';"
+	tests := []struct {
+		kind           string
+		want, unwanted []string
+	}{
+		{"", []string{
+			"export interface Part {\nname: string;\n}",
+			"export interface Embeds extends Part {\nstatus: WidgetStatus;",
+			phase,
+		}, []string{"export type Part =", "& Part"}},
+		{declarationInterface, []string{
+			"export interface Part {\nname: string;\n}",
+			"export interface Embeds extends Part {\nstatus: WidgetStatus;",
+			phase,
+		}, []string{"export type Part =", "& Part"}},
+		// embedded types are intersected instead of extended.
+		{declarationType, []string{
+			"export type Part = {\nname: string;\n} ;",
+			"export type Embeds = {\nstatus: WidgetStatus;\nname: string;\n}  & Part;",
+			phase,
+		}, []string{"export interface", "extends Part"}},
+	}
+	pkgs := testPackages(t, "foo/v1")
+	for _, tt := range tests {
+		c := testConfig()
+		c.DeclarationKind = tt.kind
+		t.Run("declarationKind="+tt.kind, func(t *testing.T) {
+			assertContains(t, renderTemplate(t, "packages", pkgs, c), tt.want, tt.unwanted)
+		})
+	}
+
+	c := testConfig()
+	c.DeclarationKind = "class"
+	if err := c.validate(); err == nil || !strings.Contains(err.Error(), `unknown declarationKind "class"`) {
+		t.Errorf("validate() = %v, want an unknown declarationKind error", err)
+	}
+}
+
+func TestCombineAPIPackages(t *testing.T) {
+	pkg := func(path, name string) *types.Package {
+		return &types.Package{
+			Path:      path,
+			Name:      name,
+			Comments:  []string{"+groupName=example.com"},
+			Types:     map[string]*types.Type{name + "Type": testType(name + "Type")},
+			Constants: map[string]*types.Type{},
+		}
+	}
+	v1, v1beta1, otherV1 := pkg("example.com/apis/v1", "v1"), pkg("example.com/apis/v1beta1", "v1beta1"), pkg("example.com/more/v1", "v1")
+	tests := []struct {
+		name     string
+		pkgs     []*types.Package
+		want     map[string]int
+		warnings int
+		wantErr  []string
+	}{
+		{
+			name: "valid",
+			pkgs: []*types.Package{v1beta1, v1, otherV1},
+			want: map[string]int{"example.com/v1": 2, "example.com/v1beta1": 1},
+		},
+		{
+			name:     "mixed",
+			pkgs:     []*types.Package{v1, pkg("example.com/apis/internal", "internal"), v1beta1, pkg("example.com/apis/v1/util", "util")},
+			want:     map[string]int{"example.com/v1": 1, "example.com/v1beta1": 1},
+			warnings: 2,
+			wantErr: []string{
+				"2 package(s) have no apiVersion (-strict-versions)",
+				"could not get apiVersion for package example.com/apis/internal",
+				"could not get apiVersion for package example.com/apis/v1/util",
+			},
+		},
+		{
+			name:     "unversioned only",
+			pkgs:     []*types.Package{pkg("example.com/apis/internal", "internal")},
+			want:     map[string]int{},
+			warnings: 1,
+			wantErr:  []string{"1 package(s) have no apiVersion (-strict-versions)"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resetRun()
+			got, err := combineAPIPackages(tt.pkgs, false)
+			if err != nil {
+				t.Fatalf("combineAPIPackages() = %v", err)
+			}
+			gotTypes := make(map[string]int)
+			var ids []string
+			for _, p := range got {
+				gotTypes[p.identifier()] = len(p.Types)
+				ids = append(ids, p.identifier())
+			}
+			if !reflect.DeepEqual(gotTypes, tt.want) {
+				t.Errorf("packages = %v, want %v", gotTypes, tt.want)
+			}
+			if !sort.StringsAreSorted(ids) {
+				t.Errorf("packages are not sorted: %v", ids)
+			}
+			if n := len(report.warnings[warnVersionSkip]); n != tt.warnings {
+				t.Errorf("%d skipped package warnings, want %d", n, tt.warnings)
+			}
+
+			resetRun()
+			_, err = combineAPIPackages(tt.pkgs, true)
+			if tt.wantErr == nil {
+				if err != nil {
+					t.Errorf("-strict-versions: %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatal("-strict-versions: no error")
+			}
+			// all the offending packages are reported at once.
+			for _, s := range tt.wantErr {
+				if !strings.Contains(err.Error(), s) {
+					t.Errorf("-strict-versions: error %q lacks %q", err, s)
+				}
+			}
+		})
+	}
+}
+
+func TestAmbientDeclarations(t *testing.T) {
+	tests := []struct {
+		ambient        bool
+		want, unwanted []string
+	}{
+		{false, []string{
+			"type ObjectMetadata = {",
+			"export interface Widget {",
+			"export type Phase = 'A' | 'B';",
+			"export type CustomResourceDefinition<Metadata, Spec, Status> = {",
+		}, []string{"declare"}},
+		{true, []string{
+			"declare type ObjectMetadata = {",
+			"export declare interface Widget {",
+			"export declare interface Embeds extends Part {",
+			"export declare type Phase = 'A' | 'B';",
+			"export declare type CustomResourceDefinition<Metadata, Spec, Status> = {",
+		}, []string{"export interface", "export type", "export const"}},
+	}
+	pkgs := testPackages(t, "foo/v1")
+	for _, tt := range tests {
+		c := testConfig()
+		c.AmbientDeclarations = tt.ambient
+		t.Run(fmt.Sprintf("ambientDeclarations=%v", tt.ambient), func(t *testing.T) {
+			assertContains(t, renderTemplate(t, "packages", pkgs, c), tt.want, tt.unwanted)
+		})
+	}
+
+	for _, style := range []string{"", enumStyleUnion, enumStyleAsConst} {
+		c := testConfig()
+		c.AmbientDeclarations = true
+		c.EnumStyle = style
+		err := c.validate()
+		if style != enumStyleAsConst {
+			if err != nil {
+				t.Errorf("enumStyle=%q: validate() = %v", style, err)
+			}
+		} else if err == nil || !strings.Contains(err.Error(), "ambientDeclarations cannot be combined") {
+			t.Errorf("enumStyle=%q: validate() = %v, want an ambientDeclarations error", style, err)
+		}
+	}
+}
+
+func TestValidateTemplates(t *testing.T) {
+	tests := []struct {
+		name      string
+		templates map[string]string
+		config    generatorConfig
+		wantErr   string
+	}{
+		{name: "default"},
+		{name: "default with options", config: generatorConfig{EnumStyle: enumStyleAsConst, EmitKindRegistry: true, DeclarationKind: declarationType}},
+		{
+			name:      "extra template",
+			templates: map[string]string{"extra.tpl": `{{ define "extra" }}{{ .Nope }}{{ end }}`},
+		},
+		{
+			name:      "parse error",
+			templates: map[string]string{"members.tpl": "{{ define \"members\" }}\n{{ if }}{{ end }}"},
+			wantErr:   "members.tpl:2",
+		},
+		{
+			name:      "unknown function",
+			templates: map[string]string{"members.tpl": `{{ define "members" }}{{ noSuchFunc . }}{{ end }}`},
+			wantErr:   `function "noSuchFunc" not defined`,
+		},
+		{
+			name:      "execution error",
+			templates: map[string]string{"members.tpl": `{{ define "members" }}{{ .Nope }}{{ end }}`},
+			wantErr:   "can't evaluate field Nope",
+		},
+		{
+			name:      "no packages template",
+			templates: map[string]string{"pkg.tpl": `{{ define "package" }}{{ end }}`},
+			wantErr:   `no "packages" template defined`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useTemplateDir(t, tt.templates)
+			resetRun()
+			err := validateTemplates(validConfig(t, tt.config))
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("validateTemplates() = %v", err)
+				}
+			} else if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("validateTemplates() = %v, want an error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
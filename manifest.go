@@ -0,0 +1,48 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+)
+
+// manifest is the record of a run written by -manifest, describing what was
+// generated and from which inputs. There is no -out-dir mode, so it always
+// describes the single -out-file output.
+type manifest struct {
+	Version    string   `json:"version"`
+	ConfigHash string   `json:"configHash"`
+	APIDir     string   `json:"apiDir"`
+	Packages   []string `json:"packages"`
+	TypeCount  int      `json:"typeCount"`
+	Types      []string `json:"types"`
+}
+
+// buildManifest describes the generation of the visible types of pkgs with
+// the given raw config file contents.
+func buildManifest(pkgs []*apiPackage, c generatorConfig, rawConfig []byte) manifest {
+	sum := sha256.Sum256(rawConfig)
+	m := manifest{
+		Version:    version,
+		ConfigHash: hex.EncodeToString(sum[:]),
+		APIDir:     *flAPIDir,
+		Packages:   []string{},
+		Types:      []string{},
+	}
+	for _, pkg := range pkgs {
+		m.Packages = append(m.Packages, pkg.identifier())
+		for _, t := range visibleTypes(sortTypes(pkg.Types, c), c) {
+			m.Types = append(m.Types, pkg.identifier()+"."+t.Name.Name)
+		}
+	}
+	m.TypeCount = len(m.Types)
+	return m
+}
+
+// writeManifest writes the JSON serialization of m to w.
+func writeManifest(w io.Writer, m manifest) error {
+	e := json.NewEncoder(w)
+	e.SetIndent("", "  ")
+	return e.Encode(m)
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"k8s.io/gengo/types"
+)
+
+func TestBuildManifest(t *testing.T) {
+	apiDir := *flAPIDir
+	defer func() { *flAPIDir = apiDir }()
+	*flAPIDir = "./apis"
+
+	pkgs := []*apiPackage{
+		{apiGroup: "bar.example.com", apiVersion: "v1", Types: []*types.Type{testType("Gadget")}},
+		{apiGroup: "foo.example.com", apiVersion: "v1", Types: []*types.Type{testType("WidgetList"), testType("Widget"), testType("widget")}},
+	}
+	c := validConfig(t, generatorConfig{HideTypePatterns: []string{"List$"}})
+	var b bytes.Buffer
+	if err := writeManifest(&b, buildManifest(pkgs, c, []byte(`{"hideTypePatterns":["List$"]}`))); err != nil {
+		t.Fatal(err)
+	}
+	var got manifest
+	if err := json.Unmarshal(b.Bytes(), &got); err != nil {
+		t.Fatalf("invalid JSON: %v\n%s", err, b.String())
+	}
+	want := manifest{
+		Version:    version,
+		ConfigHash: "1fac4d36469a5641f8b8988fb0a62bfd6f042e0ed12f4e5ab0b4ebbb4fff7acc",
+		APIDir:     "./apis",
+		Packages:   []string{"bar.example.com/v1", "foo.example.com/v1"},
+		TypeCount:  2,
+		Types:      []string{"bar.example.com/v1.Gadget", "foo.example.com/v1.Widget"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("manifest =\n%+v\nwant\n%+v", got, want)
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+)
+
+// modelPackage is the serializable view of an apiPackage written by
+// -dump-model, for tools that want to render the API on their own.
+type modelPackage struct {
+	Group     string          `json:"group"`
+	Version   string          `json:"version"`
+	Types     []modelType     `json:"types"`
+	Constants []modelConstant `json:"constants,omitempty"`
+}
+
+type modelType struct {
+	Name         string        `json:"name"`
+	Kind         string        `json:"kind"`
+	Root         bool          `json:"root"`
+	Comments     []string      `json:"comments,omitempty"`
+	Members      []modelMember `json:"members,omitempty"`
+	Values       []string      `json:"values,omitempty"`
+	ReferencedBy []string      `json:"referencedBy,omitempty"`
+}
+
+type modelMember struct {
+	Name     string   `json:"name"`
+	Type     string   `json:"type"`
+	Optional bool     `json:"optional"`
+	Embedded bool     `json:"embedded"`
+	Comments []string `json:"comments,omitempty"`
+}
+
+type modelConstant struct {
+	Name  string `json:"name"`
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+// buildModel computes the serializable view of the visible types in pkgs.
+func buildModel(pkgs []*apiPackage, c generatorConfig) []modelPackage {
+	references := findTypeReferences(pkgs)
+	constants := indexConstants(pkgs)
+	typePkgMap := extractTypeToPackageMap(pkgs)
+
+	out := make([]modelPackage, 0, len(pkgs))
+	for _, pkg := range pkgs {
+		mp := modelPackage{Group: pkg.apiGroup, Version: pkg.apiVersion, Types: []modelType{}}
+		for _, t := range visibleTypes(sortTypes(pkg.Types, c), c) {
+			mt := modelType{
+				Name:     t.Name.Name,
+				Kind:     string(t.Kind),
+				Root:     isExportedType(t, c),
+				Comments: modelComments(t.CommentLines),
+			}
+			for _, m := range t.Members {
+				if hiddenMemberOf(t, m, c) {
+					continue
+				}
+				mt.Members = append(mt.Members, modelMember{
+					Name:     fieldName(m, c),
+					Type:     typeDisplayName(m.Type, c, typePkgMap),
+					Optional: isOptionalMember(m, c),
+					Embedded: fieldEmbedded(m),
+					Comments: modelComments(memberDocs(m)),
+				})
+			}
+			for _, v := range constantsOfType(t, constants, c) {
+				mt.Values = append(mt.Values, *v.ConstValue)
+			}
+			for _, ref := range typeReferences(t, c, references) {
+				mt.ReferencedBy = append(mt.ReferencedBy, ref.Name.Name)
+			}
+			mp.Types = append(mp.Types, mt)
+		}
+		for _, v := range sortTypes(pkg.Constants, c) {
+			if v.ConstValue == nil || hideConstant(v, c) {
+				continue
+			}
+			mp.Constants = append(mp.Constants, modelConstant{
+				Name:  v.Name.Name,
+				Type:  v.Underlying.Name.Name,
+				Value: *v.ConstValue,
+			})
+		}
+		out = append(out, mp)
+	}
+	return out
+}
+
+// modelComments returns the comment lines without marker comments, or nil
+// if there are none left.
+func modelComments(lines []string) []string {
+	if !hasComments(lines) {
+		return nil
+	}
+	return filterCommentTags(lines)
+}
+
+// dumpModel writes the JSON serialization of the model of pkgs to w.
+func dumpModel(w io.Writer, pkgs []*apiPackage, c generatorConfig) error {
+	e := json.NewEncoder(w)
+	e.SetIndent("", "  ")
+	return e.Encode(buildModel(pkgs, c))
+}
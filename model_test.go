@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestBuildModel(t *testing.T) {
+	pkgs := testPackages(t, "foo/v1")
+	c := validConfig(t, testConfig())
+	var b bytes.Buffer
+	if err := dumpModel(&b, pkgs, c); err != nil {
+		t.Fatal(err)
+	}
+	var model []modelPackage
+	if err := json.Unmarshal(b.Bytes(), &model); err != nil {
+		t.Fatalf("invalid JSON: %v\n%s", err, b.String())
+	}
+	if len(model) != 1 || model[0].Group != "foo.example.com" || model[0].Version != "v1" {
+		t.Fatalf("unexpected packages: %+v", model)
+	}
+	typs := make(map[string]modelType)
+	for _, v := range model[0].Types {
+		typs[v.Name] = v
+	}
+
+	tests := []struct {
+		name string
+		want modelType
+	}{
+		{"Widget", modelType{
+			Name:     "Widget",
+			Kind:     "Struct",
+			Root:     true,
+			Comments: []string{"Widget is a widget."},
+			Members: []modelMember{
+				{Name: "metadata", Type: "ObjectMetadata", Optional: true},
+				{Name: "spec", Type: "WidgetSpec", Optional: true},
+				{Name: "status", Type: "WidgetStatus", Optional: true},
+			},
+		}},
+		{"Phase", modelType{
+			Name:         "Phase",
+			Kind:         "Alias",
+			Comments:     []string{"Phase is the phase."},
+			Values:       []string{"A", "B"},
+			ReferencedBy: []string{"WidgetSpec"},
+		}},
+		{"Embeds", modelType{
+			Name: "Embeds",
+			Kind: "Struct",
+			Members: []modelMember{
+				{Name: "Part", Type: "Part", Embedded: true},
+				{Name: "status", Type: "WidgetStatus"},
+				{Name: "name", Type: "string"},
+			},
+		}},
+	}
+	for _, tt := range tests {
+		if got := typs[tt.name]; !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("model of %s =\n%+v\nwant\n%+v", tt.name, got, tt.want)
+		}
+	}
+	if _, ok := typs["WidgetList"]; ok {
+		t.Errorf("the hidden WidgetList is in the model")
+	}
+
+	var phaseA *modelConstant
+	for i, v := range model[0].Constants {
+		if v.Name == "PhaseA" {
+			phaseA = &model[0].Constants[i]
+		}
+	}
+	if want := (modelConstant{Name: "PhaseA", Type: "Phase", Value: "A"}); phaseA == nil || *phaseA != want {
+		t.Errorf("constant PhaseA = %+v, want %+v", phaseA, want)
+	}
+}
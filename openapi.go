@@ -0,0 +1,239 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"k8s.io/gengo/types"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// openAPISchema is an OpenAPI v3 schema object. It is a map so the
+// x-kubernetes-* vendor extensions can sit next to the standard keywords.
+type openAPISchema map[string]interface{}
+
+// openAPIMarkers maps the markers of a field to the vendor extension they set
+// in its schema, along with whether the extension holds a list of values.
+var openAPIMarkers = []struct {
+	marker, extension string
+	list              bool
+}{
+	{"listType", "x-kubernetes-list-type", false},
+	{"listMapKey", "x-kubernetes-list-map-keys", true},
+	{"mapType", "x-kubernetes-map-type", false},
+	{"structType", "x-kubernetes-map-type", false},
+	{"kubebuilder:pruning:PreserveUnknownFields", "x-kubernetes-preserve-unknown-fields", false},
+	{"kubebuilder:validation:EmbeddedResource", "x-kubernetes-embedded-resource", false},
+	{"kubebuilder:validation:XIntOrString", "x-kubernetes-int-or-string", false},
+}
+
+// buildOpenAPI computes the OpenAPI v3 component schemas of the visible types
+// in pkgs, keyed by their emitted name.
+func buildOpenAPI(pkgs []*apiPackage, c generatorConfig) map[string]openAPISchema {
+	typePkgMap := extractTypeToPackageMap(pkgs)
+	constants := indexConstants(pkgs)
+	out := make(map[string]openAPISchema)
+	for _, pkg := range pkgs {
+		for _, t := range visibleTypes(sortTypes(pkg.Types, c), c) {
+			out[localTypeName(t, c, pkg)] = openAPITypeSchema(t, constants, c, typePkgMap)
+		}
+	}
+	return out
+}
+
+// openAPITypeSchema returns the schema declaring the type t.
+func openAPITypeSchema(t *types.Type, constants map[*types.Type][]*types.Type, c generatorConfig, typePkgMap map[*types.Type]*apiPackage) openAPISchema {
+	var s openAPISchema
+	switch t.Kind {
+	case types.Struct:
+		s = openAPISchema{"type": "object"}
+		props := make(map[string]openAPISchema)
+		var required, allOf []interface{}
+		for _, m := range t.Members {
+			if hiddenMemberOf(t, m, c) {
+				continue
+			}
+			if fieldEmbedded(m) {
+				allOf = append(allOf, openAPIRef(m.Type, c, typePkgMap))
+				continue
+			}
+			name := fieldName(m, c)
+			props[name] = openAPIMemberSchema(m, c, typePkgMap)
+			if !isOptionalMember(m, c) {
+				required = append(required, name)
+			}
+		}
+		if len(props) > 0 {
+			s["properties"] = props
+		}
+		if len(required) > 0 {
+			s["required"] = required
+		}
+		if len(allOf) > 0 {
+			s["allOf"] = allOf
+		}
+	case types.Alias:
+		s = openAPIRef(t.Underlying, c, typePkgMap)
+		var enum []interface{}
+		for _, v := range constantsOfType(t, constants, c) {
+			if v.ConstValue != nil {
+				enum = append(enum, openAPIEnumValue(v))
+			}
+		}
+		if len(enum) > 0 {
+			s = openAPIWrapRef(s)
+			s["enum"] = enum
+		}
+	default:
+		s = openAPISchema{"type": "object"}
+	}
+	if lines := modelComments(t.CommentLines); lines != nil {
+		s = openAPIWrapRef(s)
+		s["description"] = strings.TrimSpace(strings.Join(lines, "\n"))
+	}
+	return s
+}
+
+// openAPIWrapRef wraps the $ref schema s into an allOf, so that keywords can
+// be added next to it: siblings of $ref are ignored in OpenAPI v3. Other
+// schemas are returned as is.
+func openAPIWrapRef(s openAPISchema) openAPISchema {
+	if _, ok := s["$ref"]; ok {
+		return openAPISchema{"allOf": []interface{}{s}}
+	}
+	return s
+}
+
+// jsonNumber matches the values that are valid JSON numbers.
+var jsonNumber = regexp.MustCompile(`^-?(0|[1-9][0-9]*)(\.[0-9]+)?([eE][+-]?[0-9]+)?$`)
+
+// openAPIEnumValue returns the JSON value of the constant v: a string for
+// string constants, a number or a boolean for the constants that parse as
+// such, and else its value quoted as a string.
+func openAPIEnumValue(v *types.Type) interface{} {
+	s := *v.ConstValue
+	if u := finalUnderlyingTypeOf(v); u.Kind == types.Builtin && u.Name.Name == "string" {
+		return s
+	}
+	if jsonNumber.MatchString(s) {
+		return json.Number(s)
+	}
+	if b, err := strconv.ParseBool(s); err == nil {
+		return b
+	}
+	return s
+}
+
+// openAPIMemberSchema returns the schema of the field m, with its description,
+// format and vendor extensions.
+func openAPIMemberSchema(m types.Member, c generatorConfig, typePkgMap map[*types.Type]*apiPackage) openAPISchema {
+	extra := make(openAPISchema)
+	if lines := modelComments(memberDocs(m)); lines != nil {
+		extra["description"] = strings.TrimSpace(strings.Join(lines, "\n"))
+	}
+	if f := memberFormat(m); f != "" {
+		extra["format"] = f
+	}
+	tags := types.ExtractCommentTags("+", m.CommentLines)
+	for _, v := range openAPIMarkers {
+		values, ok := tags[v.marker]
+		if !ok {
+			continue
+		}
+		switch {
+		case v.list:
+			var l []string
+			for _, s := range values {
+				l = append(l, strings.Split(s, ",")...)
+			}
+			extra[v.extension] = l
+		case values[0] == "" || values[0] == "true":
+			extra[v.extension] = true
+		default:
+			extra[v.extension] = values[0]
+		}
+	}
+
+	s := openAPIRef(m.Type, c, typePkgMap)
+	if extra["x-kubernetes-int-or-string"] == true {
+		// the marker overrides the type of the field, usually a string.
+		s = openAPIIntOrString()
+	} else if len(extra) > 0 {
+		s = openAPIWrapRef(s)
+	}
+	for k, v := range extra {
+		s[k] = v
+	}
+	return s
+}
+
+// openAPIIntOrString returns the schema of the values that are either integers
+// or strings, declared as Kubernetes does: structural schemas allow no type
+// next to x-kubernetes-int-or-string.
+func openAPIIntOrString() openAPISchema {
+	return openAPISchema{
+		"anyOf":                      []interface{}{openAPISchema{"type": "integer"}, openAPISchema{"type": "string"}},
+		"x-kubernetes-int-or-string": true,
+	}
+}
+
+// openAPIRef returns the schema referring to the type t: a $ref for the types
+// of the API packages, or an inline schema otherwise.
+func openAPIRef(t *types.Type, c generatorConfig, typePkgMap map[*types.Type]*apiPackage) openAPISchema {
+	for t.Kind == types.Pointer {
+		t = t.Elem
+	}
+	if pkg, ok := typePkgMap[t]; ok {
+		return openAPISchema{"$ref": "#/components/schemas/" + localTypeName(t, c, pkg)}
+	}
+	// the builtins of the types built from CRDs are named after TypeScript.
+	switch t {
+	case crdNumber:
+		return openAPISchema{"type": "number"}
+	case crdBoolean:
+		return openAPISchema{"type": "boolean"}
+	case crdIntOrString:
+		return openAPIIntOrString()
+	case crdUnknown:
+		return openAPISchema{}
+	}
+	switch t.Kind {
+	case types.Slice:
+		if t.Elem.Kind == types.Builtin && t.Elem.Name.Name == "byte" {
+			return openAPISchema{"type": "string", "format": "byte"}
+		}
+		return openAPISchema{"type": "array", "items": openAPIRef(t.Elem, c, typePkgMap)}
+	case types.Map:
+		return openAPISchema{"type": "object", "additionalProperties": openAPIRef(t.Elem, c, typePkgMap)}
+	case types.Alias:
+		return openAPIRef(t.Underlying, c, typePkgMap)
+	case types.Builtin:
+		switch t.Name.Name {
+		case "string":
+			return openAPISchema{"type": "string"}
+		case "bool":
+			return openAPISchema{"type": "boolean"}
+		case "int32", "int64":
+			return openAPISchema{"type": "integer", "format": t.Name.Name}
+		case "float32", "float64":
+			return openAPISchema{"type": "number"}
+		}
+		if strings.HasPrefix(t.Name.Name, "int") || strings.HasPrefix(t.Name.Name, "uint") {
+			return openAPISchema{"type": "integer"}
+		}
+	}
+	return openAPISchema{"type": "object"}
+}
+
+// writeOpenAPI writes the OpenAPI v3 document holding the component schemas
+// of pkgs to w.
+func writeOpenAPI(w io.Writer, pkgs []*apiPackage, c generatorConfig) error {
+	e := json.NewEncoder(w)
+	e.SetIndent("", "  ")
+	return e.Encode(map[string]interface{}{
+		"components": map[string]interface{}{
+			"schemas": buildOpenAPI(pkgs, c),
+		},
+	})
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"k8s.io/gengo/types"
+)
+
+func TestOpenAPIEnumValue(t *testing.T) {
+	str, num := types.String, types.Int
+	tests := []struct {
+		underlying *types.Type
+		value      string
+		want       interface{}
+	}{
+		{str, "Running", "Running"},
+		{str, "1", "1"},
+		{str, "true", "true"},
+		{num, "1", json.Number("1")},
+		{num, "-2.5e3", json.Number("-2.5e3")},
+		{num, "true", true},
+		{num, "0x10", "0x10"},
+	}
+	for _, tt := range tests {
+		v := testConstant("Value", tt.value, tt.underlying)
+		if got := openAPIEnumValue(v); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("openAPIEnumValue(%s %s) = %#v, want %#v", tt.underlying, tt.value, got, tt.want)
+		}
+	}
+}
+
+// intOrString is the schema of the int-or-string values.
+const intOrString = `{"anyOf":[{"type":"integer"},{"type":"string"}],"x-kubernetes-int-or-string":true}`
+
+func TestOpenAPIRef(t *testing.T) {
+	pkgs := testPackages(t, "foo/v1")
+	c := validConfig(t, testConfig())
+	typePkgMap := extractTypeToPackageMap(pkgs)
+	part := findType(t, pkgs, "Part")
+	tests := []struct {
+		typ  *types.Type
+		want string
+	}{
+		{part, `{"$ref":"#/components/schemas/Part"}`},
+		{&types.Type{Kind: types.Pointer, Elem: part}, `{"$ref":"#/components/schemas/Part"}`},
+		{&types.Type{Kind: types.Slice, Elem: part}, `{"items":{"$ref":"#/components/schemas/Part"},"type":"array"}`},
+		{&types.Type{Kind: types.Slice, Elem: types.Byte}, `{"format":"byte","type":"string"}`},
+		{&types.Type{Kind: types.Map, Key: types.String, Elem: types.Bool}, `{"additionalProperties":{"type":"boolean"},"type":"object"}`},
+		{types.Int32, `{"format":"int32","type":"integer"}`},
+		{types.Uint16, `{"type":"integer"}`},
+		{types.Float64, `{"type":"number"}`},
+		{crdNumber, `{"type":"number"}`},
+		{crdBoolean, `{"type":"boolean"}`},
+		{crdIntOrString, intOrString},
+		{crdUnknown, `{}`},
+	}
+	for _, tt := range tests {
+		b, err := json.Marshal(openAPIRef(tt.typ, c, typePkgMap))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != tt.want {
+			t.Errorf("openAPIRef(%s) = %s, want %s", tt.typ, b, tt.want)
+		}
+	}
+}
+
+func TestBuildOpenAPI(t *testing.T) {
+	pkgs := testPackages(t, "foo/v1")
+	c := validConfig(t, testConfig())
+	schemas := buildOpenAPI(pkgs, c)
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"Phase", `{"description":"Phase is the phase.","enum":["A","B"],"type":"string"}`},
+		{"Part", `{"properties":{"name":{"type":"string"}},"required":["name"],"type":"object"}`},
+		{"Embeds", `{"allOf":[{"$ref":"#/components/schemas/Part"}],` +
+			`"properties":{"name":{"type":"string"},"status":{"$ref":"#/components/schemas/WidgetStatus"}},` +
+			`"required":["status","name"],"type":"object"}`},
+		// the int-or-string marker replaces the string type of the field.
+		{"OneOfs", `{"properties":{"any":{"type":"string"},"port":` + intOrString + `,"ren":{"type":"string"}},` +
+			`"required":["port","any","ren"],"type":"object"}`},
+	}
+	for _, tt := range tests {
+		b, err := json.Marshal(schemas[tt.name])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != tt.want {
+			t.Errorf("schema of %s = %s, want %s", tt.name, b, tt.want)
+		}
+	}
+	if _, ok := schemas["WidgetList"]; ok {
+		t.Errorf("hidden type WidgetList has a schema")
+	}
+
+	// the keywords next to a $ref are ignored, so they go along an allOf.
+	alias := &types.Type{
+		Name:         types.Name{Package: "example.com/fx/apis/foo/v1", Name: "Stage"},
+		Kind:         types.Alias,
+		Underlying:   findType(t, pkgs, "Phase"),
+		CommentLines: []string{"Stage is the phase."},
+	}
+	b, err := json.Marshal(openAPITypeSchema(alias, indexConstants(pkgs), c, extractTypeToPackageMap(pkgs)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"allOf":[{"$ref":"#/components/schemas/Phase"}],"description":"Stage is the phase."}`; string(b) != want {
+		t.Errorf("schema of Stage = %s, want %s", b, want)
+	}
+}
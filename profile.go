@@ -0,0 +1,78 @@
+package main
+
+import (
+	"github.com/pkg/errors"
+	"os"
+	"os/signal"
+	"runtime"
+	"runtime/pprof"
+	"sync"
+	"syscall"
+)
+
+// startProfiles starts writing a CPU profile to cpuFile, if set, and returns
+// the function stopping it and writing a heap profile to memFile, if set. As
+// the HTTP server only stops on a signal, the profiles are also written on
+// SIGINT and SIGTERM before exiting.
+func startProfiles(cpuFile, memFile string) (func(), error) {
+	if cpuFile == "" && memFile == "" {
+		return func() {}, nil
+	}
+	var cpu *os.File
+	if cpuFile != "" {
+		f, err := os.Create(cpuFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to create the CPU profile")
+		}
+		if err := pprof.StartCPUProfile(f); err != nil {
+			f.Close()
+			return nil, errors.Wrap(err, "failed to start the CPU profile")
+		}
+		cpu = f
+	}
+
+	var once sync.Once
+	stop := func() {
+		once.Do(func() {
+			if cpu != nil {
+				pprof.StopCPUProfile()
+				if err := cpu.Close(); err != nil {
+					log.Errorf("failed to write the CPU profile: %v", err)
+				} else {
+					log.Infof("CPU profile written to %s", cpuFile)
+				}
+			}
+			if memFile != "" {
+				if err := writeHeapProfile(memFile); err != nil {
+					log.Errorf("failed to write the memory profile: %v", err)
+				} else {
+					log.Infof("memory profile written to %s", memFile)
+				}
+			}
+		})
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		stop()
+		os.Exit(1)
+	}()
+	return stop, nil
+}
+
+// writeHeapProfile writes the profile of the live heap to path.
+func writeHeapProfile(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	// only count the objects still in use.
+	runtime.GC()
+	if err := pprof.WriteHeapProfile(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
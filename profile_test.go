@@ -0,0 +1,51 @@
+package main
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestStartProfiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "profiles")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cpu, mem := filepath.Join(dir, "cpu.pprof"), filepath.Join(dir, "mem.pprof")
+
+	stop, err := startProfiles(cpu, mem)
+	if err != nil {
+		t.Fatal(err)
+	}
+	renderTemplate(t, "packages", testPackages(t, ""), testConfig())
+	stop()
+	// stopping again leaves the profiles as they are.
+	stop()
+
+	for _, path := range []string{cpu, mem} {
+		f, err := os.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// profiles are gzipped protocol buffers.
+		r, err := gzip.NewReader(f)
+		if err != nil {
+			t.Errorf("%s is not a profile: %v", filepath.Base(path), err)
+		} else if b, err := ioutil.ReadAll(r); err != nil || len(b) == 0 {
+			t.Errorf("%s is not a profile: %d bytes, %v", filepath.Base(path), len(b), err)
+		}
+		f.Close()
+	}
+
+	if _, err := startProfiles(filepath.Join(dir, "missing", "cpu.pprof"), ""); err == nil {
+		t.Error("startProfiles() with a CPU profile in a missing directory did not fail")
+	}
+	if stop, err := startProfiles("", ""); err != nil {
+		t.Errorf("startProfiles() without profiles = %v", err)
+	} else {
+		stop()
+	}
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+)
+
+// Categories of the errors collected in a runReport: the output is written,
+// but it refers to types it does not declare. Dangling references are only
+// errors with -strict-references.
+const (
+	errUnresolvedType = "unresolved type"
+	errDanglingRef    = "dangling reference"
+)
+
+// Categories of the warnings collected in a runReport.
+const (
+	warnDanglingRef    = "dangling reference"
+	warnCrossPackage   = "cross-package reference"
+	warnVendorSkip     = "vendor package skipped"
+	warnDocsURL        = "external docs URL"
+	warnUnion          = "union without implementations"
+	warnFormat         = "unknown format"
+	warnSource         = "missing sources"
+	warnUnusedConfig   = "unused config entry"
+	warnUnmappedType   = "unmapped type"
+	warnNameCollision  = "type name collision"
+	warnAnchorID       = "duplicate anchor ID"
+	warnReferenceDepth = "reference depth limit"
+	warnKindRegistry   = "duplicate kind"
+	warnUnserializable = "unserializable field"
+	warnVersionSkip    = "package without apiVersion skipped"
+)
+
+// reportExamples is the number of messages shown for each category in the
+// summary.
+const reportExamples = 3
+
+// runReport collects the errors and warnings of a run by category, so they
+// can be summarized once the output is generated instead of scrolling past in
+// the logs, along with the config entries and the types the run used.
+type runReport struct {
+	errors   map[string][]string
+	warnings map[string][]string
+
+	// usedConfigEntries records the TypeReplacements, ExternalTypes,
+	// SyntheticTypes and HideTypePatterns entries that matched, keyed by
+	// configEntry.
+	usedConfigEntries map[string]bool
+	// unmappedTypes collects the types rendered as is, see addUnmappedType.
+	unmappedTypes map[string]struct{}
+	// unresolvedTypes collects the types that could not be mapped to an
+	// apiPackage while rendering.
+	unresolvedTypes map[string]struct{}
+}
+
+func newRunReport() *runReport {
+	return &runReport{
+		errors:            make(map[string][]string),
+		warnings:          make(map[string][]string),
+		usedConfigEntries: make(map[string]bool),
+		unmappedTypes:     make(map[string]struct{}),
+		unresolvedTypes:   make(map[string]struct{}),
+	}
+}
+
+// report is the runReport of the command line run. The renders of the HTTP
+// server each have their own, see generatorConfig.currentReport.
+var report = newRunReport()
+
+// errorf logs an error of the given category and records it in r, which makes
+// the run fail once its output is written.
+func (r *runReport) errorf(category, format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	r.errors[category] = append(r.errors[category], msg)
+	log.Errorf("%s", msg)
+}
+
+// warnf logs a warning of the given category and records it in r.
+func (r *runReport) warnf(category, format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	r.warnings[category] = append(r.warnings[category], msg)
+	log.Warningf("%s", msg)
+}
+
+// errorf records an error in the report of the command line run, for the
+// steps preceding the renders.
+func errorf(category, format string, args ...interface{}) {
+	report.errorf(category, format, args...)
+}
+
+// warnf records a warning in the report of the command line run, for the
+// steps preceding the renders.
+func warnf(category, format string, args ...interface{}) {
+	report.warnf(category, format, args...)
+}
+
+// count returns the number of messages recorded in entries.
+func count(entries map[string][]string) int {
+	n := 0
+	for _, v := range entries {
+		n += len(v)
+	}
+	return n
+}
+
+// print writes the number of errors and warnings by category and the first
+// few of each to w, highlighting the categories when color is set.
+func (r *runReport) print(w io.Writer, color bool) {
+	printEntries(w, "error(s)", r.errors, color, "31")
+	printEntries(w, "warning(s)", r.warnings, color, "33")
+}
+
+// printEntries writes the summary of the messages in entries, titled with
+// their number and what, and their categories in the ANSI color code when
+// color is set.
+func printEntries(w io.Writer, what string, entries map[string][]string, color bool, code string) {
+	if count(entries) == 0 {
+		return
+	}
+	var categories []string
+	for k := range entries {
+		categories = append(categories, k)
+	}
+	sort.Strings(categories)
+
+	fmt.Fprintf(w, "%d %s:\n", count(entries), what)
+	for _, c := range categories {
+		msgs := entries[c]
+		if color {
+			fmt.Fprintf(w, "  \x1b[%sm%s\x1b[0m: %d\n", code, c, len(msgs))
+		} else {
+			fmt.Fprintf(w, "  %s: %d\n", c, len(msgs))
+		}
+		for i, m := range msgs {
+			if i == reportExamples {
+				fmt.Fprintf(w, "    ... and %d more\n", len(msgs)-reportExamples)
+				break
+			}
+			fmt.Fprintf(w, "    %s\n", m)
+		}
+	}
+}
+
+// configEntry identifies the entry key of the config setting, e.g.
+// typeReplacements["Time"].
+func configEntry(setting string, keys ...string) string {
+	s := setting
+	for _, k := range keys {
+		s += fmt.Sprintf("[%q]", k)
+	}
+	return s
+}
+
+// markConfigUsed records that the entry of the setting matched.
+func (r *runReport) markConfigUsed(setting string, keys ...string) {
+	r.usedConfigEntries[configEntry(setting, keys...)] = true
+}
+
+// unusedConfigEntries returns the TypeReplacements, ExternalTypes,
+// SyntheticTypes and HideTypePatterns entries of c that never matched, sorted.
+func unusedConfigEntries(c generatorConfig) []string {
+	var out []string
+	add := func(setting string, keys ...string) {
+		if e := configEntry(setting, keys...); !c.currentReport().usedConfigEntries[e] {
+			out = append(out, e)
+		}
+	}
+	for k := range c.TypeReplacements {
+		add("typeReplacements", k)
+	}
+	for pkg, names := range c.ExternalTypes {
+		for k := range names {
+			add("externalTypes", pkg, k)
+		}
+	}
+	for k := range c.SyntheticTypes {
+		add("syntheticTypes", k)
+	}
+	for _, v := range c.HideTypePatterns {
+		add("hideTypePatterns", v)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// isTerminal reports whether f is a character device, i.e. most likely an
+// interactive terminal that understands colors.
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestRunReportPrint(t *testing.T) {
+	tests := []struct {
+		name   string
+		record func()
+		color  bool
+		want   string
+	}{
+		{"empty", func() {}, false, ""},
+		{"errors and warnings", func() {
+			warnf(warnUnion, "Shape has no implementations")
+			errorf(errDanglingRef, "Holder refers to helperThing")
+			warnf(warnDocsURL, "no docs URL for Time")
+		}, false, "1 error(s):\n" +
+			"  dangling reference: 1\n" +
+			"    Holder refers to helperThing\n" +
+			"2 warning(s):\n" +
+			"  external docs URL: 1\n" +
+			"    no docs URL for Time\n" +
+			"  union without implementations: 1\n" +
+			"    Shape has no implementations\n"},
+		{"color", func() {
+			errorf(errUnresolvedType, "Thing")
+		}, true, "1 error(s):\n" +
+			"  \x1b[31munresolved type\x1b[0m: 1\n" +
+			"    Thing\n"},
+		{"examples", func() {
+			for _, s := range []string{"a", "b", "c", "d", "e"} {
+				warnf(warnFormat, "%s", s)
+			}
+		}, false, "5 warning(s):\n" +
+			"  unknown format: 5\n" +
+			"    a\n    b\n    c\n" +
+			"    ... and 2 more\n"},
+	}
+	for _, tt := range tests {
+		resetRun()
+		tt.record()
+		var buf bytes.Buffer
+		report.print(&buf, tt.color)
+		if got := buf.String(); got != tt.want {
+			t.Errorf("%s: print() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestUnusedConfigEntries(t *testing.T) {
+	c := testConfig()
+	c.TypeReplacements["Nothing"] = "never"
+	c.HideTypePatterns = append(c.HideTypePatterns, "^Nope$")
+	c.SyntheticTypes = map[string]syntheticType{"example.com/opaque/v1.Thing": {Type: "unknown"}}
+	renderTemplate(t, "packages", testPackages(t, "foo/v1"), c)
+	want := []string{
+		`externalTypes["k8s.io/apimachinery/pkg/apis/meta/v1"]["Time"]`,
+		`hideTypePatterns["^Nope$"]`,
+		`syntheticTypes["example.com/opaque/v1.Thing"]`,
+		`typeReplacements["Nothing"]`,
+		`typeReplacements["int"]`,
+	}
+	if got := unusedConfigEntries(c); !reflect.DeepEqual(got, want) {
+		t.Errorf("unusedConfigEntries() = %q, want %q", got, want)
+	}
+
+	// the entries used by a previous run do not count.
+	resetRun()
+	if got := unusedConfigEntries(c); len(got) != 9 {
+		t.Errorf("unusedConfigEntries() before rendering = %q, want all 9 entries", got)
+	}
+}
+
+func TestConfigEntry(t *testing.T) {
+	tests := []struct {
+		setting string
+		keys    []string
+		want    string
+	}{
+		{"typeReplacements", []string{"Time"}, `typeReplacements["Time"]`},
+		{"externalTypes", []string{"k8s.io/api/core/v1", "Pod"}, `externalTypes["k8s.io/api/core/v1"]["Pod"]`},
+		{"hideTypePatterns", []string{`List$`}, `hideTypePatterns["List$"]`},
+	}
+	for _, tt := range tests {
+		if got := configEntry(tt.setting, tt.keys...); got != tt.want {
+			t.Errorf("configEntry(%s, %q) = %s, want %s", tt.setting, tt.keys, got, tt.want)
+		}
+	}
+}
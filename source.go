@@ -0,0 +1,365 @@
+package main
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"go/ast"
+	"go/build"
+	"go/parser"
+	"go/token"
+	"k8s.io/gengo/types"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// findTypeSources locates the declaration of each type and constant in pkgs,
+// since gengo does not record the position of the types it parses.
+func findTypeSources(pkgs []*apiPackage) map[*types.Type]token.Position {
+	out := make(map[*types.Type]token.Position)
+	for _, ap := range pkgs {
+		for _, pkg := range ap.GoPackages {
+			positions, err := declarationPositions(pkg.SourcePath)
+			if err != nil {
+				warnf(warnSource, "cannot locate the sources of package %s: %v", pkg.Path, err)
+				continue
+			}
+			for name, t := range pkg.Types {
+				if pos, ok := positions[name]; ok {
+					out[t] = pos
+				}
+			}
+			for name, t := range pkg.Constants {
+				if pos, ok := positions[name]; ok {
+					out[t] = pos
+				}
+			}
+		}
+	}
+	return out
+}
+
+// declarationPositions parses the Go files (except tests) in dir and returns
+// the position of each top-level type and constant declaration by name.
+func declarationPositions(dir string) (map[string]token.Position, error) {
+	fset := token.NewFileSet()
+	notTest := func(fi os.FileInfo) bool { return !strings.HasSuffix(fi.Name(), "_test.go") }
+	astPkgs, err := parser.ParseDir(fset, dir, notTest, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make(map[string]token.Position)
+	for _, p := range astPkgs {
+		for _, f := range p.Files {
+			for _, decl := range f.Decls {
+				gd, ok := decl.(*ast.GenDecl)
+				if !ok {
+					continue
+				}
+				for _, spec := range gd.Specs {
+					switch s := spec.(type) {
+					case *ast.TypeSpec:
+						out[s.Name.Name] = fset.Position(s.Name.Pos())
+					case *ast.ValueSpec:
+						for _, n := range s.Names {
+							out[n.Name] = fset.Position(n.Pos())
+						}
+					}
+				}
+			}
+		}
+	}
+	return out, nil
+}
+
+// apiFile splits the api directory into the directory of its package and the
+// Go file it names, when it is a single file rather than a directory or an
+// import path.
+func apiFile(apiDir string) (dir, file string, err error) {
+	fi, err := os.Stat(apiDir)
+	if err != nil || !fi.Mode().IsRegular() {
+		return apiDir, "", nil
+	}
+	if filepath.Ext(apiDir) != ".go" || strings.HasSuffix(apiDir, "_test.go") {
+		return "", "", errors.Errorf("%s is neither a directory nor a Go source file", apiDir)
+	}
+	dir = filepath.Dir(apiDir)
+	if !filepath.IsAbs(dir) && !strings.HasPrefix(dir, ".") {
+		// keep it a local path, not an import path.
+		dir = "." + string(filepath.Separator) + dir
+	}
+	return dir, apiDir, nil
+}
+
+// restrictToFile removes the types and constants of pkg that are not declared
+// in file.
+func restrictToFile(pkg *types.Package, file string) error {
+	positions, err := declarationPositions(pkg.SourcePath)
+	if err != nil {
+		return errors.Wrapf(err, "cannot locate the sources of package %s", pkg.Path)
+	}
+	abs, err := filepath.Abs(file)
+	if err != nil {
+		return err
+	}
+	inFile := func(name string) bool {
+		p, err := filepath.Abs(positions[name].Filename)
+		return err == nil && p == abs
+	}
+	for name := range pkg.Types {
+		if !inFile(name) {
+			delete(pkg.Types, name)
+		}
+	}
+	for name := range pkg.Constants {
+		if !inFile(name) {
+			delete(pkg.Constants, name)
+		}
+	}
+	return nil
+}
+
+// excludeFiles removes the types and constants of pkg declared in the files
+// whose name matches any of the glob patterns (e.g. "zz_generated.*.go").
+func excludeFiles(pkg *types.Package, patterns []string) error {
+	if len(patterns) == 0 {
+		return nil
+	}
+	positions, err := declarationPositions(pkg.SourcePath)
+	if err != nil {
+		return errors.Wrapf(err, "cannot locate the sources of package %s", pkg.Path)
+	}
+	excluded := func(name string) bool {
+		pos, ok := positions[name]
+		if !ok {
+			return false
+		}
+		for _, p := range patterns {
+			if ok, _ := filepath.Match(p, filepath.Base(pos.Filename)); ok {
+				return true
+			}
+		}
+		return false
+	}
+	for name := range pkg.Types {
+		if excluded(name) {
+			delete(pkg.Types, name)
+		}
+	}
+	for name := range pkg.Constants {
+		if excluded(name) {
+			delete(pkg.Constants, name)
+		}
+	}
+	return nil
+}
+
+// checkParse parses every Go file (except tests) under the api directory,
+// which is a local path or an import path, and reports the files that fail to
+// parse, since gengo only logs them and skips their packages.
+func checkParse(apiDir string) error {
+	root, file, err := apiFile(apiDir)
+	if err != nil {
+		return err
+	}
+	if file != "" {
+		root = file
+	} else {
+		bp, err := build.Import(apiDir, ".", build.FindOnly)
+		if err != nil {
+			return errors.Wrapf(err, "cannot locate %s", apiDir)
+		}
+		root = bp.Dir
+	}
+
+	fset := token.NewFileSet()
+	var failed []string
+	err = filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if path != root && (info.Name() == "vendor" || info.Name() == "testdata") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !strings.HasSuffix(path, ".go") || strings.HasSuffix(path, "_test.go") {
+			return nil
+		}
+		if _, err := parser.ParseFile(fset, path, nil, parser.DeclarationErrors); err != nil {
+			failed = append(failed, err.Error())
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if len(failed) > 0 {
+		return errors.Errorf("%d file(s) failed to parse:\n%s", len(failed), strings.Join(failed, "\n"))
+	}
+	return nil
+}
+
+// sourceLink formats pos as "<file>:<line>", with the file relative to the
+// api directory when it is a local path.
+func sourceLink(pos token.Position, apiDir string) string {
+	if dir, f, err := apiFile(apiDir); err == nil && f != "" {
+		apiDir = dir
+	}
+	file := pos.Filename
+	if base, err := filepath.Abs(apiDir); err == nil {
+		if _, err := os.Stat(base); err == nil {
+			if rel, err := filepath.Rel(base, file); err == nil {
+				file = rel
+			}
+		}
+	}
+	return fmt.Sprintf("%s:%d", filepath.ToSlash(file), pos.Line)
+}
+
+// isInvalidType determines if t is the placeholder gengo gives the types it
+// cannot resolve, e.g. those of packages that fail to load.
+func isInvalidType(t *types.Type) bool {
+	return t.Kind == types.Unsupported && t.Name.Package == "" && t.Name.Name == "invalid type"
+}
+
+// resolveInvalidMembers names the unresolved types of the fields of the
+// structs of pkg after their qualified identifier in the sources (e.g.
+// "example.com/opaque/v1.Thing"), so that SyntheticTypes can map them.
+func resolveInvalidMembers(pkg *types.Package) error {
+	var files map[string]*ast.File
+	for _, t := range pkg.Types {
+		for i, m := range t.Members {
+			if !hasInvalidType(m.Type) {
+				continue
+			}
+			if files == nil {
+				fset := token.NewFileSet()
+				notTest := func(fi os.FileInfo) bool { return !strings.HasSuffix(fi.Name(), "_test.go") }
+				astPkgs, err := parser.ParseDir(fset, pkg.SourcePath, notTest, 0)
+				if err != nil {
+					return errors.Wrapf(err, "cannot locate the sources of package %s", pkg.Path)
+				}
+				files = make(map[string]*ast.File)
+				for _, p := range astPkgs {
+					for name, f := range p.Files {
+						files[name] = f
+					}
+				}
+			}
+			for _, f := range files {
+				if expr := fieldTypeExpr(f, t.Name.Name, m.Name); expr != nil {
+					t.Members[i].Type = resolveInvalidType(m.Type, expr, fileImports(f))
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// hasInvalidType determines if t is, or points to, holds or maps to, an
+// invalid type.
+func hasInvalidType(t *types.Type) bool {
+	for t.Kind == types.Pointer || t.Kind == types.Slice || t.Kind == types.Map {
+		t = t.Elem
+	}
+	return isInvalidType(t)
+}
+
+// fieldTypeExpr returns the type expression of the field of the struct type
+// typeName declared in f, or nil if f does not declare it.
+func fieldTypeExpr(f *ast.File, typeName, field string) ast.Expr {
+	for _, decl := range f.Decls {
+		gd, ok := decl.(*ast.GenDecl)
+		if !ok {
+			continue
+		}
+		for _, spec := range gd.Specs {
+			ts, ok := spec.(*ast.TypeSpec)
+			if !ok || ts.Name.Name != typeName {
+				continue
+			}
+			st, ok := ts.Type.(*ast.StructType)
+			if !ok {
+				return nil
+			}
+			for _, fl := range st.Fields.List {
+				for _, n := range fl.Names {
+					if n.Name == field {
+						return fl.Type
+					}
+				}
+				if len(fl.Names) == 0 && embeddedFieldName(fl.Type) == field {
+					return fl.Type
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// embeddedFieldName returns the name of the embedded field of type expr,
+// which is the name of its type.
+func embeddedFieldName(expr ast.Expr) string {
+	switch e := expr.(type) {
+	case *ast.StarExpr:
+		return embeddedFieldName(e.X)
+	case *ast.SelectorExpr:
+		return e.Sel.Name
+	case *ast.Ident:
+		return e.Name
+	}
+	return ""
+}
+
+// fileImports maps the names the imports of f are referred to by to their
+// paths. Unnamed imports are assumed to be named after their last element.
+func fileImports(f *ast.File) map[string]string {
+	out := make(map[string]string)
+	for _, imp := range f.Imports {
+		p, err := strconv.Unquote(imp.Path.Value)
+		if err != nil {
+			continue
+		}
+		name := path.Base(p)
+		if imp.Name != nil {
+			name = imp.Name.Name
+		}
+		out[name] = p
+	}
+	return out
+}
+
+// resolveInvalidType rebuilds t, whose type expression is expr, with its
+// invalid type replaced by a type named after the qualified identifier of
+// expr. The types of gengo are shared, so they are copied rather than
+// modified.
+func resolveInvalidType(t *types.Type, expr ast.Expr, imports map[string]string) *types.Type {
+	switch e := expr.(type) {
+	case *ast.StarExpr:
+		if t.Kind == types.Pointer {
+			return &types.Type{Name: t.Name, Kind: t.Kind, Elem: resolveInvalidType(t.Elem, e.X, imports)}
+		}
+		// pointers to invalid types are invalid types themselves.
+		return resolveInvalidType(t, e.X, imports)
+	case *ast.ArrayType:
+		if t.Kind == types.Slice {
+			return &types.Type{Name: t.Name, Kind: t.Kind, Elem: resolveInvalidType(t.Elem, e.Elt, imports)}
+		}
+	case *ast.MapType:
+		if t.Kind == types.Map {
+			return &types.Type{Name: t.Name, Kind: t.Kind, Key: t.Key, Elem: resolveInvalidType(t.Elem, e.Value, imports)}
+		}
+	case *ast.SelectorExpr:
+		if x, ok := e.X.(*ast.Ident); ok && isInvalidType(t) {
+			if p, ok := imports[x.Name]; ok {
+				return &types.Type{Name: types.Name{Package: p, Name: e.Sel.Name}, Kind: types.Unknown}
+			}
+		}
+	}
+	return t
+}
@@ -0,0 +1,308 @@
+package main
+
+import (
+	"go/token"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"k8s.io/gengo/types"
+)
+
+func TestFindTypeSources(t *testing.T) {
+	pkgs := testPackages(t, "foo/v1")
+	sources := findTypeSources(pkgs)
+	tests := []struct {
+		name string
+		line int
+	}{
+		{"Phase", 6},
+		{"PhaseA", 10},
+		{"Widget", 17},
+	}
+	for _, tt := range tests {
+		var typ *types.Type
+		for _, p := range pkgs {
+			for _, v := range append(p.Types, p.Constants...) {
+				if v.Name.Name == tt.name {
+					typ = v
+				}
+			}
+		}
+		pos, ok := sources[typ]
+		if !ok {
+			t.Errorf("no source for %s", tt.name)
+			continue
+		}
+		if filepath.Base(pos.Filename) != "types.go" || pos.Line != tt.line {
+			t.Errorf("source of %s = %s:%d, want types.go:%d", tt.name, pos.Filename, pos.Line, tt.line)
+		}
+	}
+}
+
+func TestSourceLink(t *testing.T) {
+	abs, err := filepath.Abs(filepath.Join(testAPIDir, "apis", "foo", "v1", "types.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pos := token.Position{Filename: abs, Line: 17}
+	tests := []struct {
+		apiDir string
+		want   string
+	}{
+		{"./testdata/fx/apis", "foo/v1/types.go:17"},
+		{"./testdata/fx/apis/foo/v1", "types.go:17"},
+		{"./testdata/fx/apis/foo/v1/types.go", "types.go:17"},
+		// import paths are not directories to be relative to.
+		{"example.com/fx/apis/...", filepath.ToSlash(abs) + ":17"},
+	}
+	for _, tt := range tests {
+		if got := sourceLink(pos, tt.apiDir); got != tt.want {
+			t.Errorf("sourceLink(%s) = %q, want %q", tt.apiDir, got, tt.want)
+		}
+	}
+}
+
+func TestEmitSourceLinks(t *testing.T) {
+	apiDir := *flAPIDir
+	defer func() { *flAPIDir = apiDir }()
+	*flAPIDir = "./testdata/fx/apis"
+
+	pkgs := testPackages(t, "foo/v1")
+	link := "// source: foo/v1/types.go:17\n/**\n* Widget is a widget."
+	for _, emit := range []bool{false, true} {
+		c := testConfig()
+		c.EmitSourceLinks = emit
+		out := renderTemplate(t, "packages", pkgs, c)
+		if got := strings.Contains(out, link); got != emit {
+			t.Errorf("emitSourceLinks=%v: source link rendered = %v", emit, got)
+		}
+		if !emit && strings.Contains(out, "// source:") {
+			t.Errorf("emitSourceLinks=false: source links rendered")
+		}
+	}
+}
+
+// writeTree writes files, keyed by their slash separated path, to a temporary
+// directory and changes into it for the rest of the test.
+func writeTree(t *testing.T, files map[string]string) {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "tree")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, s := range files {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(p, []byte(s), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		os.Chdir(wd)
+		os.RemoveAll(dir)
+	})
+}
+
+func TestCheckParse(t *testing.T) {
+	writeTree(t, map[string]string{
+		"good/v1/types.go":      "package v1\n\ntype Good struct{}\n",
+		"good/v1/types_test.go": "package v1\n\nfunc {\n",
+		"good/vendor/x/x.go":    "package x\n\nfunc {\n",
+		"good/testdata/x.go":    "package x\n\nfunc {\n",
+		"bad/v1/types.go":       "package v1\n\ntype Good struct{}\n",
+		"bad/v1/broken.go":      "package v1\n\ntype Broken struct {\n",
+		"bad/v2/types.go":       "package v2\n\nfunc {\n",
+		"bad/v1/notes.txt":      "func {",
+	})
+	tests := []struct {
+		apiDir  string
+		wantErr string
+	}{
+		{"./good", ""},
+		{"./good/v1", ""},
+		{"./good/v1/types.go", ""},
+		{"./bad/v1/types.go", ""},
+		{"./bad", "2 file(s) failed to parse"},
+		{"./bad/v1", "broken.go"},
+		{"./bad/v1/broken.go", "1 file(s) failed to parse"},
+		{"./bad/v1/notes.txt", "neither a directory nor a Go source file"},
+	}
+	for _, tt := range tests {
+		err := checkParse(tt.apiDir)
+		if tt.wantErr == "" {
+			if err != nil {
+				t.Errorf("checkParse(%s) = %v, want no error", tt.apiDir, err)
+			}
+		} else if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("checkParse(%s) = %v, want an error containing %q", tt.apiDir, err, tt.wantErr)
+		}
+	}
+}
+
+func TestAPIFile(t *testing.T) {
+	tests := []struct {
+		apiDir    string
+		dir, file string
+		wantErr   bool
+	}{
+		{"./testdata/fx/apis", "./testdata/fx/apis", "", false},
+		{"example.com/fx/apis/...", "example.com/fx/apis/...", "", false},
+		{"./testdata/fx/apis/foo/v1/types.go", "./testdata/fx/apis/foo/v1", "./testdata/fx/apis/foo/v1/types.go", false},
+		// keep the directory a local path, not an import path.
+		{"testdata/fx/apis/foo/v1/types.go", "./testdata/fx/apis/foo/v1", "testdata/fx/apis/foo/v1/types.go", false},
+		{"testdata/fx/go.mod", "", "", true},
+	}
+	for _, tt := range tests {
+		dir, file, err := apiFile(tt.apiDir)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("apiFile(%s) error = %v, want error %v", tt.apiDir, err, tt.wantErr)
+			continue
+		}
+		if dir != filepath.FromSlash(tt.dir) || file != filepath.FromSlash(tt.file) {
+			t.Errorf("apiFile(%s) = %q, %q, want %q, %q", tt.apiDir, dir, file, tt.dir, tt.file)
+		}
+	}
+}
+
+func TestParseAPIFile(t *testing.T) {
+	writeTree(t, map[string]string{
+		"go.mod":      "module example.com/one\n",
+		"v1/doc.go":   "// +groupName=one.example.com\npackage v1\n",
+		"v1/a.go":     "package v1\n\ntype A struct {\n\tB B `json:\"b\"`\n}\n\ntype Mode string\n\nconst ModeFast Mode = \"fast\"\n",
+		"v1/b.go":     "package v1\n\ntype B struct{}\n\nconst ModeSlow Mode = \"slow\"\n",
+		"v2/doc.go":   "// +groupName=one.example.com\npackage v2\n",
+		"v2/types.go": "package v2\n\ntype C struct{}\n",
+	})
+	tests := []struct {
+		apiDir    string
+		types     []string
+		constants []string
+	}{
+		{"./v1", []string{"A", "B", "Mode"}, []string{"ModeFast", "ModeSlow"}},
+		{"./v1/a.go", []string{"A", "Mode"}, []string{"ModeFast"}},
+		{"./v1/b.go", []string{"B"}, []string{"ModeSlow"}},
+	}
+	for _, tt := range tests {
+		pkgs, err := parseAPIPackages(tt.apiDir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(pkgs) != 1 {
+			t.Fatalf("parseAPIPackages(%s) = %d packages, want 1", tt.apiDir, len(pkgs))
+		}
+		var typeNames, constNames []string
+		for name := range pkgs[0].Types {
+			typeNames = append(typeNames, name)
+		}
+		for name := range pkgs[0].Constants {
+			constNames = append(constNames, name)
+		}
+		sort.Strings(typeNames)
+		sort.Strings(constNames)
+		if !reflect.DeepEqual(typeNames, tt.types) || !reflect.DeepEqual(constNames, tt.constants) {
+			t.Errorf("parseAPIPackages(%s) = %v and %v, want %v and %v", tt.apiDir, typeNames, constNames, tt.types, tt.constants)
+		}
+	}
+}
+
+func TestIncludeVendor(t *testing.T) {
+	writeTree(t, map[string]string{
+		"go.mod":                             "module example.com/two\n",
+		"doc.go":                             "package two\n",
+		"v1/doc.go":                          "// +groupName=two.example.com\npackage v1\n",
+		"v1/types.go":                        "package v1\n\ntype A struct{}\n",
+		"vendor/example.com/dep/v1/doc.go":   "// +groupName=dep.example.com\npackage v1\n",
+		"vendor/example.com/dep/v1/types.go": "package v1\n\ntype Dep struct{}\n",
+		// vendored packages still need a +groupName.
+		"vendor/example.com/util/util.go": "package util\n\ntype Util struct{}\n",
+	})
+	defer func(v bool) { *flIncludeVendor = v }(*flIncludeVendor)
+	tests := []struct {
+		includeVendor bool
+		want          []string
+		warnings      int
+	}{
+		{false, []string{"example.com/two/v1"}, 1},
+		{true, []string{"example.com/dep/v1", "example.com/two/v1"}, 0},
+	}
+	for _, tt := range tests {
+		resetRun()
+		*flIncludeVendor = tt.includeVendor
+		pkgs, err := parseAPIPackages("example.com/two")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, p := range pkgs {
+			got = append(got, p.Path)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("-include-vendor=%v: packages = %v, want %v", tt.includeVendor, got, tt.want)
+		}
+		if n := len(report.warnings[warnVendorSkip]); n != tt.warnings {
+			t.Errorf("-include-vendor=%v: %d vendor skip warnings, want %d", tt.includeVendor, n, tt.warnings)
+		}
+	}
+}
+
+func TestExcludeFiles(t *testing.T) {
+	tests := []struct {
+		patterns  []string
+		types     []string
+		constants []string
+	}{
+		{nil, []string{"A", "Extra", "Mode"}, []string{"ModeExtra", "ModeFast"}},
+		{[]string{"zz_generated.*.go"}, []string{"A", "Mode"}, []string{"ModeFast"}},
+		{[]string{"types.go"}, []string{"Extra"}, []string{"ModeExtra"}},
+		{[]string{"other.go", "zz_*.go"}, []string{"A", "Mode"}, []string{"ModeFast"}},
+		// test files are never parsed in the first place.
+		{[]string{"*_test.go"}, []string{"A", "Extra", "Mode"}, []string{"ModeExtra", "ModeFast"}},
+	}
+	for _, tt := range tests {
+		writeTree(t, map[string]string{
+			"go.mod":                   "module example.com/three\n",
+			"v1/doc.go":                "// +groupName=three.example.com\npackage v1\n",
+			"v1/types.go":              "package v1\n\ntype A struct{}\n\ntype Mode string\n\nconst ModeFast Mode = \"fast\"\n",
+			"v1/zz_generated.extra.go": "package v1\n\ntype Extra struct{}\n\nconst ModeExtra Mode = \"extra\"\n",
+			"v1/types_test.go":         "package v1\n\ntype Fixture struct{}\n",
+		})
+		pkgs, err := parseAPIPackages("./v1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := excludeFiles(pkgs[0], tt.patterns); err != nil {
+			t.Fatal(err)
+		}
+		var typeNames, constNames []string
+		for name := range pkgs[0].Types {
+			typeNames = append(typeNames, name)
+		}
+		for name := range pkgs[0].Constants {
+			constNames = append(constNames, name)
+		}
+		sort.Strings(typeNames)
+		sort.Strings(constNames)
+		if !reflect.DeepEqual(typeNames, tt.types) || !reflect.DeepEqual(constNames, tt.constants) {
+			t.Errorf("excludeFiles(%q) left %v and %v, want %v and %v", tt.patterns, typeNames, constNames, tt.types, tt.constants)
+		}
+	}
+
+	c := generatorConfig{ExcludeFilePatterns: []string{"zz_[.go"}}
+	if err := c.validate(); err == nil || !strings.Contains(err.Error(), `invalid excludeFilePatterns entry "zz_[.go"`) {
+		t.Errorf("validate() = %v, want an invalid excludeFilePatterns error", err)
+	}
+}
@@ -1,16 +1,41 @@
 {{ define "members" }}
 
-  {{ range .Members }}
+  {{ range (sortedMembers .) }}
     {{ if not (hiddenMember .)}}
       {{ if not (fieldEmbedded .) }}
-        {{ if hasComments .CommentLines }}
+        {{ $docs := memberDocs . }}
+        {{ $see := externalTypeDocsURL .Type }}
+        {{ $format := jsdocFormat . }}
+        {{ $keyNote := mapKeyNote . }}
+        {{ $example := memberExample . }}
+        {{ if or (hasComments $docs) $see $format $keyNote $example }}
         /**
-         {{ range .CommentLines }}
+         {{ if hasComments $docs }}
+         {{ range wrapComments $docs }}
          * {{ . }}
          {{ end }}
+         {{ end }}
+         {{ if $keyNote }}
+         * {{ $keyNote }}
+         {{ end }}
+         {{ if $format }}
+         * @format {{ $format }}
+         {{ end }}
+         {{ if $example }}
+         * @example
+         * ```
+         {{ range $example }}
+         * {{ . }}
+         {{ end }}
+         * ```
+         {{ end }}
+         {{ if $see }}
+         * @see {{ $see }}
+         {{ end }}
          */
         {{ end }}
-        {{ fieldName . }}{{ if isOptionalMember . }}?{{ end }}: {{ typeDisplayName .Type }};
+        {{ $optional := and (isOptionalMember .) (ne config.OptionalStyle "undefined-union") }}
+        {{ fieldName . }}{{ if $optional }}?{{ end }}: {{ memberType . }};
       {{ end }}
     {{ end }}
   {{ end }}
@@ -1,36 +1,61 @@
 {{ define "packages" }}
+        {{ enumsImport }}
 
-        type ObjectMetadata = {
+        {{ declare }}type ObjectMetadata = {
           name: string;
           resourceVersion: string;
           labels: Record<string, string>;
         }
 
         {{- range .packages -}}
+          {{ renderPackage . }}
+        {{ end }}
+
+        export {{ declare }}type CustomResourceKinds = keyof ResourceDefinitions;
+
+        export {{ declare }}type CustomResources<
+          K extends CustomResourceKinds
+        > = ResourceDefinitions[K];
+
+        {{ if config.EmitKindRegistry }}
+        export {{ declare }}interface KindRegistry {
+          {{ range kindRegistry }}
+          '{{ .Kind }}': {{ .Type }};
+          {{ end }}
+        }
+        {{ end }}
+{{ end }}
+
+{{ define "enums" }}
+        {{- range .packages -}}
+          {{ range (visibleTypes (sortedTypes .Types)) }}
+              {{ if isEnum . }}
+              {{ template "type" . }}
+              {{ end }}
+          {{ end }}
+        {{ end }}
+{{ end }}
+
+{{ define "package" }}
           {{ range (visibleTypes (sortedTypes .Types))}}
+              {{ if not (inEnumsFile .) }}
               {{ template "type" .  }}
+              {{ end }}
           {{ end }}
 
 
-        export type CustomResourceDefinition<T extends { metadata: unknown; spec: unknown; status: unknown}> = {
+        export {{ declare }}type CustomResourceDefinition<Metadata, Spec, Status> = {
           apiVersion: string;
-          metadata: T['metadata'];
-          spec: T['spec'];
-          status: T['status'];
+          metadata: Metadata;
+          spec: Spec;
+          status: Status;
         }
 
-        export type ResourceDefinitions = {
+        export {{ declare }}type ResourceDefinitions = {
            {{ range (visibleTypes (sortedTypes .Types)) }}
                {{ if isExportedType . }}
-                    '{{ typeDisplayName . }}': CustomResourceDefinition<{{ typeDisplayName . }}>;
+                    '{{ typeDisplayName . }}': CustomResourceDefinition<{{ rootFieldType . "metadata" }}, {{ rootFieldType . "spec" }}, {{ rootFieldType . "status" }}>;
                {{ end }}
            {{ end }}
         }
-        {{ end }}
-
-        export type CustomResourceKinds = keyof ResourceDefinitions;
-
-        export type CustomResources<
-          K extends CustomResourceKinds
-        > = ResourceDefinitions[K];
 {{ end }}
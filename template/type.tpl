@@ -1,20 +1,63 @@
 {{ define "type" }}
+{{ if config.EmitSourceLinks }}{{ with typeSource . }}
+// source: {{ . }}
+{{ end }}{{ end }}
 
-{{ if hasComments .CommentLines }}
+{{ $meta := config.EmitTypeMetadataTags }}
+{{ $table := enumDocTable . }}
+{{ if or (hasComments .CommentLines) config.GroupByGroupOnly $meta $table }}
 /**
- {{ range .CommentLines }}
+ {{ if hasComments .CommentLines }}
+ {{ range wrapComments .CommentLines }}
  * {{ . }}
  {{ end }}
+ {{ end }}
+ {{ if and $table (hasComments .CommentLines) }}
+ *
+ {{ end }}
+ {{ range $table }}
+ * {{ . }}
+ {{ end }}
+ {{ if $meta }}{{ with typeGroup . }}
+ * @group {{ . }}
+ {{ end }}{{ end }}
+ {{ if or config.GroupByGroupOnly $meta }}
+ * @version {{ typeVersion . }}
+ {{ end }}
  */
 {{ end }}
-{{ if eq .Kind "Alias" }}
-export type {{ .Name.Name }} = {{ if eq (constantsType .) "" }} {{ .Underlying }} {{ else }}{{ constantsType . }}{{ end }};
+{{ if and (eq .Kind "Alias") (eq enumStyle "asconst") (isEnum .) }}
+export const {{ typeName . }}Values = {
+  {{ range constantsOfType . }}
+  {{ if config.EnumMemberComments }}{{ renderComments .CommentLines }}{{ end }}
+  {{ .Name.Name }}: {{ constantValue . }},
+  {{ end }}
+} as const;
+export {{ declare }}type {{ typeName . }} = typeof {{ typeName . }}Values[keyof typeof {{ typeName . }}Values];
+{{ else if isUnion . }}
+export {{ declare }}type {{ typeName . }} = {{ range $i, $t := unionTypes . }}{{ if $i }} | {{ end }}{{ typeDisplayName $t }}{{ else }}unknown{{ end }};
+{{ else if eq .Kind "Alias" }}
+export {{ declare }}type {{ typeName . }}{{ typeParams . }} = {{ aliasDisplayName . }};
+{{ else if asInterface . }}
+export {{ declare }}interface {{ typeName . }}{{ typeParams . }}{{ with interfaceExtends . }} extends {{ . }}{{ end }} {
+  {{ template "body" . }}
+}
 {{ else }}
-export type {{ .Name.Name }} = {
+export {{ declare }}type {{ typeName . }}{{ typeParams . }} = {
+  {{ template "body" . }}
+} {{ if hasEmbeddedTypes . }}{{ range embeddedTypes . }}{{ if not (hiddenMember .) }} & {{ embeddedDisplayName .Type }}{{ end }}{{ end }}{{ end }}{{- print ";" }}
+{{ end }}
+{{ println " " }}
+{{ end }}
+
+{{ define "body" }}
+  {{ range kindLiterals . }}
+  {{ . }}
+  {{ end }}
   {{ if .Members }}
   {{ template "members" .}}
   {{ end }}
-} {{ if hasEmbeddedTypes . }}{{ range embeddedTypes . }}{{ if not (hiddenMember .) }} & {{ typeDisplayName .Type }}{{ end }}{{ end }}{{ end }}{{- print ";" }}
-{{ end }}
-{{ println " " }}
+  {{ range extraMembers . }}
+  {{ . }}
+  {{ end }}
 {{ end }}
@@ -0,0 +1,14 @@
+package v1
+
+// +kubebuilder:object:root=true
+
+// Gadget is a gadget.
+type Gadget struct {
+	Spec GadgetSpec `json:"spec"`
+}
+
+type GadgetSpec struct {
+	Count int32 `json:"count"`
+}
+
+type Mode string
@@ -0,0 +1,8 @@
+package v1
+
+import bar "example.com/fx/apis/bar/v1"
+
+const (
+	ModeFast bar.Mode = "fast"
+	ModeSlow bar.Mode = "slow"
+)
@@ -0,0 +1,2 @@
+// +groupName=bar.example.com
+package v1
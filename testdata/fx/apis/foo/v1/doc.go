@@ -0,0 +1,2 @@
+// +groupName=foo.example.com
+package v1
@@ -0,0 +1,230 @@
+package v1
+
+import metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+// Phase is the phase.
+type Phase string
+
+const (
+	// PhaseA is a.
+	PhaseA Phase = "A"
+	PhaseB Phase = "B"
+)
+
+// +kubebuilder:object:root=true
+
+// Widget is a widget.
+type Widget struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   WidgetSpec   `json:"spec,omitempty"`
+	Status WidgetStatus `json:"status,omitempty"`
+}
+
+// WidgetSpec is spec.
+type WidgetSpec struct {
+	// Size of it.
+	// +optional
+	Size  int32             `json:"size,omitempty"`
+	Names []string          `json:"names"`
+	Parts []*Part           `json:"parts"`
+	Grid  [][]string        `json:"grid"`
+	Idx   map[string][]Part `json:"idx"`
+	Phase Phase             `json:"phase"`
+	Ptr   *Part             `json:"ptr,omitempty"`
+	inner innerThing
+}
+
+type Part struct {
+	Name string `json:"name"`
+}
+
+// +gencrdrefdocs:force
+type innerThing struct {
+	X bool `json:"x"`
+}
+
+type WidgetStatus struct {
+	Ready bool `json:"ready"`
+}
+
+// +kubebuilder:object:root=true
+type WidgetList struct {
+	Items []Widget `json:"items"`
+}
+
+type Shapes struct {
+	A []*Part  `json:"a"`
+	B *[]Part  `json:"b"`
+	C [][]Part `json:"c"`
+	D []Part   `json:"d"`
+	E *[]*Part `json:"e"`
+}
+
+type MapShapes struct {
+	A map[string][]Part           `json:"a"`
+	B map[string]map[string]*Part `json:"b"`
+	C []map[string][]string       `json:"c"`
+	D map[string]int32            `json:"d"`
+}
+
+type Overrides struct {
+	// Raw is raw.
+	// +optional
+	// +ts:type={ foo: string }
+	Raw  []byte `json:"raw,omitempty"`
+	Name string `json:"name"`
+}
+
+// Level is a level.
+type Level int
+
+const (
+	LevelLow Level = iota
+	LevelMid
+	LevelHigh
+)
+
+type Leveled struct {
+	Level Level `json:"level"`
+}
+
+type Embeds struct {
+	Part
+	WidgetStatus `json:"status"`
+	Name         string `json:"name"`
+	internalNote string
+}
+
+// OldThing is old.
+//
+// Deprecated: use Part.
+type OldThing struct {
+	X string `json:"x"`
+}
+
+type UsesOld struct {
+	Old OldThing `json:"old"`
+}
+
+type KeyName string
+
+type KeyedMaps struct {
+	ByName map[KeyName]Part `json:"byName"`
+	Plain  map[string]Part  `json:"plain"`
+}
+
+type Count int32
+
+// Shape is a shape.
+// +ts:union
+type Shape interface {
+	Area() float64
+}
+
+// Picked is picked.
+// +ts:union=Circle,Square
+type Picked interface {
+	Area() float64
+}
+
+// Lonely has nothing.
+// +ts:union
+type Lonely interface {
+	Nope()
+}
+
+type Circle struct {
+	R int32 `json:"r"`
+}
+
+func (Circle) Area() float64 { return 0 }
+
+type Square struct {
+	S int32 `json:"s"`
+}
+
+func (*Square) Area() float64 { return 0 }
+
+type Holder struct {
+	Shape Shape `json:"shape"`
+}
+
+type Formats struct {
+	// +kubebuilder:validation:Format=date-time
+	When string `json:"when"`
+	// +kubebuilder:validation:Format=email
+	Mail *string `json:"mail"`
+	// +kubebuilder:validation:Format=weird
+	Odd string `json:"odd"`
+	// +kubebuilder:validation:Format=email
+	Num int32 `json:"num"`
+}
+
+// Renamed is renamed.
+// +ts:name=MyRenamed
+type Renamed struct {
+	X string `json:"x"`
+}
+
+// +ts:name=Ignored
+type Replaced struct {
+	X string `json:"x"`
+}
+
+type UsesRenamed struct {
+	R  Renamed    `json:"r"`
+	RS []Replaced `json:"rs"`
+}
+
+// +kubebuilder:object:root=false
+type RootFalse struct {
+	X string `json:"x"`
+}
+
+// // +kubebuilder:object:root=true
+type RootCommented struct {
+	X string `json:"x"`
+}
+
+// +kubebuilder:object:root=true
+type RootSpaced struct {
+	X string `json:"x"`
+}
+
+// +genclient
+type RootGenclient struct {
+	X string `json:"x"`
+}
+
+// Wordy has a very long comment line that goes on and on about nothing in particular, see {@link Part the part type} for more.
+// @deprecated this tag line is also long enough that it would need wrapping if it were prose text.
+type Wordy struct {
+	X string `json:"x"`
+}
+
+type helperThing struct {
+	Deep deeperThing `json:"deep"`
+}
+
+type deeperThing struct {
+	Y string `json:"y"`
+}
+
+type orphanThing struct {
+	Z string `json:"z"`
+}
+
+type UsesHelper struct {
+	H helperThing `json:"h"`
+}
+
+type OneOfs struct {
+	// +kubebuilder:validation:XIntOrString
+	Port string `json:"port"`
+	// +ts:oneof=Circle, Square,string
+	Any string `json:"any"`
+	// +ts:oneof=Renamed
+	Ren string `json:"ren"`
+}
@@ -0,0 +1,10 @@
+package v1alpha1
+
+type AlphaThing struct {
+	X string `json:"x"`
+}
+
+// Part is the part of an older apiVersion.
+type Part struct {
+	Legacy string `json:"legacy"`
+}
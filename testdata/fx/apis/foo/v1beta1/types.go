@@ -0,0 +1,7 @@
+package v1beta1
+
+import v1 "example.com/fx/apis/foo/v1"
+
+type BetaThing struct {
+	Part v1.Part `json:"part"`
+}
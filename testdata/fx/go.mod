@@ -0,0 +1,6 @@
+module example.com/fx
+
+go 1.15
+
+require k8s.io/apimachinery v0.0.0
+replace k8s.io/apimachinery => ./stub